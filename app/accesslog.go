@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// accessLogMu keeps the lines of concurrent requests from interleaving on writers that
+// aren't safe for concurrent use
+var accessLogMu sync.Mutex
+
+// LoggingMiddleware writes a line per request to w, or to os.Stdout when w is nil, with
+// the client's address, the request line, the response status and body size and how long
+// the handlers took
+func LoggingMiddleware(w io.Writer) Middleware {
+	if w == nil {
+		w = os.Stdout
+	}
+	return func(next Handler) Handler {
+		return HandlerFunc(func(req *Request) *Response {
+			// Rewrites change the path on the way down, the line shows the one the client sent
+			remoteAddr, line := req.RemoteAddr, requestLine(req)
+			started := time.Now()
+			response := next.Handle(req)
+			// Streamed responses are logged once they have been written
+			if response.dispatch == nil {
+				writeAccessLog(w, remoteAddr, line, statusCode(response.StatusLine), int64(len(response.Body)), time.Since(started))
+			}
+			return response
+		})
+	}
+}
+
+// accessLogMiddleware applies LoggingMiddleware with AccessLog, unless it is nil
+func (s *Server) accessLogMiddleware(next Handler) Handler {
+	return HandlerFunc(func(req *Request) *Response {
+		if s.AccessLog == nil {
+			return next.Handle(req)
+		}
+		return LoggingMiddleware(s.AccessLog)(next).Handle(req)
+	})
+}
+
+// logAccess writes the access log line of a response that didn't go through the
+// middleware chain, such as a streamed one
+func (s *Server) logAccess(req *Request, status int, size int64, elapsed time.Duration) {
+	if s.AccessLog != nil {
+		writeAccessLog(s.AccessLog, req.RemoteAddr, requestLine(req), status, size, elapsed)
+	}
+}
+
+// requestLine returns the request line as the client sent it
+func requestLine(req *Request) string {
+	return req.Method + " " + req.RequestURI() + " " + req.HTTPVersion
+}
+
+// writeAccessLog writes a single access log line
+func writeAccessLog(w io.Writer, remoteAddr, requestLine string, status int, size int64, elapsed time.Duration) {
+	accessLogMu.Lock()
+	defer accessLogMu.Unlock()
+	fmt.Fprintf(w, "%s \"%s\" %d %d %s\n", remoteAddr, requestLine, status, size, elapsed)
+}
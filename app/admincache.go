@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// handleCacheClear handles POST /admin/cache/clear, dropping every in-memory cache so
+// changes made to the directory by other processes are picked up. With ?path=/files/dir
+// only the listings and file metadata under that directory are dropped
+func (s *Server) handleCacheClear(req *Request) *Response {
+	if response := s.requireAdmin(req); response != nil {
+		return response
+	}
+
+	if req.Query.Has("path") {
+		if s.Directory == "" {
+			return textResponse(StatusBadRequest, "directory not specified")
+		}
+		name, ok := strings.CutPrefix(req.Query.Get("path"), "/files")
+		if !ok {
+			return textResponse(StatusBadRequest, "path must be under /files/")
+		}
+		fullPath, err := s.resolveFilePath(name)
+		if err != nil {
+			return textResponse(StatusBadRequest, err.Error())
+		}
+		s.dirCache.InvalidateTree(fullPath)
+		s.stats.InvalidateTree(fullPath)
+		fmt.Println("Cleared caches under", fullPath)
+	} else {
+		s.dirCache.Clear()
+		s.stats.Clear()
+		s.deltaBases.clear()
+		s.Router.clearCaches()
+		fmt.Println("Cleared all caches")
+	}
+
+	s.audit(req, "cache.clear", StatusNoContent)
+	return &Response{
+		StatusLine: StatusNoContent,
+		Headers:    make(map[string]string),
+	}
+}
+
+// underDir reports whether path is dir or inside it
+func underDir(path, dir string) bool {
+	dir = filepath.Clean(dir)
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// clearCache sends POST /admin/cache/clear with the query and authorization header
+func clearCache(t *testing.T, addr, query, authorization string) int {
+	t.Helper()
+	request := "POST /admin/cache/clear" + query + " HTTP/1.1\r\nHost: localhost\r\nContent-Length: 0\r\nConnection: close\r\n"
+	if authorization != "" {
+		request += "Authorization: " + authorization + "\r\n"
+	}
+	response, _ := roundTrip(t, addr, request+"\r\n")
+	return response.StatusCode
+}
+
+// listedNames returns the names in the directory listing
+func listedNames(t *testing.T, addr string) []string {
+	t.Helper()
+	page, _ := getFileList(t, addr, "")
+	var names []string
+	for _, f := range page.Files {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+func TestAdminCacheClear(t *testing.T) {
+	for _, query := range []string{"", "?path=/files/"} {
+		t.Run("query="+query, func(t *testing.T) {
+			s := newTestServer(t)
+			s.AdminToken = "secret"
+			if err := os.WriteFile(filepath.Join(s.Directory, "a.txt"), []byte("a"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			addr := startTestServer(t, s)
+
+			if names := listedNames(t, addr); len(names) != 1 {
+				t.Fatalf("got listing %v, want [a.txt]", names)
+			}
+
+			// A file added behind the server's back isn't listed until the cache is cleared
+			if err := os.WriteFile(filepath.Join(s.Directory, "b.txt"), []byte("b"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if names := listedNames(t, addr); len(names) != 1 {
+				t.Fatalf("got listing %v before clearing the cache, want the cached [a.txt]", names)
+			}
+			if status := clearCache(t, addr, query, "Bearer secret"); status != 204 {
+				t.Fatalf("got status %d, want 204", status)
+			}
+			if names := listedNames(t, addr); len(names) != 2 {
+				t.Fatalf("got listing %v after clearing the cache, want [a.txt b.txt]", names)
+			}
+		})
+	}
+}
+
+func TestAdminCacheClearErrors(t *testing.T) {
+	s := newTestServer(t)
+	s.AdminToken = "secret"
+	addr := startTestServer(t, s)
+
+	tests := []struct {
+		query, authorization string
+		want                 int
+	}{
+		{"", "", 401},
+		{"", "Bearer wrong", 401},
+		{"?path=/other", "Bearer secret", 400},
+		{"?path=/files/../..", "Bearer secret", 400},
+	}
+	for _, tt := range tests {
+		if status := clearCache(t, addr, tt.query, tt.authorization); status != tt.want {
+			t.Errorf("%q with %q: got status %d, want %d", tt.query, tt.authorization, status, tt.want)
+		}
+	}
+}
+
+func TestAdminCacheClearDisabled(t *testing.T) {
+	addr := startTestServer(t, newTestServer(t))
+	if status := clearCache(t, addr, "", "Bearer "); status != 404 {
+		t.Errorf("got status %d without an admin token configured, want 404", status)
+	}
+}
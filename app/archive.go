@@ -0,0 +1,306 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// archiveJobRetention is how long a finished archive job can still be looked up
+const archiveJobRetention = time.Hour
+
+// archiveJob tracks a tar.gz archive being written in the background
+type archiveJob struct {
+	ID        string
+	Source    string
+	Output    string
+	StartedAt time.Time
+	Files     atomic.Int64
+	Bytes     atomic.Int64
+
+	mu         sync.Mutex
+	status     string
+	err        string
+	finishedAt time.Time
+}
+
+// archiveRequest is the JSON body of POST /files/archive
+type archiveRequest struct {
+	Source string `json:"source"`
+	Output string `json:"output"`
+}
+
+// archiveJobStatus is the JSON progress report of an archive job
+type archiveJobStatus struct {
+	ID         string     `json:"id"`
+	Source     string     `json:"source"`
+	Output     string     `json:"output"`
+	Status     string     `json:"status"`
+	Files      int64      `json:"files"`
+	Bytes      int64      `json:"bytes"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// handleArchive handles /files/archive: POST starts an archive job, GET ?job=<id> reports
+// its progress; anything else is treated as a file named "archive"
+func (s *Server) handleArchive(req *Request) *Response {
+	if req.Method == "POST" && s.Directory != "" {
+		response := s.startArchive(req)
+		s.audit(req, "archive.create", response.StatusLine)
+		return response
+	}
+	if req.Method == "GET" && req.Query.Has("job") {
+		return s.archiveProgress(req.Query.Get("job"))
+	}
+	return s.handleFiles(req)
+}
+
+// startArchive validates the request, creates the output file and archives the source into it
+// in the background
+func (s *Server) startArchive(req *Request) *Response {
+	var body archiveRequest
+	if err := json.Unmarshal(req.Body, &body); err != nil {
+		return textResponse(StatusBadRequest, "invalid JSON body: "+err.Error())
+	}
+	if body.Output == "" {
+		return textResponse(StatusBadRequest, "output is required")
+	}
+	source, err := s.resolveFilePath(body.Source)
+	if err != nil {
+		return textResponse(StatusBadRequest, err.Error())
+	}
+	output, err := s.resolveFilePath(body.Output)
+	if err != nil {
+		return textResponse(StatusBadRequest, err.Error())
+	}
+
+	info, err := os.Stat(source)
+	if err != nil || !info.IsDir() {
+		return textResponse(StatusNotFound, "source directory not found: "+body.Source)
+	}
+
+	// A directory already over its quota has no room for the archive
+	if s.DirectoryQuotaBytes > 0 {
+		if err := s.reserveQuota(0); errors.Is(err, errQuotaExceeded) {
+			return &Response{StatusLine: StatusInsufficientStorage, Headers: make(map[string]string)}
+		} else if err != nil {
+			fmt.Println("Error checking the directory quota:", err)
+			return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+		}
+	}
+
+	// Create the output now so a conflict is reported to the client rather than the job
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		fmt.Println("Error creating directory:", err)
+		return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+	}
+	file, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, fs.ErrExist) {
+		return textResponse(StatusConflict, "output already exists: "+body.Output)
+	} else if err != nil {
+		fmt.Println("Error creating archive:", err)
+		return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+	}
+
+	job := &archiveJob{
+		ID:        newJobID(),
+		Source:    body.Source,
+		Output:    body.Output,
+		StartedAt: time.Now(),
+		status:    "running",
+	}
+	s.archiveJobs.Store(job.ID, job)
+	s.invalidateDirs(output)
+	go func() {
+		counted := &quotaWriter{s: s, w: file}
+		if err := job.run(file, counted, source, output); err != nil {
+			s.usage.release(counted.reserved)
+		}
+		s.invalidateDirs(output)
+		time.AfterFunc(archiveJobRetention, func() {
+			s.archiveJobs.Delete(job.ID)
+		})
+	}()
+
+	statusURL := "/files/archive?job=" + job.ID
+	content, _ := json.Marshal(map[string]string{"job": job.ID, "status_url": statusURL})
+	return &Response{
+		StatusLine: StatusAccepted,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+			"Location":     statusURL,
+		},
+		Body: content,
+	}
+}
+
+// archiveProgress reports the progress of an archive job, until archiveJobRetention after it finished
+func (s *Server) archiveProgress(id string) *Response {
+	value, ok := s.archiveJobs.Load(id)
+	if !ok {
+		return textResponse(StatusNotFound, "unknown archive job: "+id)
+	}
+	content, err := json.Marshal(value.(*archiveJob).snapshot())
+	if err != nil {
+		fmt.Println("Error encoding archive job:", err)
+		return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+	}
+	return &Response{
+		StatusLine: StatusOK,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       content,
+	}
+}
+
+// run writes the archive, streaming every file through tar and gzip into w, which writes
+// to the output file, returning why the job failed
+func (job *archiveJob) run(file *os.File, w io.Writer, source, output string) error {
+	err := job.write(w, source, output)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.finishedAt = time.Now()
+	if err != nil {
+		fmt.Println("Error writing archive:", err)
+		os.Remove(output)
+		job.status = "failed"
+		job.err = err.Error()
+		return err
+	}
+	fmt.Printf("Archived %s into %s: %d files, %d bytes\n", job.Source, job.Output, job.Files.Load(), job.Bytes.Load())
+	job.status = "done"
+	return nil
+}
+
+// write archives every regular file and directory under source
+func (job *archiveJob) write(w io.Writer, source, output string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	err := filepath.WalkDir(source, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// The archive may be written inside the directory it archives
+		if path == source || path == output {
+			return nil
+		}
+		name, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		// Nor are the server's own files, when archiving the whole directory
+		if isInternalPath(filepath.Join(job.Source, name)) {
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		n, err := io.Copy(tw, f)
+		job.Bytes.Add(n)
+		job.Files.Add(1)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// snapshot returns the current progress of the job
+func (job *archiveJob) snapshot() archiveJobStatus {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	status := archiveJobStatus{
+		ID:        job.ID,
+		Source:    job.Source,
+		Output:    job.Output,
+		Status:    job.status,
+		Files:     job.Files.Load(),
+		Bytes:     job.Bytes.Load(),
+		Error:     job.err,
+		StartedAt: job.StartedAt,
+	}
+	if !job.finishedAt.IsZero() {
+		finishedAt := job.finishedAt
+		status.FinishedAt = &finishedAt
+	}
+	return status
+}
+
+// resolveFilePath turns a path relative to the directory into a full path, rejecting
+// paths that would leave the directory
+func (s *Server) resolveFilePath(name string) (string, error) {
+	if strings.Contains(name, "..") {
+		return "", fmt.Errorf("invalid path (directory traversal): %s", name)
+	}
+	if isInternalPath(name) {
+		return "", fmt.Errorf("invalid path (reserved by the server): %s", name)
+	}
+	return filepath.Join(s.Directory, filepath.Clean("/"+name)), nil
+}
+
+// newJobID returns a random identifier for a background job
+func newJobID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// textResponse builds a plain text response
+func textResponse(statusLine, message string) *Response {
+	return &Response{
+		StatusLine: statusLine,
+		Headers:    make(map[string]string),
+		Body:       []byte(message),
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// archive starts an archive job of source into output and waits for it to finish
+func archive(t *testing.T, s *Server, source, output string) archiveJobStatus {
+	t.Helper()
+	body, _ := json.Marshal(archiveRequest{Source: source, Output: output})
+	req := fileRequest("POST", "archive", string(body))
+	response := s.handleArchive(req)
+	expectStatus(t, response, 202)
+	var started struct{ Job string }
+	if err := json.Unmarshal(response.Body, &started); err != nil {
+		t.Fatal(err)
+	}
+
+	var status archiveJobStatus
+	waitFor(t, "the archive job to finish", func() bool {
+		response := s.archiveProgress(started.Job)
+		if err := json.Unmarshal(response.Body, &status); err != nil {
+			t.Fatal(err)
+		}
+		return status.Status != "running"
+	})
+	return status
+}
+
+func TestArchiveSkipsInternalFiles(t *testing.T) {
+	s := newTestServer(t)
+	expectStatus(t, s.handleFiles(fileRequest("POST", "a.txt", "hello")), 201)
+	if err := os.MkdirAll(filepath.Join(s.Directory, ".versions"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(s.Directory, ".versions", "a.txt"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := archive(t, s, "", "out.tar.gz"); status.Status != "done" {
+		t.Fatalf("got job %+v, want it done", status)
+	}
+
+	file, err := os.Open(filepath.Join(s.Directory, "out.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	var names []string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+	}
+	if strings.Join(names, ",") != "a.txt" {
+		t.Errorf("got archive entries %v, want only a.txt", names)
+	}
+}
+
+func TestArchiveCountsAgainstTheQuota(t *testing.T) {
+	s := newTestServer(t)
+	s.DirectoryQuotaBytes = 1 << 20
+	// Random bytes don't compress, the archive is about as large as the file
+	random := make([]byte, 600<<10)
+	rand.Read(random)
+	if err := os.MkdirAll(filepath.Join(s.Directory, "src"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(s.Directory, "src", "random.bin"), random, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	status := archive(t, s, "src", "out.tar.gz")
+	if status.Status != "failed" || status.Error != errQuotaExceeded.Error() {
+		t.Fatalf("got job %+v, want it failed for the quota", status)
+	}
+	if _, err := os.Stat(filepath.Join(s.Directory, "out.tar.gz")); !os.IsNotExist(err) {
+		t.Errorf("the failed archive was left behind: %v", err)
+	}
+
+	// The failed job gave its bytes back, and a successful one keeps them
+	s.DirectoryQuotaBytes = 2 << 20
+	if status := archive(t, s, "src", "out.tar.gz"); status.Status != "done" {
+		t.Fatalf("got job %+v, want it done", status)
+	}
+	info, err := os.Stat(filepath.Join(s.Directory, "out.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	left := s.DirectoryQuotaBytes - int64(len(random)) - info.Size()
+	expectStatus(t, s.handleFiles(fileRequest("POST", "b.bin", strings.Repeat("b", int(left)+1))), 507)
+	expectStatus(t, s.handleFiles(fileRequest("POST", "b.bin", strings.Repeat("b", int(left)))), 201)
+
+	// An archive can't start in a directory already over its quota
+	s.DirectoryQuotaBytes = 1
+	body, _ := json.Marshal(archiveRequest{Source: "src", Output: "other.tar.gz"})
+	expectStatus(t, s.handleArchive(fileRequest("POST", "archive", string(body))), 507)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"time"
+)
+
+// auditEntry is the content of a single audit log line
+type auditEntry struct {
+	Action     string `json:"action"`
+	Path       string `json:"path"`
+	User       string `json:"user"`
+	RemoteAddr string `json:"remote_addr"`
+	Result     int    `json:"result"`
+	Timestamp  string `json:"timestamp"`
+}
+
+// audit records a sensitive operation in the audit log, if one is configured
+func (s *Server) audit(req *Request, action, statusLine string) {
+	if s.AuditLog == nil {
+		return
+	}
+
+	entry := auditEntry{
+		Action:     action,
+		Path:       req.Path,
+		User:       s.auditUser(req),
+		RemoteAddr: req.RemoteAddr,
+		Result:     statusCode(statusLine),
+		Timestamp:  time.Now().UTC().Format(time.RFC3339Nano),
+	}
+
+	attrs := []slog.Attr{
+		slog.String("action", entry.Action),
+		slog.String("path", entry.Path),
+		slog.String("user", entry.User),
+		slog.String("remote_addr", entry.RemoteAddr),
+		slog.Int("result", entry.Result),
+		slog.String("timestamp", entry.Timestamp),
+	}
+
+	// Sign the entry so tampering with a logged line can be detected
+	if s.AuditHMAC != "" {
+		payload, _ := json.Marshal(entry)
+		mac := hmac.New(sha256.New, []byte(s.AuditHMAC))
+		mac.Write(payload)
+		attrs = append(attrs, slog.String("hmac", hex.EncodeToString(mac.Sum(nil))))
+	}
+
+	s.AuditLog.LogAttrs(context.Background(), slog.LevelInfo, "audit", attrs...)
+}
+
+// auditUser returns the identity of the client, as far as the server knows it
+func (s *Server) auditUser(req *Request) string {
+	if s.isAdmin(req) {
+		return "admin"
+	}
+	return "anonymous"
+}
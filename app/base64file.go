@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// handleFileBase64 serves a file as JSON with its content base64 encoded, for clients that
+// can't handle binary downloads
+func (s *Server) handleFileBase64(req *Request, fullPath string) *Response {
+	if encoding := req.Query.Get("encoding"); encoding != "base64" {
+		return textResponse(StatusBadRequest, fmt.Sprintf("unsupported encoding %q", encoding))
+	}
+
+	file, err := os.Open(fullPath)
+	if err != nil {
+		fmt.Println("Error opening file:", err)
+		return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+	}
+	defer file.Close()
+
+	// Encrypted files have to be decrypted whole, others are encoded as they are read
+	var content io.Reader = file
+	if len(s.EncryptionKey) > 0 {
+		encrypted, err := io.ReadAll(file)
+		if err == nil {
+			encrypted, err = decryptFile(s.EncryptionKey, encrypted)
+		}
+		if err != nil {
+			fmt.Println("Error decrypting file:", err)
+			return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+		}
+		content = bytes.NewReader(encrypted)
+	}
+
+	filename, _ := json.Marshal(filepath.Base(fullPath))
+	var body strings.Builder
+	fmt.Fprintf(&body, `{"filename":%s,"encoding":"base64","data":"`, filename)
+	encoder := base64.NewEncoder(base64.StdEncoding, &body)
+	size, err := io.Copy(encoder, content)
+	if err == nil {
+		err = encoder.Close()
+	}
+	if err != nil {
+		fmt.Println("Error reading file:", err)
+		return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+	}
+	fmt.Fprintf(&body, `","size":%d}`, size)
+
+	return &Response{
+		StatusLine: StatusOK,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       []byte(body.String()),
+	}
+}
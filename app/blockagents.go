@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// agentPattern matches User-Agent headers, either by a case-insensitive substring
+// or by a regex written between slashes, e.g. /bot\d+/
+type agentPattern struct {
+	source  string
+	regex   *regexp.Regexp
+	blocked *atomic.Int64
+}
+
+// parseAgentPatterns compiles the block list, skipping blank lines and # comments
+func parseAgentPatterns(lines []string) ([]agentPattern, error) {
+	patterns := make([]agentPattern, 0, len(lines))
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		pattern := agentPattern{source: line}
+		if len(line) > 2 && strings.HasPrefix(line, "/") && strings.HasSuffix(line, "/") {
+			regex, err := regexp.Compile(line[1 : len(line)-1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid user agent pattern %s: %w", line, err)
+			}
+			pattern.regex = regex
+		}
+		pattern.blocked = metrics.counter(fmt.Sprintf("blocked_requests_total{pattern=%q}", line))
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// matches reports whether the User-Agent matches the pattern
+func (p agentPattern) matches(userAgent string) bool {
+	if p.regex != nil {
+		return p.regex.MatchString(userAgent)
+	}
+	return strings.Contains(strings.ToLower(userAgent), strings.ToLower(p.source))
+}
+
+// blockedAgents is the current block list, optionally reloaded from a file when it changes
+type blockedAgents struct {
+	mu       sync.RWMutex
+	patterns []agentPattern
+	path     string
+	modTime  time.Time
+	notFound bool
+}
+
+// BlockedAgentsMiddleware answers 403 Forbidden to clients whose User-Agent matches one of
+// the patterns, each a case-insensitive substring or a regex between slashes
+func BlockedAgentsMiddleware(patterns []string) Middleware {
+	compiled, err := parseAgentPatterns(patterns)
+	if err != nil {
+		panic(err)
+	}
+	set := &blockedAgents{patterns: compiled}
+	return set.middleware
+}
+
+// BlockedAgentsFileMiddleware is BlockedAgentsMiddleware with one pattern per line of a file,
+// reloaded whenever the file changes. With notFound blocked clients get 404 Not Found instead,
+// so they can't tell they are blocked
+func BlockedAgentsFileMiddleware(path string, notFound bool) (Middleware, error) {
+	set := &blockedAgents{path: path, notFound: notFound}
+	if err := set.load(); err != nil {
+		return nil, err
+	}
+	return set.middleware, nil
+}
+
+// load reads the block list file
+func (set *blockedAgents) load() error {
+	info, err := os.Stat(set.path)
+	if err != nil {
+		return fmt.Errorf("failed to read blocked agents: %w", err)
+	}
+	content, err := os.ReadFile(set.path)
+	if err != nil {
+		return fmt.Errorf("failed to read blocked agents: %w", err)
+	}
+	patterns, err := parseAgentPatterns(strings.Split(string(content), "\n"))
+
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	set.modTime = info.ModTime()
+	if err != nil {
+		return err
+	}
+	set.patterns = patterns
+	return nil
+}
+
+// current returns the patterns, picking up changes to the block list file first
+func (set *blockedAgents) current() []agentPattern {
+	set.mu.RLock()
+	patterns, path, modTime := set.patterns, set.path, set.modTime
+	set.mu.RUnlock()
+
+	if path == "" {
+		return patterns
+	}
+	if info, err := os.Stat(path); err != nil || info.ModTime().Equal(modTime) {
+		return patterns
+	}
+
+	if err := set.load(); err != nil {
+		fmt.Println("Error reloading blocked agents, keeping the previous ones:", err)
+	} else {
+		fmt.Println("Reloaded blocked agents:", path)
+	}
+	set.mu.RLock()
+	defer set.mu.RUnlock()
+	return set.patterns
+}
+
+// middleware rejects requests from blocked user agents
+func (set *blockedAgents) middleware(next Handler) Handler {
+	return HandlerFunc(func(req *Request) *Response {
+		userAgent := req.Headers["user-agent"]
+		if userAgent == "" {
+			return next.Handle(req)
+		}
+
+		for _, pattern := range set.current() {
+			if !pattern.matches(userAgent) {
+				continue
+			}
+			pattern.blocked.Add(1)
+			statusLine := StatusForbidden
+			if set.notFound {
+				statusLine = StatusNotFound
+			}
+			return &Response{StatusLine: statusLine, Headers: make(map[string]string)}
+		}
+		return next.Handle(req)
+	})
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// bulkStatRequest is the JSON body of POST /files/?stat=1
+type bulkStatRequest struct {
+	Files []string `json:"files"`
+}
+
+// bulkStatResult is the metadata of one of the requested files
+type bulkStatResult struct {
+	Name   string     `json:"name"`
+	Exists bool       `json:"exists"`
+	Size   int64      `json:"size,omitempty"`
+	Mtime  *time.Time `json:"mtime,omitempty"`
+	IsDir  bool       `json:"is_dir,omitempty"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// handleBulkStat handles POST /files/?stat=1, returning the metadata of several files at once
+func (s *Server) handleBulkStat(req *Request) *Response {
+	var body bulkStatRequest
+	if err := json.Unmarshal(req.Body, &body); err != nil {
+		return textResponse(StatusBadRequest, "invalid JSON body: "+err.Error())
+	}
+	if s.MaxBulkStatFiles > 0 && len(body.Files) > s.MaxBulkStatFiles {
+		return textResponse(StatusBadRequest, fmt.Sprintf("at most %d files can be requested at once", s.MaxBulkStatFiles))
+	}
+
+	minimal := PreferReturnMinimal(req.Context())
+	results := make([]bulkStatResult, 0, len(body.Files))
+	for _, name := range body.Files {
+		result := bulkStatResult{Name: name}
+		fullPath, err := s.resolveFilePath(name)
+		if err != nil {
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+
+		if info, err := s.stats.Stat(fullPath); err == nil {
+			result.Exists = true
+			result.Size = info.Size()
+			if !minimal {
+				mtime := info.ModTime().UTC()
+				result.Mtime = &mtime
+				result.IsDir = info.IsDir()
+			}
+		} else if !os.IsNotExist(err) {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	content, err := json.Marshal(map[string]any{"results": results})
+	if err != nil {
+		fmt.Println("Error encoding stat results:", err)
+		return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+	}
+	return &Response{
+		StatusLine: StatusOK,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       content,
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// errRangeNotSatisfiable is returned for a range starting past the end of the file
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// parseRange parses a single byte range of a Range header against the file size, as
+// START-END, START- or -SUFFIX_LENGTH. It returns ok false for headers to ignore, such as
+// malformed ones or those with several ranges, which get the whole file
+func parseRange(header string, size int64) (start, end int64, ok bool, err error) {
+	spec, found := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false, nil
+	}
+	startSpec, endSpec, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false, nil
+	}
+
+	if startSpec == "" {
+		suffix, err := strconv.ParseInt(endSpec, 10, 64)
+		if err != nil || suffix < 0 {
+			return 0, 0, false, nil
+		}
+		if suffix == 0 || size == 0 {
+			return 0, 0, false, errRangeNotSatisfiable
+		}
+		return max(size-suffix, 0), size - 1, true, nil
+	}
+
+	start, err = strconv.ParseInt(startSpec, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false, nil
+	}
+	end = size - 1
+	if endSpec != "" {
+		end, err = strconv.ParseInt(endSpec, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false, nil
+		}
+		end = min(end, size-1)
+	}
+	if start >= size {
+		return 0, 0, false, errRangeNotSatisfiable
+	}
+	return start, end, true, nil
+}
+
+// handleRangeDownload answers a Range request for a file with 206 Partial Content, or
+// returns nil when the whole file should be sent instead
+func (s *Server) handleRangeDownload(req *Request, fullPath string, fileInfo os.FileInfo) *Response {
+	var content []byte
+	size := fileInfo.Size()
+	if len(s.EncryptionKey) > 0 {
+		// The ciphertext can't be read from the middle, decrypt all of it
+		decrypted, err := s.readFileContent(fullPath)
+		if err != nil {
+			fmt.Println("Error reading file:", err)
+			return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+		}
+		content, size = decrypted, int64(len(decrypted))
+	}
+
+	start, end, ok, err := parseRange(req.Headers["range"], size)
+	if err != nil {
+		return &Response{
+			StatusLine: StatusRangeNotSatisfiable,
+			Headers: map[string]string{
+				"Accept-Ranges": "bytes",
+				"Content-Range": fmt.Sprintf("bytes */%d", size),
+			},
+		}
+	}
+	if !ok {
+		return nil
+	}
+	if s.MaxSendBytes > 0 && end-start+1 > s.MaxSendBytes {
+		end = start + s.MaxSendBytes - 1
+	}
+
+	// Files without an extension are sniffed from their beginning, wherever the range starts
+	var head []byte
+	if content != nil {
+		head = content[:min(len(content), 512)]
+		content = content[start : end+1]
+	} else {
+		file, err := os.Open(fullPath)
+		if err != nil {
+			fmt.Println("Error opening file:", err)
+			return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+		}
+		defer file.Close()
+
+		head = make([]byte, min(size, 512))
+		if _, err := io.ReadFull(file, head); err != nil {
+			fmt.Println("Error reading file:", err)
+			return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+		}
+		if _, err := file.Seek(start, io.SeekStart); err != nil {
+			fmt.Println("Error seeking file:", err)
+			return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+		}
+		content = make([]byte, end-start+1)
+		if _, err := io.ReadFull(file, content); err != nil {
+			fmt.Println("Error reading file:", err)
+			return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+		}
+	}
+
+	contentType := fileContentType(fullPath, head)
+	response := &Response{
+		StatusLine: StatusPartialContent,
+		Headers: map[string]string{
+			"Accept-Ranges": "bytes",
+			"Content-Range": fmt.Sprintf("bytes %d-%d/%d", start, end, size),
+			"Content-Type":  contentType,
+			"Last-Modified": fileInfo.ModTime().UTC().Format(http.TimeFormat),
+			"ETag":          fileETag(fileInfo),
+		},
+		Body: content,
+	}
+	if !displayableInline(contentType) {
+		response.Headers["Content-Disposition"] = fmt.Sprintf("attachment; filename=%s", filepath.Base(fullPath))
+	}
+	return response
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"container/list"
+	"slices"
+	"sync"
+	"time"
+)
+
+// defaultRouteCacheEntries is how many responses a route cache holds unless configured otherwise
+const defaultRouteCacheEntries = 1000
+
+// routeCache is an LRU cache of the responses of a single route
+type routeCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	keyFn      func(*Request) string
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List // most recently used at the front
+}
+
+// routeCacheEntry is a cached response and when it expires
+type routeCacheEntry struct {
+	key      string
+	response *Response
+	expires  time.Time
+}
+
+// Cache caches the route's successful responses for ttl, keyed by keyFn, or by
+// method, path and query when keyFn is nil
+func (route *Route) Cache(ttl time.Duration, keyFn func(*Request) string) *Route {
+	if route.Handler == nil {
+		return route
+	}
+	if keyFn == nil {
+		keyFn = defaultCacheKey
+	}
+	cache := &routeCache{
+		ttl:        ttl,
+		keyFn:      keyFn,
+		maxEntries: defaultRouteCacheEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+	route.cache = cache
+
+	next := route.Handler
+	route.Handler = HandlerFunc(func(req *Request) *Response {
+		key := cache.keyFn(req)
+		if response, ok := cache.get(key); ok {
+			return response
+		}
+		response := next.Handle(req)
+		if response.StatusLine == StatusOK {
+			cache.put(key, response)
+		}
+		return response
+	})
+	return route
+}
+
+// MaxEntries limits how many responses the route's cache holds
+func (route *Route) MaxEntries(n int) *Route {
+	if route.cache != nil {
+		route.cache.mu.Lock()
+		route.cache.maxEntries = n
+		route.cache.mu.Unlock()
+	}
+	return route
+}
+
+// defaultCacheKey keys a response by method, path and query
+func defaultCacheKey(req *Request) string {
+	return req.Method + " " + req.Path + "?" + req.RawQuery
+}
+
+// get returns a copy of the cached response if it hasn't expired
+func (c *routeCache) get(key string) (*Response, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*routeCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return cloneResponse(entry.response), true
+}
+
+// put stores a copy of the response, evicting the least recently used entries when full
+func (c *routeCache) put(key string, response *Response) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &routeCacheEntry{
+		key:      key,
+		response: cloneResponse(response),
+		expires:  time.Now().Add(c.ttl),
+	}
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+	} else {
+		c.entries[key] = c.order.PushFront(entry)
+	}
+
+	for c.maxEntries > 0 && c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*routeCacheEntry).key)
+	}
+}
+
+// clear drops every cached response
+func (c *routeCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+}
+
+// clearCaches drops the cached responses of every route
+func (r *Router) clearCaches() {
+	for _, route := range r.routes {
+		if route.cache != nil {
+			route.cache.clear()
+		}
+	}
+}
+
+// cloneResponse copies a response so middleware can modify it without touching the original
+func cloneResponse(response *Response) *Response {
+	headers := make(map[string]string, len(response.Headers))
+	for k, v := range response.Headers {
+		headers[k] = v
+	}
+	multiHeaders := make(map[string][]string, len(response.MultiHeaders))
+	for k, values := range response.MultiHeaders {
+		multiHeaders[k] = slices.Clone(values)
+	}
+	return &Response{
+		StatusLine:   response.StatusLine,
+		Headers:      headers,
+		MultiHeaders: multiHeaders,
+		Body:         response.Body,
+	}
+}
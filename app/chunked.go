@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxChunkLineBytes bounds a chunk size line or trailer field of a chunked request body
+const maxChunkLineBytes = 4 << 10
+
+var (
+	// errMalformedChunk is returned when a chunked request body doesn't follow the framing
+	errMalformedChunk = errors.New("malformed chunked request body")
+	// errBodyTooLarge is returned when a request body is larger than Server.MaxBodySize
+	errBodyTooLarge = errors.New("request body too large")
+	// errUnsupportedTransferEncoding is returned for transfer codings other than chunked
+	errUnsupportedTransferEncoding = errors.New("unsupported transfer encoding")
+)
+
+// requestChunked reports whether the request body uses chunked transfer encoding. Any other
+// transfer coding is an error, the server can't tell where such a body ends
+func requestChunked(headers map[string]string) (bool, error) {
+	encoding, ok := headers["transfer-encoding"]
+	if !ok {
+		return false, nil
+	}
+	if !strings.EqualFold(strings.TrimSpace(encoding), "chunked") {
+		return false, errUnsupportedTransferEncoding
+	}
+	return true, nil
+}
+
+// readChunkedBody reads a chunked request body (RFC 9112 section 7.1) into req.Body,
+// ignoring chunk extensions and discarding the trailer. The request then looks as if the
+// body had been sent with a Content-Length
+func (s *Server) readChunkedBody(reader *bufio.Reader, req *Request) error {
+	var source io.Reader = reader
+	if s.SimulateSlowReads && s.SlowReadBytesPerSecond > 0 {
+		source = newThrottledReader(reader, s.SlowReadBytesPerSecond)
+	}
+	bodyReader, done := s.trackUpload(uploadID(req.Headers, req.RemoteAddr), 0, source)
+	defer done()
+
+	var body bytes.Buffer
+	for {
+		line, err := readHeaderLine(reader, maxChunkLineBytes, true)
+		if err != nil {
+			return fmt.Errorf("error reading chunk size: %w", err)
+		}
+		sizeField, _, _ := strings.Cut(strings.TrimRight(line, "\r\n"), ";")
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 64)
+		if err != nil || size < 0 {
+			return errMalformedChunk
+		}
+		if size == 0 {
+			break
+		}
+		if s.MaxBodySize > 0 && int64(body.Len())+size > s.MaxBodySize {
+			return errBodyTooLarge
+		}
+
+		if _, err := io.CopyN(&body, bodyReader, size); err != nil {
+			return fmt.Errorf("error reading request body: %w", err)
+		}
+		if line, err := readHeaderLine(reader, maxChunkLineBytes, true); err != nil {
+			return fmt.Errorf("error reading request body: %w", err)
+		} else if strings.TrimRight(line, "\r\n") != "" {
+			return errMalformedChunk
+		}
+	}
+
+	// The trailer ends with an empty line like the header does
+	for {
+		line, err := readHeaderLine(reader, maxChunkLineBytes, true)
+		if err != nil {
+			return fmt.Errorf("error reading chunked trailer: %w", err)
+		}
+		if strings.TrimRight(line, "\r\n") == "" {
+			break
+		}
+	}
+
+	req.Body = body.Bytes()
+	delete(req.Headers, "transfer-encoding")
+	req.Headers["content-length"] = strconv.Itoa(body.Len())
+	return nil
+}
+
+// writeChunked writes the body as a single chunk followed by the last, empty chunk
+func writeChunked(buf []byte, body []byte) []byte {
+	if len(body) > 0 {
+		buf = append(buf, strconv.FormatInt(int64(len(body)), 16)+"\r\n"...)
+		buf = append(buf, body...)
+		buf = append(buf, "\r\n"...)
+	}
+	return append(buf, "0\r\n\r\n"...)
+}
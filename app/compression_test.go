@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompressionSkipsExcludedExtensions(t *testing.T) {
+	s := newTestServer(t)
+	s.CompressionThreshold = 0
+	content := bytes.Repeat([]byte("not really a jpeg "), 200)
+	for _, name := range []string{"photo.jpg", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(s.Directory, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addr := startTestServer(t, s)
+
+	response, body := roundTrip(t, addr, "GET /files/photo.jpg HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip\r\nConnection: close\r\n\r\n")
+	if encoding := response.Header.Get("Content-Encoding"); encoding != "" {
+		t.Fatalf("got Content-Encoding %q for a .jpg", encoding)
+	}
+	if !bytes.Equal(body, content) {
+		t.Fatal("body of the .jpg changed")
+	}
+
+	response, _ = roundTrip(t, addr, "GET /files/notes.txt HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip\r\nConnection: close\r\n\r\n")
+	if encoding := response.Header.Get("Content-Encoding"); encoding != "gzip" {
+		t.Fatalf("got Content-Encoding %q for a .txt, want gzip", encoding)
+	}
+}
+
+func TestCompressionSkipsExcludedPaths(t *testing.T) {
+	options := CompressionOptions{ExcludePaths: []string{"/files/raw/"}}
+	if !options.excludes("/files/raw/data.txt") {
+		t.Error("path under an excluded prefix isn't excluded")
+	}
+	if options.excludes("/files/data.txt") {
+		t.Error("path outside the excluded prefixes is excluded")
+	}
+}
+
+func TestCompressionSkipsEncodedResponses(t *testing.T) {
+	s := newTestServer(t)
+	s.CompressionThreshold = 0
+	body := bytes.Repeat([]byte("already encoded "), 200)
+	handler := s.compressionMiddleware(HandlerFunc(func(req *Request) *Response {
+		return &Response{
+			StatusLine: StatusOK,
+			Headers:    map[string]string{"Content-Encoding": "br"},
+			Body:       body,
+		}
+	}))
+
+	response := handler.Handle(&Request{Method: "GET", Path: "/", Headers: map[string]string{"accept-encoding": "gzip"}})
+	if response.Headers["Content-Encoding"] != "br" || !bytes.Equal(response.Body, body) {
+		t.Fatalf("encoded response was compressed again: %v", response.Headers)
+	}
+}
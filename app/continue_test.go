@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpectContinue(t *testing.T) {
+	s := newTestServer(t)
+	s.MaxBodySize = 10
+	addr := startTestServer(t, s)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	// The body is held back until the server asks for it
+	io.WriteString(conn, "POST /files/small.txt HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n")
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(line, "HTTP/1.1 100 ") {
+		t.Fatalf("got %q, want 100 Continue", line)
+	}
+	if blank, _ := reader.ReadString('\n'); blank != "\r\n" {
+		t.Fatalf("got %q after 100 Continue, want a blank line", blank)
+	}
+	io.WriteString(conn, "hello")
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != 201 {
+		t.Fatalf("got status %d, want 201", response.StatusCode)
+	}
+}
+
+func TestExpectContinueRefusesLargeBodies(t *testing.T) {
+	s := newTestServer(t)
+	s.MaxBodySize = 10
+	addr := startTestServer(t, s)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Nothing of the body is sent, the answer has to come from the headers alone
+	io.WriteString(conn, "POST /files/large.txt HTTP/1.1\r\nHost: localhost\r\nContent-Length: 100\r\nExpect: 100-continue\r\n\r\n")
+	response, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != 417 {
+		t.Fatalf("got status %d, want 417", response.StatusCode)
+	}
+}
+
+func TestContinueHandlerSeesTheHeaders(t *testing.T) {
+	s := newTestServer(t)
+	var got *RequestHeaders
+	s.ContinueHandler = func(req *RequestHeaders) bool {
+		got = req
+		return false
+	}
+	addr := startTestServer(t, s)
+
+	response, _ := roundTrip(t, addr, "PUT /files/x.txt HTTP/1.1\r\nHost: localhost\r\nContent-Length: 3\r\nExpect: 100-continue\r\nX-Token: abc\r\n\r\n")
+	if response.StatusCode != 417 {
+		t.Fatalf("got status %d, want 417", response.StatusCode)
+	}
+	if got == nil || got.Method != "PUT" || got.Path != "/files/x.txt" || got.Headers["x-token"] != "abc" {
+		t.Fatalf("handler got %+v", got)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// parseCookies parses the Cookie request header, skipping it whole if it is malformed
+func parseCookies(header string) []*http.Cookie {
+	if header == "" {
+		return nil
+	}
+	cookies, err := http.ParseCookie(header)
+	if err != nil {
+		fmt.Println("Invalid cookie header:", err)
+		return nil
+	}
+	return cookies
+}
+
+// Cookie returns the request's cookie with the given name, or nil if it wasn't sent
+func (req *Request) Cookie(name string) *http.Cookie {
+	for _, cookie := range req.Cookies {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+// SetCookie adds a Set-Cookie header for the cookie. Invalid cookies are dropped
+func (r *Response) SetCookie(cookie *http.Cookie) {
+	if err := cookie.Valid(); err != nil {
+		fmt.Println("Dropping invalid cookie:", err)
+		return
+	}
+	r.AddHeader("Set-Cookie", cookie.String())
+}
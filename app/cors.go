@@ -0,0 +1,184 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions configures CORSMiddleware
+type CORSOptions struct {
+	// AllowedOrigins lists the origins allowed to make cross-origin requests, e.g.
+	// "https://example.com"; "*" allows any origin and "https://*.example.com" any subdomain
+	AllowedOrigins []string
+	// AllowedMethods are the methods allowed in preflight requests, all supported methods by default
+	AllowedMethods []string
+	// AllowedHeaders are the request headers allowed in preflight requests; by default
+	// whatever the client asks for is allowed
+	AllowedHeaders []string
+	// ExposeHeaders are the response headers the browser lets scripts read
+	ExposeHeaders []string
+	// AllowCredentials lets the browser send cookies and credentials along
+	AllowCredentials bool
+	// MaxAge is how long the browser may cache a preflight response
+	MaxAge time.Duration
+}
+
+// defaultCORSMethods are the methods allowed when CORSOptions.AllowedMethods is empty
+var defaultCORSMethods = supportedMethods
+
+// CORSMiddleware adds CORS headers for the allowed origins and answers preflight requests
+// itself. Requests from other origins get no CORS headers, so the browser blocks them
+func CORSMiddleware(opts CORSOptions) Middleware {
+	policy := newCORSPolicy(opts)
+	return func(next Handler) Handler {
+		return HandlerFunc(func(req *Request) *Response {
+			return policy.handle(req, next)
+		})
+	}
+}
+
+// corsPolicy is CORSOptions prepared for answering requests
+type corsPolicy struct {
+	opts    CORSOptions
+	methods string
+}
+
+// newCORSPolicy prepares the options, filling in the defaults
+func newCORSPolicy(opts CORSOptions) *corsPolicy {
+	methods := opts.AllowedMethods
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	return &corsPolicy{opts: opts, methods: strings.Join(methods, ", ")}
+}
+
+// handle answers a preflight request, or adds the CORS headers to next's response
+func (p *corsPolicy) handle(req *Request, next Handler) *Response {
+	origin := req.Headers["origin"]
+	preflight := req.Method == "OPTIONS" && req.Headers["access-control-request-method"] != ""
+	if origin == "" {
+		return next.Handle(req)
+	}
+
+	headers, ok := p.headers(origin)
+	if !ok {
+		if preflight {
+			return &Response{StatusLine: StatusForbidden, Headers: make(map[string]string)}
+		}
+		return next.Handle(req)
+	}
+
+	var response *Response
+	if preflight {
+		response = &Response{StatusLine: StatusNoContent, Headers: make(map[string]string)}
+		response.Headers["Access-Control-Allow-Methods"] = p.methods
+		if len(p.opts.AllowedHeaders) > 0 {
+			response.Headers["Access-Control-Allow-Headers"] = strings.Join(p.opts.AllowedHeaders, ", ")
+		} else if requested := req.Headers["access-control-request-headers"]; requested != "" {
+			response.Headers["Access-Control-Allow-Headers"] = requested
+		}
+		if p.opts.MaxAge > 0 {
+			response.Headers["Access-Control-Max-Age"] = strconv.Itoa(int(p.opts.MaxAge.Seconds()))
+		}
+		delete(headers, "Access-Control-Expose-Headers")
+	} else {
+		response = next.Handle(req)
+		if response.Headers == nil {
+			response.Headers = make(map[string]string)
+		}
+	}
+
+	for key, value := range headers {
+		if key == "Vary" {
+			addVary(response, value)
+		} else {
+			response.Headers[key] = value
+		}
+	}
+	return response
+}
+
+// headers returns the CORS headers of a response to the origin, if it is allowed
+func (p *corsPolicy) headers(origin string) (map[string]string, bool) {
+	allowOrigin, ok := p.opts.allowOrigin(origin)
+	if !ok {
+		return nil, false
+	}
+	headers := map[string]string{"Access-Control-Allow-Origin": allowOrigin}
+	// Browsers refuse credentials with a wildcard origin
+	if p.opts.AllowCredentials && allowOrigin != "*" {
+		headers["Access-Control-Allow-Credentials"] = "true"
+	}
+	if len(p.opts.ExposeHeaders) > 0 {
+		headers["Access-Control-Expose-Headers"] = strings.Join(p.opts.ExposeHeaders, ", ")
+	}
+	// The answer depends on the origin unless every origin gets the same wildcard
+	if allowOrigin != "*" || len(p.opts.AllowedOrigins) > 1 {
+		headers["Vary"] = "Origin"
+	}
+	return headers, true
+}
+
+// corsMiddleware applies CORSMiddleware with CORS, unless it is nil
+func (s *Server) corsMiddleware(next Handler) Handler {
+	return HandlerFunc(func(req *Request) *Response {
+		policy := s.corsPolicy()
+		if policy == nil {
+			return next.Handle(req)
+		}
+		return policy.handle(req, next)
+	})
+}
+
+// corsPolicy returns the policy for CORS, prepared again only when CORS is set to other options
+func (s *Server) corsPolicy() *corsPolicy {
+	s.corsMu.Lock()
+	defer s.corsMu.Unlock()
+	if s.CORS != s.corsOpts {
+		s.corsOpts = s.CORS
+		s.cors = nil
+		if s.CORS != nil {
+			s.cors = newCORSPolicy(*s.CORS)
+		}
+	}
+	return s.cors
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for the origin, if it is allowed.
+// Origins listed by name or pattern are echoed back, any other matches a wildcard as "*"
+func (opts CORSOptions) allowOrigin(origin string) (string, bool) {
+	wildcard := false
+	for _, allowed := range opts.AllowedOrigins {
+		if allowed == "*" {
+			wildcard = true
+			continue
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin, true
+		}
+		if prefix, suffix, ok := strings.Cut(allowed, "*"); ok &&
+			len(origin) > len(prefix)+len(suffix) &&
+			strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+			return origin, true
+		}
+	}
+	if wildcard {
+		return "*", true
+	}
+	return "", false
+}
+
+// addVary adds a header name to the response's Vary header
+func addVary(response *Response, header string) {
+	if vary := response.Headers["Vary"]; vary != "" {
+		for _, existing := range strings.Split(vary, ",") {
+			if strings.EqualFold(strings.TrimSpace(existing), header) {
+				return
+			}
+		}
+		response.Headers["Vary"] = vary + ", " + header
+		return
+	}
+	response.Headers["Vary"] = header
+}
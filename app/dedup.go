@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// linkDuplicateUpload hard links fullPath to a previously uploaded file with the same
+// content, returning the original's path relative to the directory, or "" when the
+// content is new and has to be written
+func (s *Server) linkDuplicateUpload(hash, fullPath string) string {
+	value, ok := s.uploadHashes.Load(hash)
+	if !ok {
+		return ""
+	}
+	original := value.(string)
+
+	// The original may have been changed behind the server's back since it was uploaded
+	if content, err := s.readFileContent(original); err != nil || uploadHash(content) != hash {
+		s.uploadHashes.CompareAndDelete(hash, original)
+		return ""
+	}
+	// or removed or replaced since it was read
+	if err := os.Link(original, fullPath); err != nil {
+		fmt.Println("Error linking duplicate upload, writing a new copy:", err)
+		s.uploadHashes.Delete(hash)
+		return ""
+	}
+
+	relative, err := filepath.Rel(s.Directory, original)
+	if err != nil {
+		relative = filepath.Base(original)
+	}
+	return relative
+}
+
+// forgetUploadHash drops the hash of a file whose content is about to change or go away,
+// so later uploads aren't linked to it
+func (s *Server) forgetUploadHash(fullPath string) {
+	if !s.DeduplicateUploads {
+		return
+	}
+	s.uploadHashes.Range(func(hash, path any) bool {
+		if path.(string) == fullPath {
+			s.uploadHashes.CompareAndDelete(hash, path)
+		}
+		return true
+	})
+}
+
+// uploadHash returns the hex SHA-256 of an uploaded body
+func uploadHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeduplicateUploadsLinksIdenticalContent(t *testing.T) {
+	s := newTestServer(t)
+	s.DeduplicateUploads = true
+
+	expectStatus(t, s.handleFiles(fileRequest("POST", "a.txt", "same")), 201)
+	response := s.handleFiles(fileRequest("POST", "b.txt", "same"))
+	expectStatus(t, response, 201)
+	if response.Headers["X-Deduplicated"] != "true" || response.Headers["X-Original-File"] != "a.txt" {
+		t.Fatalf("second upload wasn't deduplicated: %v", response.Headers)
+	}
+
+	a, _ := os.Stat(filepath.Join(s.Directory, "a.txt"))
+	b, _ := os.Stat(filepath.Join(s.Directory, "b.txt"))
+	if !os.SameFile(a, b) {
+		t.Fatal("duplicate upload isn't a hard link to the original")
+	}
+}
+
+func TestDeduplicateUploadsModifyingOneLinkKeepsTheOther(t *testing.T) {
+	for _, method := range []string{"PUT", "PATCH"} {
+		t.Run(method, func(t *testing.T) {
+			s := newTestServer(t)
+			s.DeduplicateUploads = true
+			expectStatus(t, s.handleFiles(fileRequest("POST", "a.txt", "same")), 201)
+			expectStatus(t, s.handleFiles(fileRequest("POST", "b.txt", "same")), 201)
+
+			expectStatus(t, s.handleFiles(fileRequest(method, "b.txt", "changed")), 204)
+			if content, _ := os.ReadFile(filepath.Join(s.Directory, "a.txt")); string(content) != "same" {
+				t.Fatalf("%s of a link changed the original to %q", method, content)
+			}
+		})
+	}
+}
+
+func TestDeduplicateUploadsForgetsChangedFiles(t *testing.T) {
+	s := newTestServer(t)
+	s.DeduplicateUploads = true
+	expectStatus(t, s.handleFiles(fileRequest("POST", "a.txt", "same")), 201)
+	expectStatus(t, s.handleFiles(fileRequest("PUT", "a.txt", "changed")), 204)
+
+	response := s.handleFiles(fileRequest("POST", "b.txt", "same"))
+	expectStatus(t, response, 201)
+	if response.Headers["X-Deduplicated"] != "" {
+		t.Fatal("upload was linked to a file whose content changed")
+	}
+	if content, _ := os.ReadFile(filepath.Join(s.Directory, "b.txt")); string(content) != "same" {
+		t.Fatalf("got %q, want %q", content, "same")
+	}
+}
+
+func TestDeduplicateUploadsRechecksTheOriginal(t *testing.T) {
+	s := newTestServer(t)
+	s.DeduplicateUploads = true
+	expectStatus(t, s.handleFiles(fileRequest("POST", "a.txt", "same")), 201)
+	// Changed outside the server, which doesn't see it
+	if err := os.WriteFile(filepath.Join(s.Directory, "a.txt"), []byte("other"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	response := s.handleFiles(fileRequest("POST", "b.txt", "same"))
+	expectStatus(t, response, 201)
+	if content, _ := os.ReadFile(filepath.Join(s.Directory, "b.txt")); string(content) != "same" {
+		t.Fatalf("got %q, want %q", content, "same")
+	}
+}
+
+func TestDeduplicateUploadsForgetsDeletedFiles(t *testing.T) {
+	s := newTestServer(t)
+	s.DeduplicateUploads = true
+	expectStatus(t, s.handleFiles(fileRequest("POST", "a.txt", "same")), 201)
+	expectStatus(t, s.handleFiles(fileRequest("DELETE", "a.txt", "")), 204)
+
+	s.uploadHashes.Range(func(hash, path any) bool {
+		t.Fatalf("hash of a deleted file kept: %v", path)
+		return false
+	})
+}
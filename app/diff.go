@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// diffContextLines is how many unchanged lines surround the changes in a hunk
+const diffContextLines = 3
+
+// diffLine is a line of a hunk
+type diffLine struct {
+	Op   string `json:"op"` // equal, insert or delete
+	Text string `json:"text"`
+}
+
+// diffHunk is a run of changes with the unchanged lines around them, starting at
+// line StartA of the first file and StartB of the second
+type diffHunk struct {
+	StartA int        `json:"start_a"`
+	StartB int        `json:"start_b"`
+	Lines  []diffLine `json:"lines"`
+}
+
+// handleCompare handles GET /files/compare?a=name&b=name, returning the line diff
+// between two files as unified diff hunks
+func (s *Server) handleCompare(req *Request) *Response {
+	if req.Method != "GET" || s.Directory == "" {
+		return s.handleFiles(req)
+	}
+
+	contents := make([]string, 0, 2)
+	for _, param := range []string{"a", "b"} {
+		name := req.Query.Get(param)
+		if name == "" {
+			return textResponse(StatusBadRequest, param+" is required")
+		}
+		content, response := s.readFileToDiff(name)
+		if response != nil {
+			return response
+		}
+		contents = append(contents, content)
+	}
+
+	content, _ := json.Marshal(map[string]any{"hunks": diffLines(contents[0], contents[1])})
+	return &Response{
+		StatusLine: StatusOK,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       content,
+	}
+}
+
+// readFileToDiff reads a file to compare, within MaxDiffFileSize
+func (s *Server) readFileToDiff(name string) (string, *Response) {
+	fullPath, err := s.resolveFilePath(name)
+	if err != nil {
+		return "", textResponse(StatusBadRequest, err.Error())
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil || info.IsDir() {
+		return "", &Response{StatusLine: StatusNotFound, Headers: make(map[string]string)}
+	}
+	if s.MaxDiffFileSize > 0 && info.Size() > s.MaxDiffFileSize {
+		return "", textResponse(StatusBadRequest, fmt.Sprintf("%s is larger than %d bytes", name, s.MaxDiffFileSize))
+	}
+
+	content, err := os.ReadFile(fullPath)
+	if err == nil && len(s.EncryptionKey) > 0 {
+		content, err = decryptFile(s.EncryptionKey, content)
+	}
+	if err != nil {
+		fmt.Println("Error reading file to diff:", err)
+		return "", &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+	}
+	return string(content), nil
+}
+
+// diffLines computes the line diff between a and b, grouped into hunks
+func diffLines(a, b string) []diffHunk {
+	dmp := diffmatchpatch.New()
+	charsA, charsB, lines := dmp.DiffLinesToChars(a, b)
+	diffs := dmp.DiffCharsToLines(dmp.DiffMain(charsA, charsB, false), lines)
+
+	// Flatten the diff into single lines
+	var ops []diffLine
+	for _, diff := range diffs {
+		op := "equal"
+		switch diff.Type {
+		case diffmatchpatch.DiffInsert:
+			op = "insert"
+		case diffmatchpatch.DiffDelete:
+			op = "delete"
+		}
+		for _, line := range strings.SplitAfter(diff.Text, "\n") {
+			if line != "" {
+				ops = append(ops, diffLine{Op: op, Text: strings.TrimSuffix(line, "\n")})
+			}
+		}
+	}
+
+	// Line numbers in a and b at which each line of the diff is
+	positions := make([][2]int, len(ops)+1)
+	positions[0] = [2]int{1, 1}
+	for i, line := range ops {
+		positions[i+1] = positions[i]
+		if line.Op != "insert" {
+			positions[i+1][0]++
+		}
+		if line.Op != "delete" {
+			positions[i+1][1]++
+		}
+	}
+
+	// Group changes separated by at most twice the context into the same hunk
+	hunks := []diffHunk{}
+	for i := 0; i < len(ops); i++ {
+		if ops[i].Op == "equal" {
+			continue
+		}
+		first, last := i, i
+		for j := i + 1; j < len(ops) && j-last <= 2*diffContextLines+1; j++ {
+			if ops[j].Op != "equal" {
+				last = j
+			}
+		}
+
+		from := max(first-diffContextLines, 0)
+		to := min(last+diffContextLines+1, len(ops))
+		hunks = append(hunks, diffHunk{
+			StartA: positions[from][0],
+			StartB: positions[from][1],
+			Lines:  ops[from:to],
+		})
+		i = last
+	}
+	return hunks
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DirCache caches directory listings so large directories aren't read on every request
+type DirCache struct {
+	entries sync.Map // directory path -> dirCacheEntry
+}
+
+// dirCacheEntry is a cached directory listing
+type dirCacheEntry struct {
+	entries  []os.DirEntry
+	cachedAt time.Time
+}
+
+// ReadDir returns the entries of the directory, from the cache when they are younger than ttl
+func (c *DirCache) ReadDir(path string, ttl time.Duration) ([]os.DirEntry, error) {
+	if value, ok := c.entries.Load(path); ok {
+		cached := value.(dirCacheEntry)
+		if time.Since(cached.cachedAt) < ttl {
+			return cached.entries, nil
+		}
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		c.entries.Delete(path)
+		return nil, err
+	}
+	if ttl > 0 {
+		c.entries.Store(path, dirCacheEntry{entries: entries, cachedAt: time.Now()})
+	}
+	return entries, nil
+}
+
+// Invalidate drops the cached listing of the directory
+func (c *DirCache) Invalidate(path string) {
+	c.entries.Delete(path)
+}
+
+// invalidateDirs drops the cached listings affected by a change to fullPath: its directory
+// and every parent up to the served directory, as they may have been created along the way.
+// The cached stat of the file itself goes too
+func (s *Server) invalidateDirs(fullPath string) {
+	s.stats.Invalidate(fullPath)
+	root := filepath.Clean(s.Directory)
+	for dir := filepath.Dir(fullPath); ; dir = filepath.Dir(dir) {
+		s.dirCache.Invalidate(dir)
+		if dir == root || !strings.HasPrefix(dir, root) || dir == filepath.Dir(dir) {
+			return
+		}
+	}
+}
+
+// InvalidateTree drops the cached listings of the directory and everything below it
+func (c *DirCache) InvalidateTree(dir string) {
+	c.entries.Range(func(key, _ any) bool {
+		if underDir(key.(string), dir) {
+			c.entries.Delete(key)
+		}
+		return true
+	})
+}
+
+// Clear drops every cached listing
+func (c *DirCache) Clear() {
+	c.entries.Clear()
+}
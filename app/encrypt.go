@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+)
+
+// encryptedFileMagic starts every file encrypted at rest, followed by the nonce and the ciphertext
+const encryptedFileMagic = "HSENC1\x00"
+
+// encryptFile seals an uploaded body with AES-GCM under a fresh random nonce
+func encryptFile(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := make([]byte, 0, len(encryptedFileMagic)+len(nonce)+len(plaintext)+gcm.Overhead())
+	sealed = append(sealed, encryptedFileMagic...)
+	sealed = append(sealed, nonce...)
+	return gcm.Seal(sealed, nonce, plaintext, nil), nil
+}
+
+// decryptFile opens a file written by encryptFile, returning other content unchanged
+// so files stored before encryption was enabled are still served
+func decryptFile(key, content []byte) ([]byte, error) {
+	if !bytes.HasPrefix(content, []byte(encryptedFileMagic)) {
+		return content, nil
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	content = content[len(encryptedFileMagic):]
+	if len(content) < gcm.NonceSize() {
+		return nil, errors.New("encrypted file is truncated")
+	}
+	nonce, ciphertext := content[:gcm.NonceSize()], content[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+// newGCM creates the AES-GCM cipher for a 16, 24 or 32 byte key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ExportStatic requests every GET route without path or query parameters in-process and writes
+// the response bodies under dir, mirroring the URL paths, so the output can be
+// deployed as a static site
+func (s *Server) ExportStatic(dir string) error {
+	exported := 0
+	for _, route := range s.Router.routes {
+		if route.Method != "GET" || route.Handler == nil || route.regex != nil || len(route.Query) > 0 || strings.HasSuffix(route.Pattern, "*") {
+			continue
+		}
+
+		response := s.Handler.Handle(&Request{
+			Method:      "GET",
+			Path:        route.Pattern,
+			HTTPVersion: "HTTP/1.1",
+			Headers:     make(map[string]string),
+			RemoteAddr:  "127.0.0.1:0",
+		})
+		if response.StatusLine != StatusOK {
+			fmt.Println("Warning: skipping", route.Pattern+":", response.StatusLine)
+			continue
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(route.Pattern))
+		if strings.HasSuffix(route.Pattern, "/") {
+			target = filepath.Join(target, "index.html")
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to export %s: %w", route.Pattern, err)
+		}
+		if err := os.WriteFile(target, response.Body, 0644); err != nil {
+			return fmt.Errorf("failed to export %s: %w", route.Pattern, err)
+		}
+		fmt.Println("Exported", route.Pattern, "to", target)
+		exported++
+	}
+
+	fmt.Println("Exported", exported, "routes to", dir)
+	return nil
+}
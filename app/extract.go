@@ -0,0 +1,193 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// errExtractTooLarge is reported once an archive exceeds Server.MaxExtractSize
+var errExtractTooLarge = errors.New("maximum extracted size exceeded")
+
+// extractedEntry reports what happened to a single archive entry
+type extractedEntry struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Size   int64  `json:"size,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// handleExtract handles POST /files/?extract=1, which extracts a tar.gz archive into the directory
+func (s *Server) handleExtract(req *Request) *Response {
+	archive, err := extractArchiveBody(req)
+	if err != nil {
+		return textResponse(StatusBadRequest, err.Error())
+	}
+	gz, err := gzip.NewReader(archive)
+	if err != nil {
+		return textResponse(StatusBadRequest, "invalid gzip body: "+err.Error())
+	}
+	defer gz.Close()
+
+	entries := s.extractArchive(tar.NewReader(gz), req.Query.Get("overwrite") == "1")
+	s.usage.reset()
+	content, err := json.Marshal(map[string]any{"files": entries})
+	if err != nil {
+		fmt.Println("Error encoding extracted files:", err)
+		return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+	}
+
+	response := &Response{
+		StatusLine: StatusMultiStatus,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       content,
+	}
+	s.audit(req, "archive.extract", response.StatusLine)
+	return response
+}
+
+// extractArchiveBody returns the archive from a raw body or the first file of a multipart form
+func extractArchiveBody(req *Request) (io.Reader, error) {
+	mediaType, params, err := mime.ParseMediaType(req.Headers["content-type"])
+	if err != nil || mediaType != "multipart/form-data" {
+		return bytes.NewReader(req.Body), nil
+	}
+
+	form := multipart.NewReader(bytes.NewReader(req.Body), params["boundary"])
+	for {
+		part, err := form.NextPart()
+		if err == io.EOF {
+			return nil, errors.New("no file in multipart body")
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid multipart body: %w", err)
+		}
+		if part.FileName() != "" {
+			return part, nil
+		}
+	}
+}
+
+// extractArchive writes every entry of the archive under the directory, skipping entries
+// that already exist unless overwrite is set
+func (s *Server) extractArchive(tr *tar.Reader, overwrite bool) []extractedEntry {
+	entries := make([]extractedEntry, 0)
+	remaining := s.MaxExtractSize
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		} else if err != nil {
+			return append(entries, extractedEntry{Status: "error", Error: err.Error()})
+		}
+
+		entry := extractedEntry{Name: header.Name}
+		// Zip-slip: an entry must not escape the directory
+		name := filepath.FromSlash(strings.TrimSuffix(header.Name, "/"))
+		if !filepath.IsLocal(name) {
+			entry.Status = "rejected"
+			entry.Error = "path outside the directory"
+			entries = append(entries, entry)
+			continue
+		}
+		if isInternalPath(name) {
+			entry.Status = "rejected"
+			entry.Error = "path reserved by the server"
+			entries = append(entries, entry)
+			continue
+		}
+		target := filepath.Join(s.Directory, name)
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				entry.Status = "error"
+				entry.Error = err.Error()
+			} else {
+				entry.Status = "created"
+			}
+		case tar.TypeReg:
+			if s.MaxExtractSize > 0 && header.Size > remaining {
+				entry.Status = "rejected"
+				entry.Error = errExtractTooLarge.Error()
+				return append(entries, entry)
+			}
+			entry.Size = header.Size
+			entry.Status, err = s.extractFile(tr, header.Size, target, overwrite)
+			if err != nil {
+				if entry.Status == "" {
+					entry.Status = "error"
+				}
+				entry.Error = err.Error()
+			}
+			if entry.Status == "extracted" || entry.Status == "overwritten" {
+				remaining -= header.Size
+			}
+		default:
+			entry.Status = "skipped"
+			entry.Error = "unsupported entry type"
+		}
+		if entry.Status != "skipped" {
+			s.invalidateDirs(target)
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// extractFile writes a single file of size bytes from the archive the way an upload or a
+// PUT would be, reporting whether it was extracted, overwritten, skipped or rejected
+func (s *Server) extractFile(r io.Reader, size int64, target string, overwrite bool) (string, error) {
+	// The whole file is held in memory like an upload's body, so it is held to the same limit
+	if s.MaxBodySize > 0 && size > s.MaxBodySize {
+		return "rejected", fmt.Errorf("file larger than the %d byte upload limit", s.MaxBodySize)
+	}
+
+	// The size comes from the untrusted header, memory only grows with the bytes actually read
+	content, err := io.ReadAll(io.LimitReader(r, size))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(content)) < size {
+		return "", io.ErrUnexpectedEOF
+	}
+	req := &Request{Method: "POST", Headers: make(map[string]string), Body: content}
+
+	var response *Response
+	if _, err := os.Stat(target); err == nil {
+		if !overwrite {
+			return "skipped", nil
+		}
+		req.Method = "PUT"
+		response = s.handleFileReplace(req, target)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	} else {
+		response = s.handleFileUpload(req, target)
+	}
+
+	switch statusCode(response.StatusLine) {
+	case 201:
+		return "extracted", nil
+	case 204:
+		return "overwritten", nil
+	case 409:
+		// Created since it was checked
+		return "skipped", nil
+	case 415:
+		return "rejected", errors.New("file extension not allowed")
+	case 507:
+		return "rejected", errors.New("directory quota exceeded")
+	default:
+		return "", fmt.Errorf("writing the file failed: %s", response.StatusLine)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"runtime"
+	"testing"
+)
+
+// tarEntry is a file written to a test archive, with a Size that may claim more than its content
+type tarEntry struct {
+	name    string
+	content string
+	size    int64
+}
+
+// makeArchive builds a tar.gz archive of the entries, cut short after an entry claiming more
+// than its content
+func makeArchive(t *testing.T, entries ...tarEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, entry := range entries {
+		size := entry.size
+		if size == 0 {
+			size = int64(len(entry.content))
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: entry.name, Mode: 0644, Size: size, Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(entry.content)); err != nil {
+			t.Fatal(err)
+		}
+		if size > int64(len(entry.content)) {
+			break
+		}
+	}
+	// Closing a cut short archive fails, what was written is what the test wants
+	tw.Flush()
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// extract posts the archive to /files/?extract=1 and returns the reported entries
+func extract(t *testing.T, s *Server, archive []byte) []extractedEntry {
+	t.Helper()
+	req := fileRequest("POST", "", "")
+	req.Body = archive
+	response := s.handleExtract(req)
+	expectStatus(t, response, 207)
+	var body struct {
+		Files []extractedEntry `json:"files"`
+	}
+	if err := json.Unmarshal(response.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Files
+}
+
+func TestExtract(t *testing.T) {
+	s := newTestServer(t)
+	entries := extract(t, s, makeArchive(t, tarEntry{name: "a.txt", content: "aaa"}, tarEntry{name: "dir/b.txt", content: "bb"}))
+	if len(entries) != 2 || entries[0].Status != "extracted" || entries[1].Status != "extracted" {
+		t.Fatalf("got %+v", entries)
+	}
+	if response := s.handleFiles(fileRequest("GET", "dir/b.txt", "")); string(response.Body) != "bb" {
+		t.Errorf("got %q, want the extracted content", response.Body)
+	}
+}
+
+func TestExtractDoesNotTrustEntrySizes(t *testing.T) {
+	for _, limit := range []int64{1 << 30, 0} {
+		s := newTestServer(t)
+		s.MaxExtractSize = limit
+		archive := makeArchive(t, tarEntry{name: "huge.bin", content: "just a few bytes", size: 1 << 30})
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		entries := extract(t, s, archive)
+		runtime.ReadMemStats(&after)
+
+		if len(entries) == 0 || entries[0].Status != "error" {
+			t.Fatalf("MaxExtractSize %d: got %+v, want an error", limit, entries)
+		}
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 64<<20 {
+			t.Errorf("MaxExtractSize %d: allocated %d bytes for a truncated entry", limit, allocated)
+		}
+		expectStatus(t, s.handleFiles(fileRequest("GET", "huge.bin", "")), 404)
+	}
+}
+
+func TestExtractRejectsEntriesLargerThanUploads(t *testing.T) {
+	s := newTestServer(t)
+	s.MaxBodySize = 4
+	entries := extract(t, s, makeArchive(t, tarEntry{name: "big.txt", content: "too big"}, tarEntry{name: "ok.txt", content: "ok"}))
+	if len(entries) != 2 || entries[0].Status != "rejected" || entries[1].Status != "extracted" {
+		t.Fatalf("got %+v", entries)
+	}
+}
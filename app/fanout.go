@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+)
+
+// FanOutMode controls which response FanOutMiddleware returns
+type FanOutMode int
+
+const (
+	// FirstWins returns the first successful response, without waiting for the others
+	FirstWins FanOutMode = iota
+	// AllMustSucceed returns the main response only if every handler succeeded
+	AllMustSucceed
+	// Merge returns the main response with the headers of all other responses added
+	Merge
+)
+
+// fanOutResult is the outcome of one handler, response is nil when it panicked
+type fanOutResult struct {
+	index    int
+	response *Response
+}
+
+// FanOutMiddleware dispatches every request to the next handler and to all the given handlers
+// at the same time, each with its own copy of the request. A handler fails when it panics or
+// responds with a 5xx status
+func FanOutMiddleware(mode FanOutMode, handlers ...Handler) Middleware {
+	return func(next Handler) Handler {
+		all := append([]Handler{next}, handlers...)
+
+		return HandlerFunc(func(req *Request) *Response {
+			results := make(chan fanOutResult, len(all))
+			for i, h := range all {
+				go func(i int, h Handler, req *Request) {
+					results <- fanOutResult{index: i, response: handleRecovered(h, req)}
+				}(i, h, cloneRequest(req))
+			}
+
+			responses := make([]*Response, len(all))
+			for range all {
+				result := <-results
+				if mode == FirstWins && fanOutSucceeded(result.response) {
+					return result.response
+				}
+				responses[result.index] = result.response
+			}
+
+			switch mode {
+			case AllMustSucceed:
+				for _, response := range responses {
+					if !fanOutSucceeded(response) {
+						return fanOutFailure(response)
+					}
+				}
+				return responses[0]
+			case Merge:
+				main := responses[0]
+				if main == nil {
+					return fanOutFailure(nil)
+				}
+				if main.Headers == nil {
+					main.Headers = make(map[string]string)
+				}
+				for _, response := range responses[1:] {
+					if response == nil {
+						continue
+					}
+					for k, v := range response.Headers {
+						if _, ok := main.Headers[k]; !ok {
+							main.Headers[k] = v
+						}
+					}
+				}
+				return main
+			default:
+				// No handler succeeded, report what the main one did
+				return fanOutFailure(responses[0])
+			}
+		})
+	}
+}
+
+// handleRecovered calls the handler, returning nil if it panics
+func handleRecovered(h Handler, req *Request) (response *Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			fmt.Println("Fan-out handler panicked:", err)
+			response = nil
+		}
+	}()
+	return h.Handle(req)
+}
+
+// fanOutSucceeded reports whether a handler's response counts as a success
+func fanOutSucceeded(response *Response) bool {
+	return response != nil && statusCode(response.StatusLine) < 500
+}
+
+// fanOutFailure returns the failed response, or 500 for a handler that panicked
+func fanOutFailure(response *Response) *Response {
+	if response != nil {
+		return response
+	}
+	return &Response{
+		StatusLine: StatusInternalServerError,
+		Headers:    make(map[string]string),
+	}
+}
+
+// cloneRequest copies a request so concurrent handlers can't see each other's changes
+func cloneRequest(req *Request) *Request {
+	clone := *req
+	clone.Headers = maps.Clone(req.Headers)
+	clone.PathParams = maps.Clone(req.PathParams)
+	clone.Body = slices.Clone(req.Body)
+	if req.Query != nil {
+		clone.Query = make(url.Values, len(req.Query))
+		for k, v := range req.Query {
+			clone.Query[k] = slices.Clone(v)
+		}
+	}
+	return &clone
+}
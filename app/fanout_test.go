@@ -0,0 +1,83 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fanOutHandler answers with the status and a header naming it, after the delay
+func fanOutHandler(name string, status string, delay time.Duration, calls *atomic.Int32) Handler {
+	return HandlerFunc(func(req *Request) *Response {
+		calls.Add(1)
+		time.Sleep(delay)
+		return &Response{StatusLine: status, Headers: map[string]string{"X-" + name: "1"}}
+	})
+}
+
+// panickingHandler panics instead of answering
+var panickingHandler = HandlerFunc(func(req *Request) *Response {
+	panic("handler failed")
+})
+
+func TestFanOutMiddlewareModes(t *testing.T) {
+	tests := []struct {
+		mode       FanOutMode
+		wantStatus int
+		wantHeader string
+	}{
+		// The main handler is the slowest, a faster one wins
+		{FirstWins, 200, "X-Fast"},
+		// One of the handlers panicked
+		{AllMustSucceed, 500, ""},
+		// The main response carries every successful response's headers
+		{Merge, 201, "X-Fast"},
+	}
+	for _, tt := range tests {
+		var calls atomic.Int32
+		main := fanOutHandler("Main", StatusCreated, 50*time.Millisecond, &calls)
+		handler := FanOutMiddleware(tt.mode,
+			fanOutHandler("Fast", StatusOK, 0, &calls),
+			fanOutHandler("Second", StatusOK, 10*time.Millisecond, &calls),
+			fanOutHandler("Third", StatusOK, 10*time.Millisecond, &calls),
+			panickingHandler,
+		)(main)
+
+		response := handler.Handle(&Request{Method: "GET", Path: "/", Headers: map[string]string{}})
+		if got := statusCode(response.StatusLine); got != tt.wantStatus {
+			t.Errorf("mode %d: got status %d, want %d", tt.mode, got, tt.wantStatus)
+		}
+		if tt.wantHeader != "" && response.Headers[tt.wantHeader] == "" {
+			t.Errorf("mode %d: got headers %v, want %s", tt.mode, response.Headers, tt.wantHeader)
+		}
+		if tt.mode == Merge && response.Headers["X-Main"] == "" {
+			t.Errorf("merged response isn't the main one: %v", response.Headers)
+		}
+		time.Sleep(60 * time.Millisecond)
+		if got := calls.Load(); got != 4 {
+			t.Errorf("mode %d: %d handlers ran, want 4", tt.mode, got)
+		}
+	}
+}
+
+func TestFanOutMiddlewareClonesTheRequest(t *testing.T) {
+	mutate := HandlerFunc(func(req *Request) *Response {
+		req.Headers["x-changed"] = "1"
+		req.Body[0] = 'X'
+		return &Response{StatusLine: StatusOK, Headers: map[string]string{}}
+	})
+	req := &Request{Method: "POST", Path: "/", Headers: map[string]string{}, Body: []byte("body")}
+
+	FanOutMiddleware(AllMustSucceed, mutate, mutate)(mutate).Handle(req)
+	if req.Headers["x-changed"] != "" || string(req.Body) != "body" {
+		t.Fatal("a handler changed the caller's request")
+	}
+}
+
+func TestFanOutMiddlewareMainPanics(t *testing.T) {
+	var calls atomic.Int32
+	handler := FanOutMiddleware(Merge, fanOutHandler("Other", StatusOK, 0, &calls))(panickingHandler)
+	if got := statusCode(handler.Handle(&Request{Headers: map[string]string{}}).StatusLine); got != 500 {
+		t.Fatalf("got status %d, want 500", got)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// fetchRequest is the JSON body of POST /files/fetch
+type fetchRequest struct {
+	URL  string `json:"url"`
+	Dest string `json:"dest"`
+}
+
+// errFetchTooLarge is returned when the remote file is larger than MaxFetchSize
+var errFetchTooLarge = errors.New("remote file is larger than the limit")
+
+// handleFetch handles POST /files/fetch, downloading a file from one of AllowedFetchHosts
+// into the directory
+func (s *Server) handleFetch(req *Request) *Response {
+	if req.Method != "POST" || s.Directory == "" {
+		return s.handleFiles(req)
+	}
+
+	var body fetchRequest
+	if err := json.Unmarshal(req.Body, &body); err != nil {
+		return textResponse(StatusBadRequest, "invalid JSON body: "+err.Error())
+	}
+	source, err := url.Parse(body.URL)
+	if err != nil || (source.Scheme != "http" && source.Scheme != "https") || source.Host == "" {
+		return textResponse(StatusBadRequest, "url must be an absolute http or https URL")
+	}
+	if !s.fetchHostAllowed(source) {
+		return textResponse(StatusForbidden, "fetching from "+source.Hostname()+" is not allowed")
+	}
+	if body.Dest == "" {
+		return textResponse(StatusBadRequest, "dest is required")
+	}
+	fullPath, err := s.resolveFilePath(body.Dest)
+	if err != nil {
+		return textResponse(StatusBadRequest, err.Error())
+	}
+	if !s.uploadExtensionAllowed(fullPath) {
+		return &Response{StatusLine: StatusUnsupportedMediaType, Headers: make(map[string]string)}
+	}
+	if _, err := os.Stat(fullPath); err == nil {
+		return &Response{StatusLine: StatusConflict, Headers: make(map[string]string)}
+	}
+
+	size, err := s.fetchToFile(source.String(), fullPath)
+	if errors.Is(err, errFetchTooLarge) {
+		return textResponse(StatusBadGateway, fmt.Sprintf("remote file is larger than %d bytes", s.MaxFetchSize))
+	} else if errors.Is(err, errQuotaExceeded) {
+		fmt.Println("Directory quota exceeded, rejecting:", fullPath)
+		return &Response{StatusLine: StatusInsufficientStorage, Headers: make(map[string]string)}
+	} else if err != nil {
+		fmt.Println("Error fetching", source.Redacted()+":", err)
+		return textResponse(StatusBadGateway, "fetch failed: "+err.Error())
+	}
+	s.invalidateDirs(fullPath)
+	s.audit(req, "file.create", StatusCreated)
+
+	content, _ := json.Marshal(map[string]any{"path": "/files/" + body.Dest, "size": size})
+	return &Response{
+		StatusLine: StatusCreated,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       content,
+	}
+}
+
+// fetchHostAllowed reports whether the URL's host is in AllowedFetchHosts
+func (s *Server) fetchHostAllowed(u *url.URL) bool {
+	return slices.ContainsFunc(s.AllowedFetchHosts, func(host string) bool {
+		return strings.EqualFold(host, u.Hostname())
+	})
+}
+
+// fetchToFile streams the URL's content into a temporary file next to fullPath and moves it
+// into place once complete, so a failed fetch leaves nothing behind. The temporary file is
+// in the write-ahead log like an upload, and counts against the directory quota
+func (s *Server) fetchToFile(source, fullPath string) (size int64, err error) {
+	client := &http.Client{
+		Timeout: s.FetchTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("too many redirects")
+			}
+			if !s.fetchHostAllowed(req.URL) {
+				return fmt.Errorf("redirect to %s is not allowed", req.URL.Hostname())
+			}
+			return nil
+		},
+	}
+	resp, err := client.Get(source)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("remote server answered %s", resp.Status)
+	}
+	if s.MaxFetchSize > 0 && resp.ContentLength > s.MaxFetchSize {
+		return 0, errFetchTooLarge
+	}
+
+	// Turn a file that can't fit away before downloading it. This also totals the directory's
+	// usage before the temporary file is there to be counted with it
+	var reserved int64
+	if s.DirectoryQuotaBytes > 0 {
+		if err := s.reserveQuota(max(resp.ContentLength, 0)); err != nil {
+			return 0, err
+		}
+		reserved = max(resp.ContentLength, 0)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		s.usage.release(reserved)
+		return 0, err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(fullPath), ".fetch-*")
+	if err != nil {
+		s.usage.release(reserved)
+		return 0, err
+	}
+	walID, err := s.wal.begin(temp.Name(), int(max(resp.ContentLength, 0)))
+	if err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		s.usage.release(reserved)
+		return 0, fmt.Errorf("writing to the write-ahead log: %w", err)
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		if err := os.Remove(temp.Name()); err == nil || os.IsNotExist(err) {
+			s.wal.abort(walID)
+		}
+		s.usage.release(reserved)
+	}()
+
+	var reader io.Reader = resp.Body
+	if s.MaxFetchSize > 0 {
+		reader = io.LimitReader(resp.Body, s.MaxFetchSize+1)
+	}
+	size, err = io.Copy(temp, reader)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if s.MaxFetchSize > 0 && size > s.MaxFetchSize {
+		return 0, errFetchTooLarge
+	}
+
+	if len(s.EncryptionKey) > 0 {
+		if err := encryptFileInPlace(s.EncryptionKey, temp.Name()); err != nil {
+			return 0, err
+		}
+	}
+
+	// Count what is actually stored in place of the announced length
+	if s.DirectoryQuotaBytes > 0 {
+		info, err := os.Stat(temp.Name())
+		if err != nil {
+			return 0, err
+		}
+		s.usage.release(reserved)
+		reserved = 0
+		if err := s.reserveQuota(info.Size()); err != nil {
+			return 0, err
+		}
+		reserved = info.Size()
+	}
+
+	if err := os.Rename(temp.Name(), fullPath); err != nil {
+		return 0, err
+	}
+	if err := s.wal.commit(walID); err != nil {
+		fmt.Println("Error writing to the write-ahead log:", err)
+	}
+	return size, nil
+}
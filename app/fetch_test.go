@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fetchFrom serves body at /file.bin on a local server that s may fetch from
+func fetchFrom(t *testing.T, s *Server, body string, chunked bool) string {
+	t.Helper()
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if chunked {
+			w.(http.Flusher).Flush()
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(remote.Close)
+	s.AllowedFetchHosts = []string{"127.0.0.1"}
+	return remote.URL + "/file.bin"
+}
+
+// fetchRequestFor is a POST /files/fetch request downloading source to dest
+func fetchRequestFor(source, dest string) *Request {
+	body, _ := json.Marshal(fetchRequest{URL: source, Dest: dest})
+	req := fileRequest("POST", "fetch", string(body))
+	req.Headers["content-type"] = "application/json"
+	return req
+}
+
+func TestFetchCountsAgainstTheQuota(t *testing.T) {
+	for _, chunked := range []bool{false, true} {
+		s := newTestServer(t)
+		s.DirectoryQuotaBytes = 1000
+		source := fetchFrom(t, s, strings.Repeat("a", 600), chunked)
+
+		expectStatus(t, s.handleFetch(fetchRequestFor(source, "a.bin")), 201)
+
+		// The first fetch's bytes are counted, a second doesn't fit
+		expectStatus(t, s.handleFetch(fetchRequestFor(source, "b.bin")), 507)
+		entries, err := os.ReadDir(s.Directory)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].Name() != "a.bin" {
+			t.Errorf("chunked %v: the rejected fetch left files behind: %v", chunked, entries)
+		}
+
+		// Nor does an upload of what is left plus one byte
+		expectStatus(t, s.handleFiles(fileRequest("POST", "c.bin", strings.Repeat("c", 401))), 507)
+		expectStatus(t, s.handleFiles(fileRequest("POST", "c.bin", strings.Repeat("c", 400))), 201)
+	}
+}
+
+func TestFetchIsInTheWriteAheadLog(t *testing.T) {
+	s := newTestServer(t)
+	wal, err := openWAL(s.WALFile, s.Directory, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.wal = wal
+	t.Cleanup(func() { wal.file.Close() })
+	source := fetchFrom(t, s, "hello", false)
+
+	expectStatus(t, s.handleFetch(fetchRequestFor(source, "a.bin")), 201)
+
+	content, err := os.ReadFile(s.WALFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		var entry walEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		actions = append(actions, entry.Action)
+		if entry.Path != "" && filepath.Dir(entry.Path) != s.Directory {
+			t.Errorf("logged %q outside the directory", entry.Path)
+		}
+	}
+	if strings.Join(actions, ",") != "write,commit" {
+		t.Errorf("got log entries %v, want a write and its commit", actions)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statCacheTTL is how long a file's stat result is reused
+const statCacheTTL = time.Second
+
+// statCache caches os.Stat results for a short time, so a file explorer polling metadata
+// doesn't stat the same files over and over
+type statCache struct {
+	entries sync.Map // full path -> statCacheEntry
+}
+
+// statCacheEntry is a cached stat result
+type statCacheEntry struct {
+	info     os.FileInfo
+	cachedAt time.Time
+}
+
+// Stat returns the file's info, from the cache when it is fresh enough
+func (c *statCache) Stat(path string) (os.FileInfo, error) {
+	if value, ok := c.entries.Load(path); ok {
+		cached := value.(statCacheEntry)
+		if time.Since(cached.cachedAt) < statCacheTTL {
+			return cached.info, nil
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		c.entries.Delete(path)
+		return nil, err
+	}
+	c.entries.Store(path, statCacheEntry{info: info, cachedAt: time.Now()})
+	return info, nil
+}
+
+// Invalidate drops the cached stat result of the file
+func (c *statCache) Invalidate(path string) {
+	c.entries.Delete(path)
+}
+
+// InvalidateTree drops the cached stat results of the directory and everything below it
+func (c *statCache) InvalidateTree(dir string) {
+	c.entries.Range(func(key, _ any) bool {
+		if underDir(key.(string), dir) {
+			c.entries.Delete(key)
+		}
+		return true
+	})
+}
+
+// Clear drops every cached stat result
+func (c *statCache) Clear() {
+	c.entries.Clear()
+}
+
+// fileInfoJSON is the JSON metadata of a file served by GET /files/{name}?info=1
+type fileInfoJSON struct {
+	Name  string    `json:"name"`
+	Size  int64     `json:"size"`
+	Mtime time.Time `json:"mtime"`
+	Mode  string    `json:"mode"`
+	IsDir bool      `json:"is_dir"`
+	MIME  string    `json:"mime"`
+	ETag  string    `json:"etag"`
+}
+
+// handleFileInfo returns the metadata of a file or directory as JSON, without its content
+func (s *Server) handleFileInfo(fullPath string) *Response {
+	info, err := s.stats.Stat(fullPath)
+	if err != nil {
+		return &Response{StatusLine: StatusNotFound, Headers: make(map[string]string)}
+	}
+
+	metadata := fileInfoJSON{
+		Name:  info.Name(),
+		Size:  info.Size(),
+		Mtime: info.ModTime().UTC(),
+		Mode:  info.Mode().String(),
+		IsDir: info.IsDir(),
+		ETag:  fileETag(info),
+	}
+	if !info.IsDir() {
+		metadata.MIME = fileMIMEType(fullPath)
+	}
+
+	content, err := json.Marshal(metadata)
+	if err != nil {
+		fmt.Println("Error encoding file info:", err)
+		return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+	}
+	return &Response{
+		StatusLine: StatusOK,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       content,
+	}
+}
+
+// fileETag identifies a version of a file by its size and modification time. It is weak
+// since a file rewritten within the clock's resolution keeps its ETag
+func fileETag(info os.FileInfo) string {
+	h := fnv.New64a()
+	binary.Write(h, binary.LittleEndian, [2]int64{info.Size(), info.ModTime().UnixNano()})
+	return fmt.Sprintf(`W/"%x"`, h.Sum64())
+}
+
+// notModified reports whether the client's copy of the file is current, going by
+// If-None-Match, or If-Modified-Since when there is no If-None-Match
+func notModified(req *Request, info os.FileInfo) bool {
+	if ifNoneMatch := req.Headers["if-none-match"]; ifNoneMatch != "" {
+		etag := strings.TrimPrefix(fileETag(info), "W/")
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	since, err := http.ParseTime(req.Headers["if-modified-since"])
+	if err != nil {
+		return false
+	}
+	// Last-Modified has a resolution of a second
+	return !info.ModTime().Truncate(time.Second).After(since)
+}
+
+// fileMIMEType guesses the media type of a file from its extension
+func fileMIMEType(path string) string {
+	if mimeType := mime.TypeByExtension(filepath.Ext(path)); mimeType != "" {
+		return mimeType
+	}
+	return "application/octet-stream"
+}
+
+// fileContentType guesses the media type of a file from its extension, or from the first
+// 512 bytes of its content when it has no extension
+func fileContentType(path string, content []byte) string {
+	if filepath.Ext(path) == "" && len(content) > 0 {
+		return http.DetectContentType(content[:min(len(content), 512)])
+	}
+	return fileMIMEType(path)
+}
+
+// displayableInline reports whether browsers display content of the media type rather
+// than offering to save it
+func displayableInline(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, prefix := range []string{"text/", "image/", "audio/", "video/"} {
+		if strings.HasPrefix(mediaType, prefix) {
+			return true
+		}
+	}
+	switch mediaType {
+	case "application/pdf", "application/json", "application/javascript", "application/xml":
+		return true
+	}
+	return strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
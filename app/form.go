@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"slices"
+)
+
+// multipartMaxMemory is how much of a form upload to /files/ is kept in memory, the rest
+// is spilled to temporary files
+const multipartMaxMemory = 32 << 20
+
+// errNotMultipart is returned when parsing a form from a request that isn't multipart/form-data
+var errNotMultipart = errors.New("request Content-Type isn't multipart/form-data")
+
+// errNotURLEncoded is returned when parsing a form from a request that isn't
+// application/x-www-form-urlencoded
+var errNotURLEncoded = errors.New("request Content-Type isn't application/x-www-form-urlencoded")
+
+// ParseForm decodes an application/x-www-form-urlencoded body
+func (req *Request) ParseForm() (url.Values, error) {
+	mediaType, _, err := mime.ParseMediaType(req.Headers["content-type"])
+	if err != nil || mediaType != "application/x-www-form-urlencoded" {
+		return nil, errNotURLEncoded
+	}
+	values, err := url.ParseQuery(string(req.Body))
+	if err != nil {
+		return nil, fmt.Errorf("invalid form body: %w", err)
+	}
+	return values, nil
+}
+
+// handleFormEcho handles POST /form-echo, which returns the fields of a URL-encoded form as JSON
+func (s *Server) handleFormEcho(req *Request) *Response {
+	values, err := req.ParseForm()
+	if err == errNotURLEncoded {
+		return textResponse(StatusUnsupportedMediaType, err.Error())
+	} else if err != nil {
+		return textResponse(StatusBadRequest, err.Error())
+	}
+
+	content, _ := json.Marshal(values)
+	return &Response{
+		StatusLine: StatusOK,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       content,
+	}
+}
+
+// FormData is a parsed multipart/form-data body
+type FormData struct {
+	Fields map[string][]string
+	Files  map[string][]*UploadedFile
+
+	form *multipart.Form
+}
+
+// UploadedFile is a file of a multipart form
+type UploadedFile struct {
+	Filename    string
+	ContentType string
+	Size        int64
+	Reader      io.Reader
+}
+
+// ParseMultipartForm decodes a multipart/form-data body, keeping up to maxMemory bytes of
+// files in memory and spilling the rest to temporary files, which RemoveAll deletes
+func (req *Request) ParseMultipartForm(maxMemory int64) (*FormData, error) {
+	mediaType, params, err := mime.ParseMediaType(req.Headers["content-type"])
+	if err != nil || mediaType != "multipart/form-data" {
+		return nil, errNotMultipart
+	}
+	if params["boundary"] == "" {
+		return nil, errors.New("multipart body without a boundary")
+	}
+
+	form, err := multipart.NewReader(bytes.NewReader(req.Body), params["boundary"]).ReadForm(maxMemory)
+	if err != nil {
+		return nil, fmt.Errorf("invalid multipart body: %w", err)
+	}
+
+	data := &FormData{Fields: form.Value, Files: make(map[string][]*UploadedFile), form: form}
+	for name, headers := range form.File {
+		for _, header := range headers {
+			file, err := header.Open()
+			if err != nil {
+				data.RemoveAll()
+				return nil, err
+			}
+			data.Files[name] = append(data.Files[name], &UploadedFile{
+				Filename:    header.Filename,
+				ContentType: header.Header.Get("Content-Type"),
+				Size:        header.Size,
+				Reader:      file,
+			})
+		}
+	}
+	return data, nil
+}
+
+// RemoveAll closes the form's files and deletes the temporary files they were spilled to
+func (f *FormData) RemoveAll() error {
+	for _, files := range f.Files {
+		for _, file := range files {
+			if closer, ok := file.Reader.(io.Closer); ok {
+				closer.Close()
+			}
+		}
+	}
+	return f.form.RemoveAll()
+}
+
+// formUploadContent returns the content of the file of a multipart upload, the one of the
+// first field by name if there are several
+func formUploadContent(req *Request) ([]byte, error) {
+	form, err := req.ParseMultipartForm(multipartMaxMemory)
+	if err != nil {
+		return nil, err
+	}
+	defer form.RemoveAll()
+
+	if len(form.Files) == 0 {
+		return nil, errors.New("no file in multipart body")
+	}
+	name := slices.Min(slices.Collect(maps.Keys(form.Files)))
+	return io.ReadAll(form.Files[name][0].Reader)
+}
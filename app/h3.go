@@ -0,0 +1,152 @@
+//go:build h3
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// StartH3 serves HTTP/3 over QUIC on the UDP port, next to the TCP listener started by Start.
+// Experimental: only built with the h3 build tag
+func (s *Server) StartH3(port int, certFile, keyFile string) error {
+	fmt.Println("Starting HTTP/3 server on port", port)
+
+	server := &http3.Server{
+		Addr:    fmt.Sprintf("0.0.0.0:%d", port),
+		Handler: s,
+	}
+	return server.ListenAndServeTLS(certFile, keyFile)
+}
+
+// ServeHTTP bridges net/http to the server's handlers, so it can be used by http3
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body := r.Body
+	if s.MaxBodySize > 0 {
+		body = http.MaxBytesReader(w, r.Body, s.MaxBodySize)
+	}
+	content, err := io.ReadAll(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	headers := make(map[string]string, len(r.Header)+1)
+	for k, v := range r.Header {
+		headers[strings.ToLower(k)] = strings.Join(v, ", ")
+	}
+	headers["host"] = r.Host
+
+	// The handlers speak HTTP/1.1 semantics, the framing is up to http3
+	req := &Request{
+		Method:      r.Method,
+		Path:        r.URL.Path,
+		RawPath:     r.URL.EscapedPath(),
+		RawQuery:    r.URL.RawQuery,
+		Query:       r.URL.Query(),
+		HTTPVersion: "HTTP/1.1",
+		Headers:     headers,
+		Cookies:     r.Cookies(),
+		Body:        content,
+		RemoteAddr:  r.RemoteAddr,
+		TLS:         r.TLS,
+	}
+	started := time.Now()
+
+	// The middleware chain logs the responses it produces
+	response := s.inspectBody(req)
+	if response == nil {
+		response = s.Handler.Handle(req)
+	} else {
+		s.logAccess(req, statusCode(response.StatusLine), int64(len(response.Body)), time.Since(started))
+	}
+
+	for k, v := range response.Headers {
+		w.Header().Set(k, v)
+	}
+	for k, values := range response.MultiHeaders {
+		for _, v := range values {
+			w.Header().Add(k, v)
+		}
+	}
+
+	// Streaming handlers write the response once the chain has let the request through,
+	// connection handlers need a TCP connection
+	if route := response.dispatch; route != nil && route.Handler2 != nil {
+		rw := &httpResponseWriter{w: w, status: http.StatusOK, flushInterval: s.StreamFlushInterval}
+		route.Handler2.Handle2(req, rw)
+		s.logAccess(req, rw.status, rw.written, time.Since(started))
+		return
+	} else if route != nil {
+		response = &Response{StatusLine: StatusNotImplemented}
+		s.logAccess(req, 501, 0, time.Since(started))
+	}
+	if len(response.Body) > 0 {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", "text/plain")
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(response.Body)))
+	}
+	w.WriteHeader(statusCode(response.StatusLine))
+	w.Write(response.Body)
+}
+
+// httpResponseWriter adapts a net/http ResponseWriter for streaming handlers
+type httpResponseWriter struct {
+	w             http.ResponseWriter
+	status        int
+	wroteHeader   bool
+	flushInterval int // see connResponseWriter
+	unflushed     int
+	written       int64 // body bytes written, for the access log
+}
+
+// SetStatus sets the status code, it has no effect once the body is being written
+func (w *httpResponseWriter) SetStatus(code int) {
+	w.status = code
+}
+
+// SetHeader sets a response header, it has no effect once the body is being written
+func (w *httpResponseWriter) SetHeader(key, value string) {
+	w.w.Header().Set(key, value)
+}
+
+// AddHeader adds a value to a header sent once per value, it has no effect once the body
+// is being written
+func (w *httpResponseWriter) AddHeader(key, value string) {
+	w.w.Header().Add(key, value)
+}
+
+// Write sends part of the body
+func (w *httpResponseWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.w.WriteHeader(w.status)
+	}
+	n, err := w.w.Write(data)
+	w.written += int64(n)
+	if err != nil {
+		return n, err
+	}
+	w.unflushed += n
+	if w.flushInterval > 0 && w.unflushed >= w.flushInterval {
+		return n, w.Flush()
+	}
+	return n, nil
+}
+
+// Flush sends everything written so far to the client
+func (w *httpResponseWriter) Flush() error {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.w.WriteHeader(w.status)
+	}
+	w.unflushed = 0
+	return http.NewResponseController(w.w).Flush()
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// handoffTimeout bounds the exchange on the control socket
+const handoffTimeout = 5 * time.Second
+
+// errHandoffRefused is returned when the running server serves a different port
+var errHandoffRefused = errors.New("running server refused to hand its listener over")
+
+// inheritListener serves the listening socket passed in as an open file descriptor
+func inheritListener(fd int) (net.Listener, error) {
+	file := os.NewFile(uintptr(fd), "inherited-listener")
+	if file == nil {
+		return nil, fmt.Errorf("invalid file descriptor %d", fd)
+	}
+	defer file.Close()
+
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inherit listener from file descriptor %d: %w", fd, err)
+	}
+	return listener, nil
+}
+
+// takeOverListener asks the server running on the control socket for its listening socket,
+// so a restart doesn't refuse a single connection; it returns nil when no server answers
+func (s *Server) takeOverListener(port string) (net.Listener, error) {
+	if err := checkControlSocketDir(s.ControlSocket); err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("unix", s.ControlSocket, time.Second)
+	if err != nil {
+		return nil, nil
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(handoffTimeout)); err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(conn, "LISTENER %s\n", port); err != nil {
+		return nil, fmt.Errorf("failed to request listener: %w", err)
+	}
+	listener, err := receiveListener(conn.(*net.UnixConn))
+	if err != nil {
+		return nil, fmt.Errorf("failed to take listener over: %w", err)
+	}
+	// Whatever answered may have sent any socket
+	if addr, ok := listener.Addr().(*net.TCPAddr); !ok || strconv.Itoa(addr.Port) != port {
+		listener.Close()
+		return nil, fmt.Errorf("received a listener on %s instead of port %s", listener.Addr(), port)
+	}
+	fmt.Println("Took the listening socket over from the running server")
+	return listener, nil
+}
+
+// serveControlSocket hands the listener over to the first restarted server asking for it,
+// then stops accepting connections so the ones in flight can finish
+func (s *Server) serveControlSocket(listener net.Listener, port string) {
+	if err := checkControlSocketDir(s.ControlSocket); err != nil {
+		fmt.Println("Error listening on control socket:", err)
+		return
+	}
+	// Left behind by a server that crashed, or by the one that handed over to us
+	os.Remove(s.ControlSocket)
+	control, err := net.Listen("unix", s.ControlSocket)
+	if err != nil {
+		fmt.Println("Error listening on control socket:", err)
+		return
+	}
+	defer control.Close()
+	if err := os.Chmod(s.ControlSocket, 0600); err != nil {
+		fmt.Println("Error restricting control socket:", err)
+		return
+	}
+
+	for {
+		conn, err := control.Accept()
+		if err != nil {
+			fmt.Println("Error accepting control connection:", err)
+			return
+		}
+		if s.handOver(conn.(*net.UnixConn), listener, port) {
+			// The path belongs to the new server from now on
+			control.(*net.UnixListener).SetUnlinkOnClose(false)
+			return
+		}
+	}
+}
+
+// handOver sends the listener to the server asking for it and starts draining
+func (s *Server) handOver(conn *net.UnixConn, listener net.Listener, port string) bool {
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(handoffTimeout)); err != nil {
+		fmt.Println("Error setting control deadline:", err)
+		return false
+	}
+	request, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		fmt.Println("Error reading control request:", err)
+		return false
+	}
+	requestedPort, ok := strings.CutPrefix(strings.TrimSpace(request), "LISTENER ")
+	if !ok || requestedPort != port {
+		fmt.Println("Refusing control request:", strings.TrimSpace(request))
+		conn.Write([]byte{0})
+		return false
+	}
+
+	if err := sendListener(conn, listener); err != nil {
+		fmt.Println("Error handing listener over:", err)
+		return false
+	}
+	fmt.Println("Handed the listening socket over, draining connections")
+	s.draining.Store(true)
+	listener.Close()
+	s.closeIdleConnections()
+	return true
+}
+
+// serveConnection handles a connection accepted by Start, keeping track of it for draining.
+// A handler panicking with http.ErrAbortHandler just closes the connection
+func (s *Server) serveConnection(conn net.Conn) {
+	defer s.connections.Done()
+	defer func() {
+		if err := recover(); err != nil && err != http.ErrAbortHandler {
+			panic(err)
+		}
+	}()
+	s.handleConnection(conn)
+}
@@ -0,0 +1,26 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// errHandoffUnsupported is returned when handing the listener over on a platform without SCM_RIGHTS
+var errHandoffUnsupported = errors.New("listener handoff is only supported on Unix")
+
+// checkControlSocketDir is only available on Unix
+func checkControlSocketDir(path string) error {
+	return errHandoffUnsupported
+}
+
+// sendListener is only available on Unix
+func sendListener(conn *net.UnixConn, listener net.Listener) error {
+	return errHandoffUnsupported
+}
+
+// receiveListener is only available on Unix
+func receiveListener(conn *net.UnixConn) (net.Listener, error) {
+	return nil, errHandoffUnsupported
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOversizedHeadersGet431(t *testing.T) {
+	s := newTestServer(t)
+	addr := startTestServer(t, s)
+
+	header := "X-Large: " + strings.Repeat("a", 64<<10) + "\r\n"
+	response, _ := roundTrip(t, addr, "GET /echo/hi HTTP/1.1\r\nHost: localhost\r\n"+header+"\r\n")
+	if response.StatusCode != 431 {
+		t.Fatalf("got status %d, want 431", response.StatusCode)
+	}
+	if !response.Close {
+		t.Fatal("connection kept open after 431")
+	}
+}
+
+func TestManyHeadersGet431(t *testing.T) {
+	s := newTestServer(t)
+	addr := startTestServer(t, s)
+
+	headers := strings.Repeat("X-Small: value\r\n", 1000)
+	response, _ := roundTrip(t, addr, "GET /echo/hi HTTP/1.1\r\nHost: localhost\r\n"+headers+"\r\n")
+	if response.StatusCode != 431 {
+		t.Fatalf("got status %d, want 431", response.StatusCode)
+	}
+}
+
+func TestHeadersWithinMaxHeaderBytes(t *testing.T) {
+	s := newTestServer(t)
+	s.MaxHeaderBytes = 128 << 10
+	addr := startTestServer(t, s)
+
+	header := "X-Large: " + strings.Repeat("a", 64<<10) + "\r\n"
+	response, _ := roundTrip(t, addr, "GET /echo/hi HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n"+header+"\r\n")
+	if response.StatusCode != 200 {
+		t.Fatalf("got status %d, want 200", response.StatusCode)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// PIIPatterns matches common kinds of personally identifiable information
+var PIIPatterns = map[string]*regexp.Regexp{
+	"credit card number":     regexp.MustCompile(`\b(?:\d[ -]?){13,16}\b`),
+	"social security number": regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`),
+	"IBAN":                   regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]{4}){3,7}\b`),
+}
+
+// RegexBodyInspector returns a BodyInspector rejecting bodies that match any of the named patterns
+func RegexBodyInspector(patterns map[string]*regexp.Regexp) func(req *Request, body []byte) error {
+	return func(req *Request, body []byte) error {
+		for name, pattern := range patterns {
+			if pattern.Match(body) {
+				return fmt.Errorf("request body contains a %s", name)
+			}
+		}
+		return nil
+	}
+}
+
+// inspectBody runs the BodyInspector and returns the rejection response, or nil if the body is accepted
+func (s *Server) inspectBody(req *Request) *Response {
+	if s.BodyInspector == nil || len(req.Body) == 0 {
+		return nil
+	}
+
+	err := s.BodyInspector(req, req.Body)
+	if err == nil {
+		return nil
+	}
+
+	fmt.Println("Request body rejected by inspector:", err)
+	return &Response{
+		StatusLine: StatusUnprocessableEntity,
+		Headers:    make(map[string]string),
+		Body:       []byte(err.Error()),
+	}
+}
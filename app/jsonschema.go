@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"path/filepath"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// schemaError is one validation failure reported to the client
+type schemaError struct {
+	Field       string `json:"field"`
+	Description string `json:"description"`
+}
+
+// JSONSchemaMiddleware validates the JSON body of POST and PUT requests against the schema
+// in schemaFile, answering 422 Unprocessable Entity with the list of violations
+func JSONSchemaMiddleware(schemaFile string) Middleware {
+	path, err := filepath.Abs(schemaFile)
+	if err != nil {
+		panic(fmt.Sprintf("invalid JSON schema file %q: %v", schemaFile, err))
+	}
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewReferenceLoader("file://" + filepath.ToSlash(path)))
+	if err != nil {
+		panic(fmt.Sprintf("invalid JSON schema %s: %v", schemaFile, err))
+	}
+
+	return func(next Handler) Handler {
+		return HandlerFunc(func(req *Request) *Response {
+			if req.Method != "POST" && req.Method != "PUT" {
+				return next.Handle(req)
+			}
+			if mediaType, _, _ := mime.ParseMediaType(req.Headers["content-type"]); mediaType != "application/json" {
+				return next.Handle(req)
+			}
+
+			result, err := schema.Validate(gojsonschema.NewBytesLoader(req.Body))
+			if err != nil {
+				return schemaErrorResponse([]schemaError{{Field: "(root)", Description: err.Error()}})
+			}
+			if !result.Valid() {
+				errs := make([]schemaError, 0, len(result.Errors()))
+				for _, e := range result.Errors() {
+					errs = append(errs, schemaError{Field: e.Field(), Description: e.Description()})
+				}
+				return schemaErrorResponse(errs)
+			}
+			return next.Handle(req)
+		})
+	}
+}
+
+// schemaErrorResponse builds the 422 response listing the validation errors
+func schemaErrorResponse(errs []schemaError) *Response {
+	content, _ := json.Marshal(map[string]any{"errors": errs})
+	return &Response{
+		StatusLine: StatusUnprocessableEntity,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       content,
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// maxSimulatedLatency caps the injected latency, so a stray setting can't hang clients
+const maxSimulatedLatency = 30 * time.Second
+
+// latencyParam is the query parameter overriding the latency of a single request
+const latencyParam = "__latency"
+
+// LatencyMiddleware delays every response by base plus a random duration up to jitter, to
+// test how clients cope with a slow server. A request can ask for its own delay with
+// ?__latency=500ms, which handlers don't see
+func LatencyMiddleware(base, jitter time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(req *Request) *Response {
+			delay := base
+			if jitter > 0 {
+				delay += time.Duration(rand.Int63n(int64(jitter)))
+			}
+			if req.Query.Has(latencyParam) {
+				override, err := time.ParseDuration(req.Query.Get(latencyParam))
+				if err != nil || override < 0 {
+					return textResponse(StatusBadRequest, fmt.Sprintf("invalid %s, expected a duration like 500ms", latencyParam))
+				}
+				delay = override
+				req.Query.Del(latencyParam)
+				req.RawQuery = req.Query.Encode()
+			}
+
+			response := next.Handle(req)
+			time.Sleep(min(delay, maxSimulatedLatency))
+			return response
+		})
+	}
+}
+
+// latencyMiddleware applies LatencyMiddleware with LatencyBase and LatencyJitter while
+// SimulateLatency is set
+func (s *Server) latencyMiddleware(next Handler) Handler {
+	return HandlerFunc(func(req *Request) *Response {
+		if !s.SimulateLatency {
+			return next.Handle(req)
+		}
+		return LatencyMiddleware(s.LatencyBase, s.LatencyJitter)(next).Handle(req)
+	})
+}
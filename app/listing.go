@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultListPerPage is the page size of a directory listing unless the client asks otherwise
+const defaultListPerPage = 50
+
+// maxListPerPage is the largest page size a client may ask for
+const maxListPerPage = 1000
+
+// fileListEntry describes a single file in a directory listing
+type fileListEntry struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	IsDir    bool      `json:"is_dir"`
+}
+
+// fileListPage is the JSON envelope of a directory listing page
+type fileListPage struct {
+	Files   []fileListEntry `json:"files"`
+	Total   int             `json:"total"`
+	Page    int             `json:"page"`
+	PerPage int             `json:"per_page"`
+	HasNext bool            `json:"has_next"`
+}
+
+// handleFileList lists the directory as JSON, sorted and paginated by the query parameters
+func (s *Server) handleFileList(req *Request, w ResponseWriter) {
+	page, perPage, err := listPagination(req.Query)
+	if err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
+
+	sortKey := req.Query.Get("sort")
+	if sortKey == "" {
+		sortKey = "name"
+	}
+	less, ok := fileListOrders[sortKey]
+	if !ok {
+		writeError(w, 400, fmt.Sprintf("invalid sort %q, expected name, size or modified", sortKey))
+		return
+	}
+	order := req.Query.Get("order")
+	if order != "" && order != "asc" && order != "desc" {
+		writeError(w, 400, fmt.Sprintf("invalid order %q, expected asc or desc", order))
+		return
+	}
+
+	dirEntries, err := s.dirCache.ReadDir(s.Directory, s.DirCacheTTL)
+	if err != nil {
+		fmt.Println("Error reading directory:", err)
+		w.SetStatus(500)
+		return
+	}
+
+	files := make([]fileListEntry, 0, len(dirEntries))
+	for _, entry := range dirEntries {
+		if isInternalPath(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, fileListEntry{
+			Name:     entry.Name(),
+			Size:     info.Size(),
+			Modified: info.ModTime().UTC(),
+			IsDir:    entry.IsDir(),
+		})
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		if order == "desc" {
+			return less(files[j], files[i])
+		}
+		return less(files[i], files[j])
+	})
+
+	start := min((page-1)*perPage, len(files))
+	end := min(start+perPage, len(files))
+	result := fileListPage{
+		Files:   files[start:end],
+		Total:   len(files),
+		Page:    page,
+		PerPage: perPage,
+		HasNext: end < len(files),
+	}
+
+	// Point the client at the next page
+	if result.HasNext {
+		next := url.Values{}
+		for k, v := range req.Query {
+			next[k] = v
+		}
+		next.Set("page", strconv.Itoa(page+1))
+		next.Set("per_page", strconv.Itoa(perPage))
+		w.SetHeader("Link", fmt.Sprintf("<%s?%s>; rel=\"next\"", req.Path, next.Encode()))
+	}
+
+	body, err := json.Marshal(result)
+	if err != nil {
+		fmt.Println("Error encoding file list:", err)
+		w.SetStatus(500)
+		return
+	}
+	w.SetHeader("Content-Type", "application/json")
+	w.SetHeader("Content-Length", strconv.Itoa(len(body)))
+	w.Write(body)
+}
+
+// fileListOrders compares listing entries for each supported sort key
+var fileListOrders = map[string]func(a, b fileListEntry) bool{
+	"name": func(a, b fileListEntry) bool {
+		return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+	},
+	"size": func(a, b fileListEntry) bool {
+		return a.Size < b.Size
+	},
+	"modified": func(a, b fileListEntry) bool {
+		return a.Modified.Before(b.Modified)
+	},
+}
+
+// listPagination parses and validates the page and per_page query parameters
+func listPagination(query url.Values) (int, int, error) {
+	page, perPage := 1, defaultListPerPage
+
+	if value := query.Get("page"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return 0, 0, fmt.Errorf("invalid page %q", value)
+		}
+		page = n
+	}
+	if value := query.Get("per_page"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxListPerPage {
+			return 0, 0, fmt.Errorf("per_page must be between 1 and %d", maxListPerPage)
+		}
+		perPage = n
+	}
+	return page, perPage, nil
+}
+
+// writeError writes a plain text error response from a streaming handler
+func writeError(w ResponseWriter, code int, message string) {
+	w.SetStatus(code)
+	w.SetHeader("Content-Type", "text/plain")
+	w.SetHeader("Content-Length", strconv.Itoa(len(message)))
+	w.Write([]byte(message))
+}
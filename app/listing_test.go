@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listTestServer serves a.txt, b.txt and c.txt, each first in a different sort order
+func listTestServer(t *testing.T) string {
+	t.Helper()
+	s := newTestServer(t)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	files := []struct {
+		name     string
+		content  string
+		modified time.Time
+	}{
+		{"a.txt", "aaa", base.Add(time.Hour)},
+		{"b.txt", "b", base.Add(2 * time.Hour)},
+		{"c.txt", "cc", base},
+	}
+	for _, f := range files {
+		path := filepath.Join(s.Directory, f.name)
+		if err := os.WriteFile(path, []byte(f.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, f.modified, f.modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return startTestServer(t, s)
+}
+
+// getFileList requests a listing page
+func getFileList(t *testing.T, addr, query string) (fileListPage, string) {
+	t.Helper()
+	response, body := roundTrip(t, addr, "GET /files/?list=1"+query+" HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	if response.StatusCode != 200 {
+		t.Fatalf("got status %d: %s", response.StatusCode, body)
+	}
+	var page fileListPage
+	if err := json.Unmarshal(body, &page); err != nil {
+		t.Fatal(err)
+	}
+	return page, response.Header.Get("Link")
+}
+
+func TestFileListSorting(t *testing.T) {
+	addr := listTestServer(t)
+	tests := []struct {
+		sort string
+		want []string // ascending
+	}{
+		{"name", []string{"a.txt", "b.txt", "c.txt"}},
+		{"size", []string{"b.txt", "c.txt", "a.txt"}},
+		{"modified", []string{"c.txt", "a.txt", "b.txt"}},
+	}
+	for _, tt := range tests {
+		for _, order := range []string{"asc", "desc"} {
+			t.Run(tt.sort+"/"+order, func(t *testing.T) {
+				page, _ := getFileList(t, addr, "&sort="+tt.sort+"&order="+order)
+				var names []string
+				for _, f := range page.Files {
+					names = append(names, f.Name)
+				}
+				want := slices.Clone(tt.want)
+				if order == "desc" {
+					slices.Reverse(want)
+				}
+				if !slices.Equal(names, want) {
+					t.Fatalf("got %v, want %v", names, want)
+				}
+			})
+		}
+	}
+}
+
+func TestFileListPagination(t *testing.T) {
+	addr := listTestServer(t)
+
+	page, link := getFileList(t, addr, "&per_page=2")
+	if len(page.Files) != 2 || page.Total != 3 || page.Page != 1 || page.PerPage != 2 || !page.HasNext {
+		t.Fatalf("got first page %+v", page)
+	}
+	if !strings.Contains(link, "page=2") || !strings.HasSuffix(link, `rel="next"`) {
+		t.Fatalf("got Link %q", link)
+	}
+
+	page, link = getFileList(t, addr, "&per_page=2&page=2")
+	if len(page.Files) != 1 || page.Files[0].Name != "c.txt" || page.HasNext || link != "" {
+		t.Fatalf("got last page %+v, Link %q", page, link)
+	}
+}
+
+func TestFileListRejectsInvalidParameters(t *testing.T) {
+	addr := listTestServer(t)
+	for _, query := range []string{"&per_page=0", "&per_page=1001", "&page=0", "&sort=color", "&order=up"} {
+		response, _ := roundTrip(t, addr, "GET /files/?list=1"+query+" HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+		if response.StatusCode != 400 {
+			t.Errorf("%s: got status %d, want 400", query, response.StatusCode)
+		}
+	}
+}
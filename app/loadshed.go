@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// heapResumeRatio is the fraction of MaxHeapBytes the heap has to shrink back to before
+// connections are accepted again, so shedding doesn't flap around the threshold
+const heapResumeRatio = 0.8
+
+// monitorHeap checks the heap every second, shedding new connections while it is above
+// MaxHeapBytes and until it drops back below heapResumeRatio of it
+func (s *Server) monitorHeap() {
+	ctx := s.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	resume := uint64(float64(s.MaxHeapBytes) * heapResumeRatio)
+	var stats runtime.MemStats
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		runtime.ReadMemStats(&stats)
+		switch {
+		case stats.HeapInuse > s.MaxHeapBytes && !s.shedding.Load():
+			fmt.Printf("Heap in use %d bytes is over %d, shedding new connections\n", stats.HeapInuse, s.MaxHeapBytes)
+			s.shedding.Store(true)
+		case stats.HeapInuse < resume && s.shedding.Load():
+			fmt.Printf("Heap in use %d bytes is back under %d, accepting connections\n", stats.HeapInuse, resume)
+			s.shedding.Store(false)
+		}
+	}
+}
@@ -4,39 +4,401 @@ import (
 	"bufio"
 	"bytes"
 	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 // HTTP status codes
-const (
-	StatusOK                  = "HTTP/1.1 200 OK"
-	StatusCreated             = "HTTP/1.1 201 Created"
-	StatusBadRequest          = "HTTP/1.1 400 Bad Request"
-	StatusNotFound            = "HTTP/1.1 404 Not Found"
-	StatusMethodNotAllowed    = "HTTP/1.1 405 Not Allowed"
-	StatusConflict            = "HTTP/1.1 409 Conflict"
-	StatusUpgradeRequired     = "HTTP/1.1 426 Upgrade Required"
-	StatusInternalServerError = "HTTP/1.1 500 Internal Server Error"
+var (
+	StatusContinue                    = StatusLine(100, "Continue")
+	StatusSwitchingProtocols          = StatusLine(101, "Switching Protocols")
+	StatusOK                          = StatusLine(200, "OK")
+	StatusCreated                     = StatusLine(201, "Created")
+	StatusAccepted                    = StatusLine(202, "Accepted")
+	StatusNoContent                   = StatusLine(204, "No Content")
+	StatusPartialContent              = StatusLine(206, "Partial Content")
+	StatusMultiStatus                 = StatusLine(207, "Multi-Status")
+	StatusIMUsed                      = StatusLine(226, "IM Used")
+	StatusMovedPermanently            = StatusLine(301, "Moved Permanently")
+	StatusFound                       = StatusLine(302, "Found")
+	StatusNotModified                 = StatusLine(304, "Not Modified")
+	StatusTemporaryRedirect           = StatusLine(307, "Temporary Redirect")
+	StatusBadRequest                  = StatusLine(400, "Bad Request")
+	StatusUnauthorized                = StatusLine(401, "Unauthorized")
+	StatusForbidden                   = StatusLine(403, "Forbidden")
+	StatusNotFound                    = StatusLine(404, "Not Found")
+	StatusMethodNotAllowed            = StatusLine(405, "Not Allowed")
+	StatusConflict                    = StatusLine(409, "Conflict")
+	StatusContentTooLarge             = StatusLine(413, "Content Too Large")
+	StatusUnsupportedMediaType        = StatusLine(415, "Unsupported Media Type")
+	StatusRangeNotSatisfiable         = StatusLine(416, "Range Not Satisfiable")
+	StatusExpectationFailed           = StatusLine(417, "Expectation Failed")
+	StatusUnprocessableEntity         = StatusLine(422, "Unprocessable Entity")
+	StatusTooManyRequests             = StatusLine(429, "Too Many Requests")
+	StatusRequestHeaderFieldsTooLarge = StatusLine(431, "Request Header Fields Too Large")
+	StatusUpgradeRequired             = StatusLine(426, "Upgrade Required")
+	StatusInternalServerError         = StatusLine(500, "Internal Server Error")
+	StatusNotImplemented              = StatusLine(501, "Not Implemented")
+	StatusBadGateway                  = StatusLine(502, "Bad Gateway")
+	StatusServiceUnavailable          = StatusLine(503, "Service Unavailable")
+	StatusInsufficientStorage         = StatusLine(507, "Insufficient Storage")
 )
 
+// StatusLine builds a status line with the given reason phrase, which may be non-standard
+func StatusLine(code int, reason string) string {
+	return fmt.Sprintf("HTTP/1.1 %d %s", code, reason)
+}
+
+// statusLineFor builds the status line for a numeric status code
+func statusLineFor(code int) string {
+	return StatusLine(code, http.StatusText(code))
+}
+
+// withCustomReason replaces the reason phrase of a status line when the code has a custom one
+func withCustomReason(statusLine string, reasons map[int]string) string {
+	code := statusCode(statusLine)
+	if reason, ok := reasons[code]; ok {
+		return StatusLine(code, reason)
+	}
+	return statusLine
+}
+
+// statusCode extracts the numeric status code from a status line
+func statusCode(statusLine string) int {
+	fields := strings.Fields(statusLine)
+	if len(fields) < 2 {
+		return 0
+	}
+	code, _ := strconv.Atoi(fields[1])
+	return code
+}
+
 // Server represents an HTTP server
 type Server struct {
 	Directory string
 	Handler   Handler
+	Router    *Router
+
+	// LenientParsing accepts request lines that were split across several lines
+	// by a misbehaving proxy, joining the continuation lines back together
+	LenientParsing bool
+
+	// MaxHeaderBytes limits the size of the request line and headers; larger requests get
+	// 431 Request Header Fields Too Large. Zero means no limit
+	MaxHeaderBytes int
+
+	// Compression controls which responses compressionMiddleware leaves untouched
+	Compression CompressionOptions
+	// CompressionThreshold is the smallest body compressionMiddleware compresses, in bytes
+	CompressionThreshold int
+
+	// AcceptProxyProtocol expects every connection to start with a PROXY protocol v1 or v2
+	// header and uses the client address from it
+	AcceptProxyProtocol bool
+
+	// TLSConfig holds the certificates used when a cleartext connection is upgraded to TLS
+	TLSConfig *tls.Config
+	// AllowSTARTTLS upgrades cleartext connections that send Upgrade: TLS/1.x (RFC 2817)
+	AllowSTARTTLS bool
+	// ForceHTTPS redirects plain HTTP requests sent with Upgrade-Insecure-Requests to HTTPS
+	ForceHTTPS bool
+
+	// SimulateSlowReads throttles reading request bodies to SlowReadBytesPerSecond, for
+	// testing timeouts during development; never enable it in production
+	SimulateSlowReads bool
+	// SlowReadBytesPerSecond is the body read rate used when SimulateSlowReads is on
+	SlowReadBytesPerSecond int64
+
+	// ReadRetries is how many times an interrupted (EINTR) body read is retried
+	ReadRetries int
+
+	// PrewarmCompression writes a gzip sidecar in the background for every uploaded file
+	PrewarmCompression bool
+	// PrewarmWorkers limits how many sidecars are compressed at the same time
+	PrewarmWorkers int
+	// UsePrecompressed serves the up to date gzip sidecar kept under .gzcache to clients
+	// accepting gzip
+	UsePrecompressed bool
+
+	// DeduplicateUploads hard links an upload to an earlier file with the same content
+	// instead of storing another copy
+	DeduplicateUploads bool
+
+	// MaxExtractSize caps the total size of the files extracted from an archive by
+	// POST /files/?extract=1; zero means no limit
+	MaxExtractSize int64
+
+	// MaxBulkStatFiles caps how many files POST /files/?stat=1 accepts in one request
+	MaxBulkStatFiles int
+
+	// AllowedUploadExtensions, when set, is the only file extensions uploads may have, e.g. ".txt"
+	AllowedUploadExtensions []string
+	// BlockedUploadExtensions are file extensions uploads may never have, e.g. ".exe"
+	BlockedUploadExtensions []string
+
+	// DirectoryQuotaBytes caps the total size of the files in the directory; uploads that
+	// would exceed it get 507 Insufficient Storage. Zero means no limit
+	DirectoryQuotaBytes int64
+
+	// EncryptionKey encrypts uploaded files at rest with AES-GCM; it must be 32 bytes for
+	// AES-256. Downloads are decrypted transparently
+	EncryptionKey []byte
+
+	// DeltaEncoding keeps recently downloaded file versions in memory, so clients sending
+	// A-IM: vcdiff with the ETag of one of them get a VCDIFF delta instead (RFC 3229)
+	DeltaEncoding bool
+
+	// VersionFiles moves a file about to be overwritten to .versions/<name>/<timestamp>
+	// instead of losing its content
+	VersionFiles bool
+	// MaxVersionsPerFile is how many old versions are kept per file, the oldest are deleted
+	MaxVersionsPerFile int
+
+	// MaxConcurrentConnections limits how many connections are served at once, by as many
+	// worker goroutines, queueing the rest; 256 per CPU by default. Zero serves every
+	// connection immediately in a goroutine of its own
+	MaxConcurrentConnections int
+	// QueueMode selects which queued connection is served next and what happens when the
+	// queue is full; Block by default, so a burst waits to be accepted instead of being dropped
+	QueueMode QueueMode
+
+	// MaxPipelineDepth limits how many requests a client may pipeline on a connection before
+	// waiting for the responses; the excess gets 503 Service Unavailable. Zero means no limit
+	MaxPipelineDepth int
+
+	// ContinueHandler decides whether a request sent with Expect: 100-continue may send its body
+	ContinueHandler func(req *RequestHeaders) bool
+	// MaxBodySize is the largest request body read, larger ones get 413 Content Too Large
+	// before any of it is; zero means no limit
+	MaxBodySize int64
+
+	// OpenAPISpec is the path of a YAML or JSON OpenAPI spec served at /openapi.json, /openapi.yaml and /docs
+	OpenAPISpec string
+
+	// BodyInspector checks every request body before handlers see it, rejecting it with
+	// 422 Unprocessable Entity when it returns an error
+	BodyInspector func(req *Request, body []byte) error
+
+	// MockFile is a YAML file of interactions served instead of the real handlers
+	MockFile string
+	// RecordMode serves the real handlers and records every interaction to MockFile
+	RecordMode bool
+
+	// SearchMaxResults caps the number of matches returned by GET /files/?search=
+	SearchMaxResults int
+	// SearchTimeout stops a search that runs longer than this
+	SearchTimeout time.Duration
+
+	// DirCacheTTL is how long a directory listing is served from the cache; files changed
+	// through the server's own endpoints invalidate it right away
+	DirCacheTTL time.Duration
+
+	// MaxSendBytes caps the size of response bodies: file downloads are cut short into a 206
+	// Partial Content, other bodies are truncated; zero means no limit
+	MaxSendBytes int64
+
+	// AllowedFetchHosts are the hosts POST /files/fetch may download from; empty refuses every fetch
+	AllowedFetchHosts []string
+	// MaxFetchSize caps the size of a file downloaded by POST /files/fetch
+	MaxFetchSize int64
+	// FetchTimeout bounds a download by POST /files/fetch, including reading the body
+	FetchTimeout time.Duration
+
+	// MaxDiffFileSize caps the size of the files GET /files/compare diffs
+	MaxDiffFileSize int64
+
+	// UploadTTL is how long a resumable upload may go without receiving a chunk before it is deleted
+	UploadTTL time.Duration
+
+	// WatchInterval is how often GET /files/{name}?watch=1 checks the file for changes
+	WatchInterval time.Duration
+	// MaxWatchers caps the watches waiting at once; more get 503 Service Unavailable
+	MaxWatchers int
+
+	// EnableRouteList serves the list of registered routes at GET /__routes
+	EnableRouteList bool
+
+	// SimulateLatency delays every response by LatencyBase plus up to LatencyJitter, or by the
+	// request's ?__latency=500ms, at most 30 seconds; for development only
+	SimulateLatency bool
+	LatencyBase     time.Duration
+	LatencyJitter   time.Duration
+
+	// CORS adds CORS headers to responses and answers preflight requests; nil leaves
+	// cross-origin requests to the browser's same-origin policy. Set it to new options
+	// rather than changing the ones in use, which are only read once
+	CORS *CORSOptions
+
+	// StreamFlushInterval is how many bytes a streaming handler may write before they are
+	// flushed to the client
+	StreamFlushInterval int
+
+	// WALFile is the write-ahead log of uploads, whose partial files are deleted on the next
+	// start after a crash; .wal in Directory by default, disabled when empty
+	WALFile string
+
+	// AccessLog receives a line per request, os.Stdout by default; nil disables it
+	AccessLog io.Writer
+
+	// Debug logs routine events that are otherwise left out to keep the log readable
+	Debug bool
+
+	// AdminToken enables the admin endpoints, which require it as a bearer token
+	AdminToken string
+
+	// AuditLog receives a record of every sensitive operation, separate from the access log
+	AuditLog *slog.Logger
+	// AuditHMAC is the key used to sign each audit record so tampering can be detected
+	AuditHMAC string
+
+	// PresignKey signs the URLs issued by POST /files/presign; a random key is generated when
+	// empty, so URLs don't survive a restart
+	PresignKey []byte
+
+	// CustomStatusReasons overrides the reason phrase sent for a status code, e.g. {200: "Proceed"}
+	CustomStatusReasons map[int]string
+
+	// EnableWatchdog reports goroutines serving a connection that stay blocked in the same
+	// call for longer than WatchdogTimeout
+	EnableWatchdog bool
+	// WatchdogTimeout is how long a goroutine may stay blocked, 30 seconds by default
+	WatchdogTimeout time.Duration
+	// WatchdogKillOnStuck exits the process when the watchdog finds a stuck goroutine,
+	// so a supervisor can restart it
+	WatchdogKillOnStuck bool
+
+	// MaxHeapBytes is the heap size above which new connections get 503 Service Unavailable,
+	// until the heap shrinks back to 80% of it; zero disables load shedding
+	MaxHeapBytes uint64
+
+	// Prefork runs a child process per CPU, all serving the port through SO_REUSEPORT. Linux only
+	Prefork bool
+	// IsPreforkChild is set in the processes started by Prefork, from the environment
+	IsPreforkChild bool
+
+	// ControlSocket is the Unix socket on which a restarted server takes the listening socket
+	// over from the running one, which then drains its connections and exits; empty disables it.
+	// Its directory must belong to the user running the server and be closed to everyone else
+	ControlSocket string
+	// InheritFD serves the listening socket passed in as this file descriptor instead of binding the port
+	InheritFD int
+
+	// Context stops the server's background listeners, such as the UDP health check, when cancelled
+	Context context.Context
+
+	openAPI      *openAPISpec
+	mocks        *mockStore
+	uploads      sync.Map // upload ID -> *uploadProgress
+	uploadHashes sync.Map // SHA-256 of the content -> path of the first upload
+	archiveJobs  sync.Map // job ID -> *archiveJob
+	dirCache     DirCache
+	stats        statCache
+	deltaBases   deltaBases
+	connRate     connRate
+	usage        storageUsage
+	shedding     atomic.Bool
+	watchers     atomic.Int64
+	draining     atomic.Bool
+	connections  sync.WaitGroup // connections accepted and not closed yet
+	activeConns  sync.Map       // net.Conn -> *atomic.Bool, whether it waits for a request
+	listener     net.Listener
+	listenerMu   sync.Mutex
+	// presignedTokens holds the pre-signed URL tokens not used yet, with their expiry
+	presignedTokens sync.Map
+	presignOnce     sync.Once
+	// resumableUploads holds the resumable uploads not completed yet, by Upload-Id
+	resumableUploads sync.Map
+	prewarmOnce      sync.Once
+	prewarmSem       chan struct{}
+	// wal is the write-ahead log opened at WALFile
+	wal *writeAheadLog
+	// tlsCert is the certificate set by SetTLSCert, loaded from tlsCertFile and tlsKeyFile
+	tlsCertMu   sync.RWMutex
+	tlsCert     *tls.Certificate
+	tlsCertFile string
+	tlsKeyFile  string
+	// readerPool recycles connection readers, so short-lived connections don't each
+	// allocate a buffer
+	readerPool sync.Pool
+	// cors is the policy prepared from corsOpts, the CORS it was last prepared for
+	corsMu   sync.Mutex
+	cors     *corsPolicy
+	corsOpts *CORSOptions
+}
+
+// CompressionOptions configures response compression
+type CompressionOptions struct {
+	// ExcludeExtensions lists file extensions (e.g. ".jpg") that are never compressed
+	ExcludeExtensions []string
+	// ExcludePaths lists path prefixes that are never compressed
+	ExcludePaths []string
 }
 
+// defaultCompressionExcludeExtensions lists formats that are already compressed
+var defaultCompressionExcludeExtensions = []string{
+	".jpg", ".jpeg", ".png", ".gif", ".webp", ".zip", ".gz", ".bz2", ".xz", ".7z", ".webm", ".mp4", ".mp3",
+}
+
+// preforkChildEnv marks the processes started by Server.Prefork, so they don't fork again
+const preforkChildEnv = "HTTP_SERVER_PREFORK_CHILD"
+
 // NewServer creates a new HTTP server
 func NewServer(directory string) *Server {
 	server := &Server{
-		Directory: directory,
+		Directory:                directory,
+		Router:                   NewRouter(),
+		IsPreforkChild:           os.Getenv(preforkChildEnv) == "1",
+		MaxConcurrentConnections: runtime.NumCPU() * 256,
+		QueueMode:                Block,
+		Compression: CompressionOptions{
+			ExcludeExtensions: defaultCompressionExcludeExtensions,
+		},
+		CompressionThreshold: 1400,
+		ReadRetries:          3,
+		SearchMaxResults:     100,
+		SearchTimeout:        10 * time.Second,
+		DirCacheTTL:          5 * time.Second,
+		PrewarmWorkers:       2,
+		MaxExtractSize:       1 << 30,
+		MaxBodySize:          1 << 30,
+		MaxPipelineDepth:     10,
+		MaxHeaderBytes:       8 << 10,
+		MaxVersionsPerFile:   5,
+		MaxBulkStatFiles:     100,
+		WatchInterval:        500 * time.Millisecond,
+		MaxWatchers:          100,
+		UploadTTL:            time.Hour,
+		MaxFetchSize:         100 << 20,
+		FetchTimeout:         30 * time.Second,
+		MaxDiffFileSize:      1 << 20,
+		StreamFlushInterval:  4 << 10,
+		AccessLog:            os.Stdout,
 	}
+	if directory != "" {
+		server.WALFile = filepath.Join(directory, walFileName)
+	}
+	server.ContinueHandler = server.approveContinue
+	server.registerRoutes()
 	server.Handler = server.createMiddlewareChain()
 	return server
 }
@@ -44,17 +406,61 @@ func NewServer(directory string) *Server {
 // Request represents an HTTP request
 type Request struct {
 	Method      string
-	Path        string
+	Path        string // decoded, routes match against it
+	RawPath     string // as sent by the client, still percent-encoded
+	RawQuery    string
+	Query       url.Values
 	HTTPVersion string
 	Headers     map[string]string
+	Cookies     []*http.Cookie
 	Body        []byte
+	RemoteAddr  string
+	PathParams  map[string]string
+	TLS         *tls.ConnectionState // nil on plain HTTP connections
+	Ctx         context.Context      // request-scoped values set by middleware, see Context
+}
+
+// Context returns the request's context, the background context until middleware sets one
+func (r *Request) Context() context.Context {
+	if r.Ctx == nil {
+		return context.Background()
+	}
+	return r.Ctx
+}
+
+// RequestHeaders holds the request line and headers of a request whose body hasn't been read yet
+type RequestHeaders struct {
+	Method      string
+	Path        string
+	HTTPVersion string
+	Headers     map[string]string
 }
 
 // Response represents an HTTP response
 type Response struct {
 	StatusLine string
 	Headers    map[string]string
-	Body       string
+	// MultiHeaders holds headers sent once per value, such as Set-Cookie, in addition to Headers
+	MultiHeaders map[string][]string
+	Body         []byte
+	// NoBuffer sends the response to the client as soon as it is written, even when written
+	// through a streaming handler's ResponseWriter
+	NoBuffer bool
+	// Chunked sends the body with chunked transfer encoding instead of a Content-Length.
+	// Bodies of unknown size are better written chunk by chunk by a streaming handler
+	Chunked bool
+
+	// dispatch is the streaming or connection route routingMiddleware matched. The chain only
+	// vets such requests, the route's handler writes the response once the chain lets it through
+	dispatch *Route
+}
+
+// AddHeader adds a value to a header that may be sent several times
+func (r *Response) AddHeader(key, value string) {
+	if r.MultiHeaders == nil {
+		r.MultiHeaders = make(map[string][]string)
+	}
+	r.MultiHeaders[key] = append(r.MultiHeaders[key], value)
 }
 
 // Handler is an interface for handling HTTP requests
@@ -70,7 +476,9 @@ func (f HandlerFunc) Handle(req *Request) *Response {
 	return f(req)
 }
 
-// Middleware wraps a handler with additional functionality
+// Middleware wraps a handler with additional functionality. A middleware stops the chain by
+// returning its own response without calling the next handler, as the rate limiters do; the
+// middleware outside it still see that response
 type Middleware func(Handler) Handler
 
 // Chain combines multiple middleware into a single middleware
@@ -85,33 +493,161 @@ func Chain(middlewares ...Middleware) Middleware {
 
 // Start starts the HTTP server on the specified port
 func (s *Server) Start(port string) error {
+	return s.start(port, nil)
+}
+
+// start serves the port, over TLS when tlsConfig is set
+func (s *Server) start(port string, tlsConfig *tls.Config) error {
 	fmt.Println("Starting HTTP server on port", port)
 	if s.Directory != "" {
 		fmt.Println("Directory:", s.Directory)
 	}
 
-	listener, err := net.Listen("tcp", "0.0.0.0:"+port)
-	if err != nil {
-		return fmt.Errorf("failed to bind to port %s: %w", port, err)
+	if s.OpenAPISpec != "" {
+		spec, err := loadOpenAPISpec(s.OpenAPISpec)
+		if err != nil {
+			return err
+		}
+		s.openAPI = spec
+	}
+
+	if s.MockFile != "" {
+		mocks, err := loadMockStore(s.MockFile, s.RecordMode)
+		if err != nil {
+			return err
+		}
+		s.mocks = mocks
+	}
+
+	if s.EnableWatchdog {
+		go s.runWatchdog()
+	}
+	if s.MaxHeapBytes > 0 {
+		go s.monitorHeap()
+	}
+	if s.UploadTTL > 0 {
+		go s.expireUploads()
+	}
+
+	// The parent of a prefork only supervises the children serving the port
+	if s.Prefork && !s.IsPreforkChild {
+		if s.WALFile != "" {
+			if err := recoverWAL(s.WALFile, s.Directory); err != nil {
+				return fmt.Errorf("failed to recover write-ahead log: %w", err)
+			}
+		}
+		return s.runPreforkParent()
+	}
+
+	s.RegisterSignalReload(syscall.SIGHUP, s.reloadConfig)
+
+	var listener net.Listener
+	var err error
+	if s.InheritFD > 0 {
+		listener, err = inheritListener(s.InheritFD)
+		if err != nil {
+			return err
+		}
+	} else if s.ControlSocket != "" && !s.Prefork {
+		listener, err = s.takeOverListener(port)
+		if err != nil {
+			fmt.Println("Error taking over from the running server, binding the port instead:", err)
+		}
+	}
+
+	// Only the first server on the port may clean up after a crash, the others share the log
+	if s.WALFile != "" {
+		s.wal, err = openWAL(s.WALFile, s.Directory, listener == nil && !s.IsPreforkChild)
+		if err != nil {
+			return err
+		}
+	}
+
+	if listener == nil {
+		if s.Prefork {
+			listener, err = listenReusePort("0.0.0.0:" + port)
+		} else {
+			listener, err = net.Listen("tcp", "0.0.0.0:"+port)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to bind to port %s: %w", port, err)
+		}
 	}
 	defer listener.Close()
 
+	if s.ControlSocket != "" && !s.Prefork {
+		go s.serveControlSocket(listener, port)
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	s.setListener(listener)
+
+	// Start a fixed set of workers fed from a bounded queue when concurrency is limited
+	var queue *connQueue
+	if s.MaxConcurrentConnections > 0 {
+		queue = newConnQueue(s.MaxConcurrentConnections, s.QueueMode)
+		for i := 0; i < s.MaxConcurrentConnections; i++ {
+			go func() {
+				for conn := queue.pop(); conn != nil; conn = queue.pop() {
+					s.serveConnection(conn)
+				}
+			}()
+		}
+	}
+
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			// Shutdown was called or the listener was handed over to a restarted server,
+			// finish what's in flight
+			if s.draining.Load() {
+				// The workers serve what's queued, then exit
+				if queue != nil {
+					queue.close()
+				}
+				s.connections.Wait()
+				fmt.Println("Connections drained, exiting")
+				return nil
+			}
 			fmt.Println("Error accepting connection:", err)
 			continue
 		}
+		s.connRate.record()
+		s.connections.Add(1)
+
+		if queue == nil {
+			go s.serveConnection(conn)
+			continue
+		}
 
-		go s.handleConnection(conn)
+		// Close the dropped connection before reading anything from it
+		if dropped := queue.push(conn); dropped != nil {
+			fmt.Println("Connection queue full, dropping:", dropped.RemoteAddr())
+			dropped.Close()
+			s.connections.Done()
+		}
 	}
 }
 
 func main() {
-	directory := parseArgs()
+	args := parseArgs()
 
 	// Create server instance
-	server := NewServer(directory)
+	server := NewServer(args.Directory)
+	server.InheritFD = args.InheritFD
+	server.ControlSocket = args.ControlSocket
+	// The gzip stage of the challenge expects even /echo/abc to be compressed
+	server.CompressionThreshold = 0
+
+	// Pre-render the static routes instead of serving them
+	if args.Export != "" {
+		if err := server.ExportStatic(args.Export); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	// Start the server
 	err := server.Start("4221")
@@ -136,10 +672,57 @@ func httpVersionMiddleware(next Handler) Handler {
 	})
 }
 
-// methodValidationMiddleware validates that the HTTP method is GET or POST
+// recoveryMiddleware turns a panic in a handler or middleware into a 500 response, logging
+// the stack trace. Panics with http.ErrAbortHandler go on, to close the connection
+func recoveryMiddleware(next Handler) Handler {
+	return HandlerFunc(func(req *Request) (response *Response) {
+		defer func() {
+			if logPanic(req, recover()) {
+				response = &Response{
+					StatusLine: StatusInternalServerError,
+					Headers:    make(map[string]string),
+				}
+			}
+		}()
+		return next.Handle(req)
+	})
+}
+
+// logPanic logs the value a handler panicked with and its stack trace, reporting whether
+// there was a panic. Panics with http.ErrAbortHandler go on, to close the connection
+func logPanic(req *Request, err any) bool {
+	if err == nil {
+		return false
+	}
+	if err == http.ErrAbortHandler {
+		panic(err)
+	}
+	fmt.Printf("Panic handling %s %s: %v\n%s", req.Method, req.Path, err, debug.Stack())
+	return true
+}
+
+// handleConnRecovering runs a connection handler, which runs after the middleware chain
+// and outside its recovery, logging a panic instead of crashing the server
+func handleConnRecovering(h ConnHandler, req *Request, conn net.Conn) {
+	defer func() { logPanic(req, recover()) }()
+	h.HandleConn(req, conn)
+}
+
+// handle2Recovering runs a streaming handler, which runs after the middleware chain and
+// outside its recovery, reporting whether it panicked
+func handle2Recovering(h Handler2, req *Request, w ResponseWriter) (panicked bool) {
+	defer func() { panicked = logPanic(req, recover()) }()
+	h.Handle2(req, w)
+	return false
+}
+
+// supportedMethods are the HTTP methods the server accepts, in the order listed in Allow headers
+var supportedMethods = []string{"GET", "HEAD", "POST", "PUT", "DELETE", "PATCH", "OPTIONS"}
+
+// methodValidationMiddleware validates that the HTTP method is one of supportedMethods
 func methodValidationMiddleware(next Handler) Handler {
 	return HandlerFunc(func(req *Request) *Response {
-		if req.Method != "GET" && req.Method != "POST" {
+		if !slices.Contains(supportedMethods, req.Method) {
 			return &Response{
 				StatusLine: StatusMethodNotAllowed,
 				Headers:    make(map[string]string),
@@ -149,80 +732,223 @@ func methodValidationMiddleware(next Handler) Handler {
 	})
 }
 
+// excludes reports whether compression should be skipped for the given path
+func (o CompressionOptions) excludes(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, excluded := range o.ExcludeExtensions {
+		if ext != "" && ext == strings.ToLower(excluded) {
+			return true
+		}
+	}
+	for _, prefix := range o.ExcludePaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// sendLimitMiddleware truncates response bodies larger than MaxSendBytes. File downloads
+// are truncated by handleFileDownload itself, into a 206 Partial Content
+func (s *Server) sendLimitMiddleware(next Handler) Handler {
+	return HandlerFunc(func(req *Request) *Response {
+		response := next.Handle(req)
+		if s.MaxSendBytes <= 0 || int64(len(response.Body)) <= s.MaxSendBytes || response.StatusLine == StatusPartialContent {
+			return response
+		}
+		fmt.Printf("Warning: truncating %d byte response to %s %s at %d bytes\n", len(response.Body), req.Method, req.Path, s.MaxSendBytes)
+		response.Body = response.Body[:s.MaxSendBytes]
+		return response
+	})
+}
+
 // compressionMiddleware adds Content-Encoding: gzip header and compresses the response body if client supports it
-func compressionMiddleware(next Handler) Handler {
+func (s *Server) compressionMiddleware(next Handler) Handler {
 	return HandlerFunc(func(req *Request) *Response {
 		response := next.Handle(req)
 
-		// Check if client supports gzip compression
-		acceptEncoding, ok := req.Headers["accept-encoding"]
-		if ok && response.Body != "" {
-			// Split by comma and check each encoding
-			encodings := strings.Split(acceptEncoding, ",")
-			for _, encoding := range encodings {
-				// Trim whitespace and convert to lowercase
-				encoding = strings.TrimSpace(strings.ToLower(encoding))
-				if encoding == "gzip" {
-					if response.Headers == nil {
-						response.Headers = make(map[string]string)
-					}
+		// Skip streamed and already encoded bodies, partial ones whose Content-Range counts
+		// identity bytes, and excluded paths
+		if response.dispatch != nil || response.Headers["Content-Encoding"] != "" || response.StatusLine == StatusPartialContent || s.Compression.excludes(req.Path) {
+			return response
+		}
 
-					// Compress the response body using gzip
-					var compressedBody bytes.Buffer
-					gz := gzip.NewWriter(&compressedBody)
-					if _, err := gz.Write([]byte(response.Body)); err != nil {
-						fmt.Println("Error compressing response body:", err)
-						return response
-					}
-					if err := gz.Close(); err != nil {
-						fmt.Println("Error closing gzip writer:", err)
-						return response
-					}
+		// Small bodies fit in a single packet anyway, compressing them isn't worth it
+		if len(response.Body) < s.CompressionThreshold {
+			return response
+		}
+		if response.Headers == nil {
+			response.Headers = make(map[string]string)
+		}
+		// Caches must not hand a compressed body to clients that didn't ask for it
+		addVary(response, "Accept-Encoding")
+
+		// Use the encoding the client prefers, if any
+		encoding := negotiateEncoding(req, "gzip", "deflate")
+		if len(response.Body) > 0 && encoding != "" {
+			compressedBody, err := compressBody(encoding, response.Body)
+			if err != nil {
+				fmt.Println("Error compressing response body:", err)
+				return response
+			}
 
-					// Update the response with compressed body
-					response.Body = string(compressedBody.Bytes())
-					response.Headers["Content-Encoding"] = "gzip"
+			// Update the response with compressed body
+			response.Body = compressedBody
+			response.Headers["Content-Encoding"] = encoding
 
-					// Update Content-Length header
-					response.Headers["Content-Length"] = strconv.Itoa(len(response.Body))
-					break
-				}
-			}
+			// Update Content-Length header
+			response.Headers["Content-Length"] = strconv.Itoa(len(response.Body))
 		}
 
 		return response
 	})
 }
 
+// compressBody compresses the body with gzip or deflate, which HTTP defines as the zlib format
+func compressBody(encoding string, body []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+	var w io.WriteCloser
+	var err error
+	if encoding == "deflate" {
+		w, err = zlib.NewWriterLevel(&compressed, gzipLevel(len(body)))
+	} else {
+		w, err = gzip.NewWriterLevel(&compressed, gzipLevel(len(body)))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return compressed.Bytes(), nil
+}
+
+// gzipLevel picks a compression level worth the CPU for a body of the given size
+func gzipLevel(size int) int {
+	switch {
+	case size < 10<<10:
+		return gzip.BestSpeed
+	case size <= 100<<10:
+		return gzip.DefaultCompression
+	default:
+		return gzip.BestCompression
+	}
+}
+
+// acceptsEncoding reports whether the client accepts the encoding, listed by name or
+// through "*", with a non-zero q-value
+func acceptsEncoding(req *Request, name string) bool {
+	q, ok := acceptedEncodings(req)[name]
+	return ok && q > 0
+}
+
+// acceptedEncodings parses Accept-Encoding into the q-value of each encoding, giving
+// unlisted encodings the q-value of "*" when present
+func acceptedEncodings(req *Request) map[string]float64 {
+	accepted := make(map[string]float64)
+	for _, encoding := range strings.Split(req.Headers["accept-encoding"], ",") {
+		name, params, _ := strings.Cut(encoding, ";")
+		name = strings.TrimSpace(strings.ToLower(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		accepted[name] = q
+	}
+	if q, ok := accepted["*"]; ok {
+		for _, name := range []string{"gzip", "deflate", "identity"} {
+			if _, listed := accepted[name]; !listed {
+				accepted[name] = q
+			}
+		}
+	}
+	return accepted
+}
+
+// negotiateEncoding picks the offered encoding with the highest q-value, earlier ones
+// winning ties, or "" when the client accepts none of them or explicitly prefers identity
+func negotiateEncoding(req *Request, offered ...string) string {
+	accepted := acceptedEncodings(req)
+	best, bestQ := "", accepted["identity"]
+	for _, encoding := range offered {
+		q := accepted[encoding]
+		if q > 0 && (q > bestQ || best == "" && q == bestQ) {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
 // routingMiddleware routes requests to appropriate handlers
 func (s *Server) routingMiddleware() Middleware {
 	return func(next Handler) Handler {
 		return HandlerFunc(func(req *Request) *Response {
-			// Route to appropriate handler
-			switch {
-			case req.Method == "GET" && req.Path == "/":
-				// Root path, just return 200 OK
-				return &Response{
-					StatusLine: StatusOK,
-					Headers:    make(map[string]string),
+			// Answer OPTIONS for routes that don't handle it themselves
+			if req.Method == "OPTIONS" {
+				if route := s.Router.Match(req); route == nil || route.Method != "OPTIONS" {
+					if allowed := s.Router.AllowedMethods(req); len(allowed) > 0 {
+						return &Response{
+							StatusLine: StatusNoContent,
+							Headers:    map[string]string{"Allow": strings.Join(allowed, ", ")},
+						}
+					}
 				}
+			}
 
-			case req.Method == "GET" && req.Path == "/user-agent":
-				return s.handleUserAgent(req)
-
-			case req.Method == "GET" && strings.HasPrefix(req.Path, "/echo/"):
-				return s.handleEcho(req)
-
-			case strings.HasPrefix(req.Path, "/files/"):
-				return s.handleFiles(req)
-
-			default:
-				return next.Handle(req)
+			// Route to appropriate handler, streaming and connection handlers write to the
+			// connection themselves once the chain has let the request through
+			if route := s.Router.Match(req); route != nil {
+				if route.Handler != nil {
+					return route.Handler.Handle(req)
+				}
+				return &Response{StatusLine: StatusOK, Headers: make(map[string]string), dispatch: route}
 			}
+			return next.Handle(req)
 		})
 	}
 }
 
+// registerRoutes registers the built-in endpoints on the router
+func (s *Server) registerRoutes() {
+	s.Router.GET("/", func(req *Request) *Response {
+		// Root path, just return 200 OK
+		return &Response{
+			StatusLine: StatusOK,
+			Headers:    make(map[string]string),
+		}
+	})
+	s.Router.GET("/user-agent", s.handleUserAgent)
+	s.Router.GET("/echo/*text", s.handleEcho)
+	s.Router.Register("POST", "/form-echo", HandlerFunc(s.handleFormEcho))
+	s.Router.RegisterConn("GET", "/ws/echo", ConnHandlerFunc(s.handleWebSocketEcho))
+	s.Router.RegisterStreaming("GET", "/events/", Handler2Func(s.handleEvents))
+	s.Router.GET("/debug/uploads", s.handleDebugUploads)
+	s.Router.Register("POST", "/admin/cache/clear", HandlerFunc(s.handleCacheClear))
+	s.Router.GET("/metrics", s.handleMetrics)
+	s.Router.GET("/openapi.json", s.handleOpenAPI)
+	s.Router.GET("/openapi.yaml", s.handleOpenAPI)
+	s.Router.GET("/docs", s.handleDocs)
+	s.Router.GET("/__routes", s.handleRouteList)
+	s.Router.RegisterStreaming("GET", "/files/", Handler2Func(s.handleFilesRoot))
+	s.Router.Register("POST", "/files/", HandlerFunc(s.handleFilesRootPost))
+	s.Router.Register("PATCH", "/files/", HandlerFunc(s.handleUploadChunk))
+	s.Router.Register("", "/files/archive", HandlerFunc(s.handleArchive))
+	s.Router.Register("", "/files/presign", HandlerFunc(s.handlePresign))
+	s.Router.Register("", "/files/fetch", HandlerFunc(s.handleFetch))
+	s.Router.Register("", "/files/compare", HandlerFunc(s.handleCompare))
+	s.Router.Register("", "/files/*name", HandlerFunc(s.handleFiles))
+}
+
 // createMiddlewareChain creates the middleware chain for request handling
 func (s *Server) createMiddlewareChain() Handler {
 	// Create base handler that returns 404 Not Found
@@ -235,9 +961,16 @@ func (s *Server) createMiddlewareChain() Handler {
 
 	// Build middleware chain
 	middlewareChain := Chain(
+		s.accessLogMiddleware,
+		recoveryMiddleware,
+		s.corsMiddleware,
+		s.mockMiddleware,
 		httpVersionMiddleware,
+		s.upgradeInsecureMiddleware,
 		methodValidationMiddleware,
-		compressionMiddleware,
+		s.latencyMiddleware,
+		s.compressionMiddleware,
+		s.sendLimitMiddleware,
 		s.routingMiddleware(),
 	)
 
@@ -245,29 +978,97 @@ func (s *Server) createMiddlewareChain() Handler {
 	return middlewareChain(notFoundHandler)
 }
 
-// parseArgs parses command line arguments and returns the directory if specified
-func parseArgs() string {
-	var directory string
+// args holds the parsed command line arguments
+type args struct {
+	Directory     string
+	Export        string
+	InheritFD     int
+	ControlSocket string
+}
 
-	// Check for --directory flag
+// parseArgs parses command line arguments
+func parseArgs() args {
+	var parsed args
+
+	// Check for --directory, --export, --control-socket and --inherit-fd flags
 	for i := 1; i < len(os.Args); i++ {
-		if os.Args[i] == "--directory" && i+1 < len(os.Args) {
-			directory = os.Args[i+1]
+		if value, ok := strings.CutPrefix(os.Args[i], "--inherit-fd="); ok {
+			parsed.InheritFD, _ = strconv.Atoi(value)
+			continue
+		}
+		if i+1 >= len(os.Args) {
+			break
+		}
+		switch os.Args[i] {
+		case "--directory":
+			parsed.Directory = os.Args[i+1]
 			i++ // Skip the next argument as we've already processed it
+		case "--export":
+			parsed.Export = os.Args[i+1]
+			i++
+		case "--control-socket":
+			parsed.ControlSocket = os.Args[i+1]
+			i++
 		}
 	}
 
-	return directory
+	return parsed
 }
 
+// readerAllocations counts the connection readers the pool had none to recycle for
+var readerAllocations = metrics.counter("http_reader_allocations_total")
+
 // handleConnection handles a client connection
 func (s *Server) handleConnection(conn net.Conn) {
 	defer conn.Close()
+	idle, untrack := s.trackConnection(conn)
+	defer untrack()
+
+	// Borrow a reader for the connection, returning it once the connection is closed
+	reader, _ := s.readerPool.Get().(*bufio.Reader)
+	if reader == nil {
+		readerAllocations.Add(1)
+		reader = bufio.NewReader(conn)
+	} else {
+		reader.Reset(conn)
+	}
+	defer func() {
+		reader.Reset(nil)
+		s.readerPool.Put(reader)
+	}()
+
+	// Take the client address from the load balancer's PROXY protocol header
+	if s.AcceptProxyProtocol {
+		if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			fmt.Println("Error setting read deadline:", err)
+			return
+		}
+		addr, err := readProxyHeader(reader)
+		if err != nil {
+			fmt.Println("Error reading PROXY protocol header:", err)
+			return
+		}
+		if addr != nil {
+			conn = &proxyConn{Conn: conn, remoteAddr: addr}
+		}
+	}
 
-	fmt.Println("Accepted connection from:", conn.RemoteAddr())
+	if s.Debug {
+		fmt.Println("Accepted connection from:", conn.RemoteAddr())
+	}
 
-	// Create a reader once for the connection
-	reader := bufio.NewReader(conn)
+	// Turn the connection away while memory is under pressure
+	if s.shedding.Load() {
+		s.sendResponse(conn, &Response{
+			StatusLine: StatusServiceUnavailable,
+			Headers:    map[string]string{"Connection": "close"},
+		}, false)
+		lingeringClose(conn, reader)
+		return
+	}
+
+	// Number of requests the client sent without waiting for the responses
+	pipelined := 0
 
 	// Process requests in a loop to handle persistent connections
 	for {
@@ -277,25 +1078,175 @@ func (s *Server) handleConnection(conn net.Conn) {
 			fmt.Println("Error setting read deadline:", err)
 			return
 		}
+		if reader.Buffered() == 0 {
+			pipelined = 0
+
+			// Waiting for the next request, Shutdown may close the connection now
+			idle.Store(true)
+			if s.draining.Load() {
+				return
+			}
+		}
 
 		// Parse the request using the buffered reader
-		request, err := parseRequestWithReader(reader)
+		request, err := s.parseRequestWithReader(reader, conn.RemoteAddr().String())
+		idle.Store(false)
 		if err != nil {
-			if err != io.EOF {
+			// Shutdown wakes idle connections up with a timeout, that's no error
+			if err != io.EOF && !s.draining.Load() {
 				fmt.Println("Error parsing request:", err)
 			}
+			if errors.Is(err, errMalformedRequestLine) {
+				s.sendResponse(conn, &Response{
+					StatusLine: StatusBadRequest,
+					Headers:    map[string]string{"Connection": "close"},
+				}, false)
+			} else if errors.Is(err, errHeaderTooLarge) {
+				s.sendResponse(conn, &Response{
+					StatusLine: StatusRequestHeaderFieldsTooLarge,
+					Headers:    map[string]string{"Connection": "close"},
+				}, false)
+				lingeringClose(conn, reader)
+			}
+			return
+		}
+
+		// Let the client know whether to send the body it is holding back
+		if strings.EqualFold(request.Headers["expect"], "100-continue") {
+			if s.ContinueHandler != nil && !s.ContinueHandler(request.requestHeaders()) {
+				s.sendResponse(conn, &Response{
+					StatusLine: StatusExpectationFailed,
+					Headers:    map[string]string{"Connection": "close"},
+				}, false)
+				return
+			}
+			if _, err := conn.Write([]byte(withCustomReason(StatusContinue, s.CustomStatusReasons) + "\r\n\r\n")); err != nil {
+				fmt.Println("Error sending 100 Continue:", err)
+				return
+			}
+		}
+
+		if err := s.readRequestBody(reader, request); err != nil {
+			fmt.Println("Error parsing request:", err)
+			var statusLine string
+			if errors.Is(err, errMalformedChunk) {
+				statusLine = StatusBadRequest
+			} else if errors.Is(err, errBodyTooLarge) {
+				statusLine = StatusContentTooLarge
+			} else if errors.Is(err, errUnsupportedTransferEncoding) {
+				statusLine = StatusNotImplemented
+			}
+			if statusLine != "" {
+				s.sendResponse(conn, &Response{
+					StatusLine: statusLine,
+					Headers:    map[string]string{"Connection": "close"},
+				}, false)
+				lingeringClose(conn, reader)
+			}
 			return
 		}
 
-		fmt.Println("Request:", request.Method, request.Path, request.HTTPVersion)
+		started := time.Now()
+
+		if tlsConn, ok := conn.(*tls.Conn); ok {
+			state := tlsConn.ConnectionState()
+			request.TLS = &state
+		}
+
+		if protocol, ok := s.wantsTLSUpgrade(conn, request); ok {
+			s.upgradeToTLS(conn, reader, protocol)
+			return
+		}
 
-		// Check if the client wants to close the connection
-		connectionClose := false
+		// Check if the client wants to close the connection, or the server is draining
+		connectionClose := s.draining.Load()
 		if connHeader, ok := request.Headers["connection"]; ok && strings.ToLower(connHeader) == "close" {
 			connectionClose = true
 		}
 
-		response := s.Handler.Handle(request)
+		// Refuse requests pipelined beyond the limit, closing once the client's backlog is drained
+		pipelined++
+		if s.MaxPipelineDepth > 0 && pipelined > s.MaxPipelineDepth {
+			drained := reader.Buffered() == 0
+			response := &Response{
+				StatusLine: StatusServiceUnavailable,
+				Headers:    make(map[string]string),
+			}
+			if drained || connectionClose {
+				response.Headers["Connection"] = "close"
+			}
+			if err := s.sendResponse(conn, response, false); err != nil {
+				s.logSendError(request, err)
+				return
+			}
+			s.logAccess(request, statusCode(response.StatusLine), 0, time.Since(started))
+			if drained || connectionClose {
+				return
+			}
+			continue
+		}
+
+		// Reject bodies the inspector objects to before any handler sees them. The middleware
+		// chain logs the responses it produces
+		response := s.inspectBody(request)
+		if response == nil {
+			response = s.Handler.Handle(request)
+		} else {
+			s.logAccess(request, statusCode(response.StatusLine), int64(len(response.Body)), time.Since(started))
+		}
+		route := response.dispatch
+
+		// Connection handlers take the connection over for good
+		if route != nil && route.ConnHandler != nil {
+			// The deadline was for reading this request, the handler lives as long as it likes
+			if err := conn.SetDeadline(time.Time{}); err != nil {
+				fmt.Println("Error clearing deadline:", err)
+				return
+			}
+			handleConnRecovering(route.ConnHandler, request, &bufferedConn{Conn: conn, reader: reader})
+			return
+		}
+
+		// Streaming handlers write the response to the connection themselves
+		if route != nil && route.Handler2 != nil {
+			w := newConnResponseWriter(conn, s.CustomStatusReasons)
+			w.head = request.Method == "HEAD"
+			w.flushInterval = s.StreamFlushInterval
+			// Keep the headers the middleware added, such as CORS and Vary
+			for key, value := range response.Headers {
+				w.SetHeader(key, value)
+			}
+			for key, values := range response.MultiHeaders {
+				for _, value := range values {
+					w.AddHeader(key, value)
+				}
+			}
+			if connectionClose {
+				w.SetHeader("Connection", "close")
+			}
+			if handle2Recovering(route.Handler2, request, w) {
+				// Once the headers are out the client can only tell from the connection closing
+				if !w.wroteHeader {
+					w.status = 500
+					w.headers = map[string]string{"Content-Length": "0", "Connection": "close"}
+					w.multi = nil
+					if err := w.finish(); err != nil {
+						s.logSendError(request, err)
+					}
+				}
+				s.logAccess(request, 500, w.written, time.Since(started))
+				return
+			}
+			if err := w.finish(); err != nil {
+				s.logSendError(request, err)
+				return
+			}
+			s.logAccess(request, w.status, w.written, time.Since(started))
+			if connectionClose {
+				return
+			}
+			continue
+		}
 
 		// If the client requested to close the connection, add the header
 		if connectionClose {
@@ -305,14 +1256,12 @@ func (s *Server) handleConnection(conn net.Conn) {
 			response.Headers["Connection"] = "close"
 		}
 
-		err = sendResponse(conn, response)
+		err = s.sendResponse(conn, response, request.Method == "HEAD")
 		if err != nil {
-			fmt.Println("Error sending response:", err)
+			s.logSendError(request, err)
 			return
 		}
 
-		fmt.Println("Response:", response.StatusLine)
-
 		// If the client requested to close the connection, break the loop
 		if connectionClose {
 			return
@@ -320,18 +1269,27 @@ func (s *Server) handleConnection(conn net.Conn) {
 	}
 }
 
-// parseRequestWithReader parses an HTTP request from a bufio.Reader
-func parseRequestWithReader(reader *bufio.Reader) (*Request, error) {
+// errMalformedRequestLine is returned when the request line can't be split into method, path and version
+var errMalformedRequestLine = errors.New("invalid HTTP request format")
+
+// parseRequestWithReader parses the request line and headers of an HTTP request from a bufio.Reader,
+// leaving the body to readRequestBody
+func (s *Server) parseRequestWithReader(reader *bufio.Reader, remoteAddr string) (*Request, error) {
 	requestHeaders := make(map[string]string)
 	var requestTarget string
-	var requestBody []byte
+	var lastHeader string
+	headerBytes := 0
 
 	// Read until we get the empty line that marks end of headers
 	for {
-		line, err := reader.ReadString('\n')
+		line, err := readHeaderLine(reader, s.MaxHeaderBytes-headerBytes, s.MaxHeaderBytes > 0)
+		headerBytes += len(line)
 		if err == io.EOF {
 			return nil, fmt.Errorf("connection closed by client")
 		}
+		if err == errHeaderTooLarge {
+			return nil, err
+		}
 		if err != nil {
 			return nil, fmt.Errorf("error reading: %w", err)
 		}
@@ -341,58 +1299,265 @@ func parseRequestWithReader(reader *bufio.Reader) (*Request, error) {
 		line = line[:len(line)-1] // Remove trailing newline
 		if requestTarget == "" {
 			requestTarget = line
+		} else if lastHeader == "" && s.LenientParsing && (line[0] == ' ' || line[0] == '\t') {
+			// Continuation of a request line split by an upstream proxy
+			fmt.Println("Warning: joining request line split across multiple lines, check the upstream proxy")
+			requestTarget = strings.TrimRight(requestTarget, "\r \t") + strings.TrimSpace(line)
 		} else {
 			pair := strings.SplitN(line, ":", 2)
 			if len(pair) == 2 {
 				key := strings.ToLower(strings.TrimSpace(pair[0]))
 				value := strings.TrimSpace(pair[1])
 				requestHeaders[key] = value
+				lastHeader = key
 			} else {
 				fmt.Println("Invalid header format:", line)
 			}
 		}
 	}
 
-	// Read request body if Content-Length header is present
-	if contentLength, err := strconv.Atoi(requestHeaders["content-length"]); err == nil && contentLength > 0 {
-		requestBody = make([]byte, contentLength)
-		_, err = io.ReadFull(reader, requestBody)
-		if err != nil {
-			return nil, fmt.Errorf("error reading request body: %w", err)
-		}
-	}
-
 	parts := strings.Split(strings.TrimSpace(requestTarget), " ")
 	if len(parts) != 3 {
-		return nil, fmt.Errorf("invalid HTTP request format")
+		return nil, errMalformedRequestLine
+	}
+
+	// Split the query string off the path
+	rawPath, rawQuery, _ := strings.Cut(parts[1], "?")
+	path, err := url.PathUnescape(rawPath)
+	if err != nil {
+		return nil, errMalformedRequestLine
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		fmt.Println("Invalid query string:", rawQuery)
 	}
 
 	return &Request{
 		Method:      parts[0],
-		Path:        parts[1],
+		Path:        path,
+		RawPath:     rawPath,
+		RawQuery:    rawQuery,
+		Query:       query,
 		HTTPVersion: parts[2],
 		Headers:     requestHeaders,
-		Body:        requestBody,
+		Cookies:     parseCookies(requestHeaders["cookie"]),
+		RemoteAddr:  remoteAddr,
 	}, nil
 }
 
+// errHeaderTooLarge is returned when the request line and headers exceed Server.MaxHeaderBytes
+var errHeaderTooLarge = errors.New("request header fields too large")
+
+// readHeaderLine reads a line of the request head, giving up as soon as it grows past
+// remaining bytes when limited, so an oversized header is never buffered whole
+func readHeaderLine(reader *bufio.Reader, remaining int, limited bool) (string, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		line = append(line, chunk...)
+		if limited && len(line) > remaining {
+			return "", errHeaderTooLarge
+		}
+		if err != bufio.ErrBufferFull {
+			return string(line), err
+		}
+	}
+}
+
+// lingeringClose stops sending and discards what the client is still sending for a moment,
+// so closing the connection doesn't reset it before the client has read the response
+func lingeringClose(conn net.Conn, reader *bufio.Reader) {
+	if tcpConn, ok := conn.(interface{ CloseWrite() error }); ok {
+		tcpConn.CloseWrite()
+	}
+	conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	io.Copy(io.Discard, io.LimitReader(reader, 1<<20))
+}
+
+// readRequestBody reads the request body if Content-Length header is present, or the
+// chunks of a chunked body
+func (s *Server) readRequestBody(reader *bufio.Reader, req *Request) error {
+	if chunked, err := requestChunked(req.Headers); err != nil {
+		return err
+	} else if chunked {
+		return s.readChunkedBody(reader, req)
+	}
+
+	contentLength, err := strconv.Atoi(req.Headers["content-length"])
+	if err != nil || contentLength <= 0 {
+		return nil
+	}
+	if s.MaxBodySize > 0 && int64(contentLength) > s.MaxBodySize {
+		return fmt.Errorf("%w: %d bytes", errBodyTooLarge, contentLength)
+	}
+
+	req.Body = make([]byte, contentLength)
+	var source io.Reader = reader
+	if s.SimulateSlowReads && s.SlowReadBytesPerSecond > 0 {
+		source = newThrottledReader(reader, s.SlowReadBytesPerSecond)
+	}
+	bodyReader, done := s.trackUpload(uploadID(req.Headers, req.RemoteAddr), int64(contentLength), source)
+	_, err = readWithRetry(bodyReader, req.Body, s.ReadRetries)
+	done()
+	if err != nil {
+		return fmt.Errorf("error reading request body: %w", err)
+	}
+	return nil
+}
+
+// RequestURI returns the path together with the query string, as sent by the client
+// unless middleware rewrote the path since
+func (req *Request) RequestURI() string {
+	path := req.RawPath
+	if decoded, err := url.PathUnescape(path); err != nil || decoded != req.Path {
+		path = (&url.URL{Path: req.Path}).EscapedPath()
+	}
+	if req.RawQuery == "" {
+		return path
+	}
+	return path + "?" + req.RawQuery
+}
+
+// requestHeaders returns the part of the request known before its body is read
+func (req *Request) requestHeaders() *RequestHeaders {
+	return &RequestHeaders{
+		Method:      req.Method,
+		Path:        req.Path,
+		HTTPVersion: req.HTTPVersion,
+		Headers:     req.Headers,
+	}
+}
+
+// approveContinue is the default ContinueHandler, accepting bodies within MaxBodySize.
+// Chunked bodies are accepted, their size is checked as they are read
+func (s *Server) approveContinue(headers *RequestHeaders) bool {
+	if chunked, _ := requestChunked(headers.Headers); s.MaxBodySize <= 0 || chunked {
+		return true
+	}
+	contentLength, err := strconv.ParseInt(headers.Headers["content-length"], 10, 64)
+	return err == nil && contentLength <= s.MaxBodySize
+}
+
+// connectionResets counts responses the client reset the connection in the middle of
+var connectionResets = metrics.counter("http_connection_resets_total")
+
+// logSendError logs a failure to send a response. Clients resetting the connection
+// while a GET or HEAD response is on its way is routine, so it's only logged in debug mode
+func (s *Server) logSendError(req *Request, err error) {
+	if !isConnReset(err) {
+		fmt.Println("Error sending response:", err)
+		return
+	}
+	connectionResets.Add(1)
+	if req.Method != "GET" && req.Method != "HEAD" {
+		fmt.Println("Error sending response, connection reset by client:", err)
+	} else if s.Debug {
+		fmt.Println("Connection reset by client while sending response:", err)
+	}
+}
+
+// isConnReset reports whether a write failed because the client reset or closed the connection
+func isConnReset(err error) bool {
+	var netErr net.Error
+	if !errors.As(err, &netErr) {
+		return false
+	}
+	return errors.Is(netErr, syscall.ECONNRESET) || errors.Is(netErr, syscall.EPIPE)
+}
+
+// readRetries counts body reads retried after EINTR, for monitoring
+var readRetries = metrics.counter("http_read_retries_total")
+
+// readWithRetry fills buf from r like io.ReadFull, retrying reads interrupted by a signal up to maxRetries times
+func readWithRetry(r io.Reader, buf []byte, maxRetries int) (int, error) {
+	n := 0
+	retries := 0
+	for n < len(buf) {
+		read, err := r.Read(buf[n:])
+		n += read
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, syscall.EINTR) && retries < maxRetries {
+			retries++
+			readRetries.Add(1)
+			fmt.Println("Read interrupted, retrying:", retries)
+			continue
+		}
+		if err == io.EOF && n > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if n == len(buf) {
+			err = nil
+		}
+		return n, err
+	}
+	return n, nil
+}
+
+// requireAdmin returns an error response unless the request carries the admin token
+func (s *Server) requireAdmin(req *Request) *Response {
+	if s.AdminToken == "" {
+		return &Response{
+			StatusLine: StatusNotFound,
+			Headers:    make(map[string]string),
+		}
+	}
+	if !s.isAdmin(req) {
+		s.audit(req, "auth.failure", StatusUnauthorized)
+		return &Response{
+			StatusLine: StatusUnauthorized,
+			Headers:    map[string]string{"WWW-Authenticate": "Bearer"},
+		}
+	}
+	s.audit(req, "admin.access", StatusOK)
+	return nil
+}
+
+// isAdmin reports whether the request carries the admin token
+func (s *Server) isAdmin(req *Request) bool {
+	if s.AdminToken == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(req.Headers["authorization"], "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) == 1
+}
+
 // handleUserAgent handles the /user-agent endpoint
 func (s *Server) handleUserAgent(req *Request) *Response {
 	return &Response{
 		StatusLine: StatusOK,
 		Headers:    make(map[string]string),
-		Body:       req.Headers["user-agent"],
+		Body:       []byte(req.Headers["user-agent"]),
 	}
 }
 
 // handleEcho handles the /echo/ endpoint
 func (s *Server) handleEcho(req *Request) *Response {
-	content := strings.TrimPrefix(req.Path, "/echo/")
 	return &Response{
 		StatusLine: StatusOK,
 		Headers:    make(map[string]string),
-		Body:       content,
+		Body:       []byte(req.PathParams["text"]),
+	}
+}
+
+// handleFilesRootPost handles POST /files/, which extracts an archive with ?extract=1,
+// returns the metadata of several files with ?stat=1 and starts and completes
+// resumable uploads with ?start-upload and ?complete-upload=<Upload-Id>
+func (s *Server) handleFilesRootPost(req *Request) *Response {
+	switch {
+	case s.Directory == "":
+		return s.handleFiles(req)
+	case req.Query.Get("stat") == "1":
+		return s.handleBulkStat(req)
+	case req.Query.Get("extract") == "1":
+		return s.handleExtract(req)
+	case req.Query.Has("start-upload"):
+		return s.handleStartUpload(req)
+	case req.Query.Has("complete-upload"):
+		return s.handleCompleteUpload(req)
 	}
+	return s.handleFiles(req)
 }
 
 // handleFiles handles the /files/ endpoint for both GET and POST methods
@@ -407,7 +1572,12 @@ func (s *Server) handleFiles(req *Request) *Response {
 		return response
 	}
 
-	filePath := filepath.Clean(strings.TrimPrefix(req.Path, "/files/"))
+	// Routes under /files/ that aren't for a file fall back here without the name parameter
+	name, ok := req.PathParams["name"]
+	if !ok {
+		name = strings.TrimPrefix(req.Path, "/files/")
+	}
+	filePath := filepath.Clean(name)
 	if filePath == "" {
 		response.StatusLine = StatusBadRequest
 		fmt.Println("Invalid file path:", filePath)
@@ -420,19 +1590,80 @@ func (s *Server) handleFiles(req *Request) *Response {
 		fmt.Println("Invalid file path (directory traversal):", filePath)
 		return response
 	}
+	if isInternalPath(filePath) {
+		response.StatusLine = StatusNotFound
+		return response
+	}
 
 	fullPath := filepath.Join(s.Directory, filePath)
 
-	if req.Method == "POST" {
-		return s.handleFileUpload(req, fullPath)
-	} else if req.Method == "GET" {
+	// HEAD gets the same response as GET, sendResponse leaves out the body
+	method := req.Method
+	if method == "HEAD" {
+		method = "GET"
+	}
+
+	if method == "POST" {
+		response := s.handleFileUpload(req, fullPath)
+		s.audit(req, "file.create", response.StatusLine)
+		return response
+	} else if method == "GET" && req.Query.Get("info") == "1" {
+		return s.handleFileInfo(fullPath)
+	} else if method == "GET" && req.Query.Get("watch") == "1" {
+		return s.handleFileWatch(req, fullPath)
+	} else if method == "GET" && req.Query.Get("versions") == "1" {
+		return s.handleFileVersions(fullPath)
+	} else if method == "GET" && req.Query.Has("version") {
+		return s.handleFileVersion(req, fullPath)
+	} else if method == "GET" {
 		return s.handleFileDownload(req, fullPath)
+	} else if method == "PUT" {
+		response := s.handleFileReplace(req, fullPath)
+		s.audit(req, "file.update", response.StatusLine)
+		return response
+	} else if method == "DELETE" {
+		response := s.handleFileDelete(fullPath)
+		s.audit(req, "file.delete", response.StatusLine)
+		return response
+	} else if method == "PATCH" {
+		response := s.handleFilePatch(req, fullPath)
+		s.audit(req, "file.update", response.StatusLine)
+		return response
 	} else {
 		response.StatusLine = StatusMethodNotAllowed
 		return response
 	}
 }
 
+// internalNames are the entries of the served directory the server keeps for itself
+var internalNames = []string{versionsDirName, partialUploadsDirName, precompressedDirName, walFileName}
+
+// isInternalPath reports whether a path relative to the directory is one the server keeps
+// for itself, which clients can neither read nor write
+func isInternalPath(name string) bool {
+	first, _, _ := strings.Cut(filepath.ToSlash(filepath.Clean("/" + name))[1:], "/")
+	return slices.Contains(internalNames, first)
+}
+
+// uploadExtensionAllowed checks the file's extension against the allowed and blocked lists
+func (s *Server) uploadExtensionAllowed(name string) bool {
+	ext := filepath.Ext(name)
+	for _, blocked := range s.BlockedUploadExtensions {
+		if strings.EqualFold(ext, blocked) {
+			return false
+		}
+	}
+	if len(s.AllowedUploadExtensions) == 0 {
+		return true
+	}
+	for _, allowed := range s.AllowedUploadExtensions {
+		if strings.EqualFold(ext, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
 // handleFileUpload handles uploading a file (POST to /files/)
 func (s *Server) handleFileUpload(req *Request, fullPath string) *Response {
 	response := &Response{
@@ -446,6 +1677,21 @@ func (s *Server) handleFileUpload(req *Request, fullPath string) *Response {
 		return response
 	}
 
+	// A form upload stores the file in the form rather than the whole body
+	if content, err := formUploadContent(req); err == nil {
+		req.Body = content
+	} else if err != errNotMultipart {
+		response.StatusLine = StatusBadRequest
+		fmt.Println("Invalid multipart upload:", err)
+		return response
+	}
+
+	if !s.uploadExtensionAllowed(fullPath) {
+		response.StatusLine = StatusUnsupportedMediaType
+		fmt.Println("File extension not allowed:", fullPath)
+		return response
+	}
+
 	// Ensure the directory exists
 	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
 		response.StatusLine = StatusInternalServerError
@@ -464,12 +1710,80 @@ func (s *Server) handleFileUpload(req *Request, fullPath string) *Response {
 		return response
 	}
 
-	// Create a new file with the content from the request body
-	if err := os.WriteFile(fullPath, req.Body, 0644); err != nil {
+	// Link to an earlier upload with the same content instead of storing it again
+	var hash string
+	if s.DeduplicateUploads {
+		hash = uploadHash(req.Body)
+		if original := s.linkDuplicateUpload(hash, fullPath); original != "" {
+			s.invalidateDirs(fullPath)
+			response.StatusLine = StatusCreated
+			response.Headers["X-Deduplicated"] = "true"
+			response.Headers["X-Original-File"] = original
+			return response
+		}
+	}
+
+	// Encrypt the content at rest
+	content := req.Body
+	if len(s.EncryptionKey) > 0 {
+		encrypted, err := encryptFile(s.EncryptionKey, req.Body)
+		if err != nil {
+			response.StatusLine = StatusInternalServerError
+			fmt.Println("Error encrypting file:", err)
+			return response
+		}
+		content = encrypted
+	}
+
+	// Make sure the new file fits in the quota
+	if s.DirectoryQuotaBytes > 0 {
+		ok, err := s.usage.reserve(s.Directory, int64(len(content)), s.DirectoryQuotaBytes)
+		if err != nil {
+			response.StatusLine = StatusInternalServerError
+			fmt.Println("Error computing directory usage:", err)
+			return response
+		}
+		if !ok {
+			response.StatusLine = StatusInsufficientStorage
+			fmt.Println("Directory quota exceeded, rejecting:", fullPath)
+			return response
+		}
+	}
+
+	// Create a new file with the content from the request body, logging the write first so
+	// a partial file left by a crash is deleted on the next start
+	walID, err := s.wal.begin(fullPath, len(content))
+	if err != nil {
+		if s.DirectoryQuotaBytes > 0 {
+			s.usage.release(int64(len(content)))
+		}
+		response.StatusLine = StatusInternalServerError
+		fmt.Println("Error writing to the write-ahead log:", err)
+		return response
+	}
+	if err := os.WriteFile(fullPath, content, 0644); err != nil {
+		if s.DirectoryQuotaBytes > 0 {
+			s.usage.release(int64(len(content)))
+		}
+		if err := os.Remove(fullPath); err == nil || os.IsNotExist(err) {
+			s.wal.abort(walID)
+		}
 		response.StatusLine = StatusInternalServerError
 		fmt.Println("Error creating file:", err)
 		return response
 	}
+	if err := s.wal.commit(walID); err != nil {
+		fmt.Println("Error writing to the write-ahead log:", err)
+	}
+	if s.DeduplicateUploads {
+		s.uploadHashes.Store(hash, fullPath)
+	}
+	s.invalidateDirs(fullPath)
+
+	// A sidecar would hold the ciphertext, compressed for nothing
+	if s.PrewarmCompression && len(s.EncryptionKey) == 0 {
+		go s.prewarmCompression(fullPath)
+	}
 
 	response.StatusLine = StatusCreated
 	return response
@@ -482,14 +1796,42 @@ func (s *Server) handleFileDownload(req *Request, fullPath string) *Response {
 		Headers:    make(map[string]string),
 	}
 
-	fileInfo, err := os.Stat(fullPath)
+	fileInfo, err := s.stats.Stat(fullPath)
 	if err != nil || fileInfo.IsDir() {
 		response.StatusLine = StatusNotFound
 		return response
 	}
+	if notModified(req, fileInfo) {
+		response.StatusLine = StatusNotModified
+		response.Headers["ETag"] = fileETag(fileInfo)
+		response.Headers["Last-Modified"] = fileInfo.ModTime().UTC().Format(http.TimeFormat)
+		response.Headers["Accept-Ranges"] = "bytes"
+		return response
+	}
+	if req.Query.Has("encoding") {
+		return s.handleFileBase64(req, fullPath)
+	}
+	if req.Headers["range"] != "" {
+		if response := s.handleRangeDownload(req, fullPath, fileInfo); response != nil {
+			return response
+		}
+	}
+
+	// Serve the gzip sidecar directly when the client accepts it
+	contentPath := fullPath
+	if (s.UsePrecompressed || s.PrewarmCompression) && len(s.EncryptionKey) == 0 {
+		if sidecar, ok := s.precompressedPath(fullPath, fileInfo); ok {
+			// Caches must not hand the gzip body to clients that don't accept it
+			addVary(response, "Accept-Encoding")
+			if acceptsEncoding(req, "gzip") {
+				contentPath = sidecar
+				response.Headers["Content-Encoding"] = "gzip"
+			}
+		}
+	}
 
 	// Read the file content
-	file, err := os.Open(fullPath)
+	file, err := os.Open(contentPath)
 	if err != nil {
 		response.StatusLine = StatusInternalServerError
 		fmt.Println("Error opening file:", err)
@@ -503,34 +1845,89 @@ func (s *Server) handleFileDownload(req *Request, fullPath string) *Response {
 		fmt.Println("Error reading file:", err)
 		return response
 	}
+	if len(s.EncryptionKey) > 0 {
+		fileContent, err = decryptFile(s.EncryptionKey, fileContent)
+		if err != nil {
+			response.StatusLine = StatusInternalServerError
+			fmt.Println("Error decrypting file:", err)
+			return response
+		}
+	}
 
-	response.Body = string(fileContent)
-	response.Headers["Content-Type"] = "application/octet-stream"
-	response.Headers["Content-Disposition"] = fmt.Sprintf("attachment; filename=%s", filepath.Base(fullPath))
+	// Send a delta from the version the client already has
+	if s.DeltaEncoding && contentPath == fullPath {
+		etag := fileETag(fileInfo)
+		if delta := s.deltaResponse(req, fullPath, etag, fileContent); delta != nil {
+			return delta
+		}
+		s.deltaBases.put(fullPath, etag, fileContent)
+	}
+
+	// Send only the beginning of files larger than MaxSendBytes, telling the client how much is missing
+	if s.MaxSendBytes > 0 && int64(len(fileContent)) > s.MaxSendBytes {
+		response.StatusLine = StatusPartialContent
+		response.Headers["Content-Range"] = fmt.Sprintf("bytes 0-%d/%d", s.MaxSendBytes-1, len(fileContent))
+		fileContent = fileContent[:s.MaxSendBytes]
+	}
+
+	// Sniffing the gzip sidecar would only find gzip
+	contentType := fileMIMEType(fullPath)
+	if contentPath == fullPath {
+		contentType = fileContentType(fullPath, fileContent)
+	}
+
+	response.Body = fileContent
+	response.Headers["Content-Type"] = contentType
+	if !displayableInline(contentType) {
+		response.Headers["Content-Disposition"] = fmt.Sprintf("attachment; filename=%s", filepath.Base(fullPath))
+	}
+	response.Headers["Last-Modified"] = fileInfo.ModTime().UTC().Format(http.TimeFormat)
+	response.Headers["ETag"] = fileETag(fileInfo)
+	response.Headers["Accept-Ranges"] = "bytes"
 
 	return response
 }
 
-// sendResponse sends an HTTP response to the client
-func sendResponse(conn net.Conn, response *Response) error {
+// sendResponse sends an HTTP response to the client, leaving out the body but not its
+// Content-Length for responses to HEAD requests
+func (s *Server) sendResponse(conn net.Conn, response *Response, head bool) error {
 	// Add Content-Length and Content-Type headers if body is not empty
-	if response.Body != "" {
+	if len(response.Body) > 0 {
 		if response.Headers["Content-Type"] == "" {
 			response.Headers["Content-Type"] = "text/plain"
 		}
 		response.Headers["Content-Length"] = strconv.Itoa(len(response.Body))
+	} else if code := statusCode(response.StatusLine); code >= 200 && code != 204 && code != 304 && response.Headers["Content-Length"] == "" {
+		// Without it the client can't tell where the next response on the connection starts
+		if response.Headers == nil {
+			response.Headers = make(map[string]string)
+		}
+		response.Headers["Content-Length"] = "0"
+	}
+	if response.Chunked {
+		delete(response.Headers, "Content-Length")
+		response.Headers["Transfer-Encoding"] = "chunked"
 	}
 
 	// Build response
-	lines := make([]string, 0, 3+len(response.Headers))
-	lines = append(lines, response.StatusLine)
+	lines := make([]string, 0, 3+len(response.Headers)+len(response.MultiHeaders))
+	lines = append(lines, withCustomReason(response.StatusLine, s.CustomStatusReasons))
 	for k, v := range response.Headers {
 		lines = append(lines, fmt.Sprintf("%s: %s", k, v))
 	}
-	lines = append(lines, "")
-	lines = append(lines, response.Body)
+	for k, values := range response.MultiHeaders {
+		for _, v := range values {
+			lines = append(lines, fmt.Sprintf("%s: %s", k, v))
+		}
+	}
+	lines = append(lines, "", "")
 
-	responseStr := strings.Join(lines, "\r\n")
-	_, err := conn.Write([]byte(responseStr))
+	responseBytes := []byte(strings.Join(lines, "\r\n"))
+	if response.Chunked && !head {
+		responseBytes = writeChunked(responseBytes, response.Body)
+	} else if !head {
+		responseBytes = append(responseBytes, response.Body...)
+	}
+	_, err := conn.Write(responseBytes)
 	return err
 }
@@ -0,0 +1,297 @@
+package main
+
+// The benchmarks below compare the server's raw throughput with net/http's. Each one runs
+// twice, as "server" against this server and as "net_http" against a net/http server
+// doing the same work, both driven by raw HTTP/1.1 over net.Dial so the client's overhead
+// is the same. After the two sub-benchmarks each one prints how the server's req/s compare
+// to net/http's, run them with
+//
+//	go test -run '^$' -bench . -benchmem ./app
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// benchFileSize is the size of the files downloaded and uploaded by the benchmarks
+const benchFileSize = 1 << 20
+
+// benchTarget starts a server for the benchmarks serving dir, returning its address
+type benchTarget func(b *testing.B, dir string) string
+
+// benchTargets are the servers every benchmark compares
+var benchTargets = []struct {
+	name  string
+	start benchTarget
+}{
+	{"server", startBenchServer},
+	{"net_http", startNetHTTPServer},
+}
+
+// startBenchServer starts this server on a random port, serving every connection at once
+func startBenchServer(b *testing.B, dir string) string {
+	s := NewServer(dir)
+	s.AccessLog = io.Discard
+	s.MaxConcurrentConnections = 0
+	return startTestServer(b, s)
+}
+
+// startNetHTTPServer starts a net/http server with the same echo and file endpoints
+func startNetHTTPServer(b *testing.B, dir string) string {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /echo/{text}", func(w http.ResponseWriter, r *http.Request) {
+		body := []byte(r.PathValue("text"))
+		w.Header().Set("Content-Type", "text/plain")
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.Write(body)
+			return
+		}
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		gz.Write(body)
+		gz.Close()
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Content-Length", strconv.Itoa(compressed.Len()))
+		w.Write(compressed.Bytes())
+	})
+	mux.HandleFunc("GET /files/{name}", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, filepath.Join(dir, r.PathValue("name")))
+	})
+	mux.HandleFunc("POST /files/{name}", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err == nil {
+			err = os.WriteFile(filepath.Join(dir, r.PathValue("name")), body, 0644)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	b.Cleanup(func() { server.Close() })
+	return listener.Addr().String()
+}
+
+// benchConn is a keep-alive client connection sending raw requests
+type benchConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// dialBench opens a connection to the benchmarked server
+func dialBench(b *testing.B, addr string) *benchConn {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { conn.Close() })
+	return &benchConn{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// do sends a raw request and reads the response, returning its status code and body size
+func (c *benchConn) do(request []byte) (int, int64, error) {
+	if _, err := c.conn.Write(request); err != nil {
+		return 0, 0, err
+	}
+
+	status, err := c.reader.ReadString('\n')
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(status) < 12 {
+		return 0, 0, fmt.Errorf("malformed status line %q", status)
+	}
+	code, err := strconv.Atoi(status[9:12])
+	if err != nil {
+		return 0, 0, fmt.Errorf("malformed status line %q", status)
+	}
+
+	contentLength := int64(-1)
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return 0, 0, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, _ := strings.Cut(line, ":")
+		if strings.EqualFold(name, "Content-Length") {
+			contentLength, err = strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if err != nil {
+				return 0, 0, fmt.Errorf("malformed Content-Length %q", value)
+			}
+		}
+	}
+	if contentLength < 0 {
+		return 0, 0, errors.New("response has no Content-Length")
+	}
+	if _, err := io.CopyN(io.Discard, c.reader, contentLength); err != nil {
+		return 0, 0, err
+	}
+	return code, contentLength, nil
+}
+
+// mustDo sends a request, failing the benchmark unless it gets the status code
+func (c *benchConn) mustDo(b *testing.B, request []byte, want int) int64 {
+	code, size, err := c.do(request)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if code != want {
+		b.Fatalf("got status %d, want %d", code, want)
+	}
+	return size
+}
+
+// runBenchTargets runs the benchmark against every target, with a directory set up by setup,
+// reporting their requests per second and noting how they compare
+func runBenchTargets(b *testing.B, setup func(b *testing.B, dir string), bench func(b *testing.B, addr string)) {
+	rates := make([]float64, len(benchTargets))
+	for i, target := range benchTargets {
+		b.Run(target.name, func(b *testing.B) {
+			dir := b.TempDir()
+			if setup != nil {
+				setup(b, dir)
+			}
+			bench(b, target.start(b, dir))
+			rates[i] = float64(b.N) / b.Elapsed().Seconds()
+			b.ReportMetric(rates[i], "req/s")
+		})
+	}
+	if rates[0] > 0 && rates[1] > 0 {
+		fmt.Printf("%s: the server does %.2fx net/http's throughput (%.0f vs %.0f req/s)\n", b.Name(), rates[0]/rates[1], rates[0], rates[1])
+	}
+}
+
+// writeBenchFile creates a file for the benchmarks to download
+func writeBenchFile(b *testing.B, dir, name string, content []byte) {
+	if err := os.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkEchoHTTP(b *testing.B) {
+	request := []byte("GET /echo/hello HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	runBenchTargets(b, nil, func(b *testing.B, addr string) {
+		conn := dialBench(b, addr)
+		b.ReportAllocs()
+		for b.Loop() {
+			conn.mustDo(b, request, 200)
+		}
+	})
+}
+
+func BenchmarkFileDownload1MB(b *testing.B) {
+	request := []byte("GET /files/download.bin HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	setup := func(b *testing.B, dir string) {
+		writeBenchFile(b, dir, "download.bin", bytes.Repeat([]byte{0xa5}, benchFileSize))
+	}
+	runBenchTargets(b, setup, func(b *testing.B, addr string) {
+		conn := dialBench(b, addr)
+		b.SetBytes(benchFileSize)
+		b.ReportAllocs()
+		for b.Loop() {
+			if size := conn.mustDo(b, request, 200); size != benchFileSize {
+				b.Fatalf("downloaded %d bytes, want %d", size, benchFileSize)
+			}
+		}
+	})
+}
+
+func BenchmarkFileUpload1MB(b *testing.B) {
+	body := bytes.Repeat([]byte{0xa5}, benchFileSize)
+	runBenchTargets(b, nil, func(b *testing.B, addr string) {
+		conn := dialBench(b, addr)
+		b.SetBytes(benchFileSize)
+		b.ReportAllocs()
+		i := 0
+		for b.Loop() {
+			// Every upload creates a new file, uploading over an existing one is a conflict
+			i++
+			header := fmt.Sprintf("POST /files/upload-%d.bin HTTP/1.1\r\nHost: localhost\r\nContent-Type: application/octet-stream\r\nContent-Length: %d\r\n\r\n", i, len(body))
+			conn.mustDo(b, append([]byte(header), body...), 201)
+		}
+	})
+}
+
+func BenchmarkConcurrent1000(b *testing.B) {
+	const clients = 1000
+	request := []byte("GET /echo/hello HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	runBenchTargets(b, nil, func(b *testing.B, addr string) {
+		conns := make([]*benchConn, clients)
+		for i := range conns {
+			conns[i] = dialBench(b, addr)
+		}
+
+		// The clients share the b.N requests, each sending its next one once answered
+		b.ReportAllocs()
+		b.ResetTimer()
+		var remaining atomic.Int64
+		remaining.Store(int64(b.N))
+		var wg sync.WaitGroup
+		errs := make(chan error, clients)
+		for _, conn := range conns {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for remaining.Add(-1) >= 0 {
+					code, _, err := conn.do(request)
+					if err == nil && code != 200 {
+						err = fmt.Errorf("got status %d, want 200", code)
+					}
+					if err != nil {
+						errs <- err
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		b.StopTimer()
+		close(errs)
+		if err := <-errs; err != nil {
+			b.Fatal(err)
+		}
+	})
+}
+
+func BenchmarkGzipResponse(b *testing.B) {
+	text := strings.Repeat("abcdefgh", 256)
+	request := []byte("GET /echo/" + text + " HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip\r\n\r\n")
+	runBenchTargets(b, nil, func(b *testing.B, addr string) {
+		conn := dialBench(b, addr)
+		if size := conn.mustDo(b, request, 200); size >= int64(len(text)) {
+			b.Fatalf("got %d bytes for a %d byte body, it wasn't compressed", size, len(text))
+		}
+
+		// MB/s counts the uncompressed body
+		b.SetBytes(int64(len(text)))
+		b.ReportAllocs()
+		for b.Loop() {
+			conn.mustDo(b, request, 200)
+		}
+	})
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func FuzzParseRequest(f *testing.F) {
+	seeds := []string{
+		"GET / HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		"GET /echo/abc?x=1&y=%20 HTTP/1.1\r\nHost: localhost\r\nUser-Agent: fuzz\r\nAccept-Encoding: gzip, deflate\r\n\r\n",
+		"POST /files/a.txt HTTP/1.1\r\nHost: localhost\r\nContent-Type: application/octet-stream\r\nContent-Length: 5\r\n\r\nhello",
+		"POST /files/a.txt HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n5;ext=1\r\nhello\r\n0\r\nTrailer: x\r\n\r\n",
+		"PUT /files/b%2Fc.txt HTTP/1.1\r\nHost: localhost\r\nCookie: a=1; b=2\r\nExpect: 100-continue\r\nContent-Length: 3\r\n\r\nabc",
+		"HEAD /files/ HTTP/1.1\nHost: localhost\n\n",
+		"GET /split\r\n HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: localhost\r\n\r\nGET /second HTTP/1.1\r\nHost: localhost\r\n\r\n",
+	}
+	for _, seed := range seeds {
+		f.Add(seed, false)
+		f.Add(seed, true)
+	}
+
+	s := NewServer(f.TempDir())
+	s.MaxBodySize = 1 << 16
+	s.MaxHeaderBytes = 1 << 12
+
+	f.Fuzz(func(t *testing.T, raw string, lenient bool) {
+		s.LenientParsing = lenient
+		reader := bufio.NewReader(strings.NewReader(raw))
+		req, err := s.parseRequestWithReader(reader, "127.0.0.1:1234")
+		if err != nil {
+			return
+		}
+		if req.Headers == nil || req.Query == nil {
+			t.Fatalf("parsed request %+v without headers or query", req)
+		}
+		if err := s.readRequestBody(reader, req); err != nil {
+			return
+		}
+		if int64(len(req.Body)) > s.MaxBodySize {
+			t.Fatalf("read a %d byte body, the limit is %d", len(req.Body), s.MaxBodySize)
+		}
+		req.RequestURI()
+	})
+}
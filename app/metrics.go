@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// metricsRegistry holds the counters exposed at /metrics
+type metricsRegistry struct {
+	mu       sync.Mutex
+	counters map[string]*atomic.Int64
+}
+
+// metrics is the registry shared by the whole process
+var metrics = &metricsRegistry{counters: make(map[string]*atomic.Int64)}
+
+// counter returns the counter for a series, creating it on first use; the series
+// is a Prometheus metric name optionally followed by labels, e.g. `name{label="value"}`
+func (m *metricsRegistry) counter(series string) *atomic.Int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.counters[series]
+	if !ok {
+		c = &atomic.Int64{}
+		m.counters[series] = c
+	}
+	return c
+}
+
+// render formats all counters in the Prometheus text exposition format
+func (m *metricsRegistry) render() string {
+	m.mu.Lock()
+	series := make([]string, 0, len(m.counters))
+	for s := range m.counters {
+		series = append(series, s)
+	}
+	m.mu.Unlock()
+	sort.Strings(series)
+
+	var b strings.Builder
+	for _, s := range series {
+		fmt.Fprintf(&b, "%s %d\n", s, m.counter(s).Load())
+	}
+	return b.String()
+}
+
+// handleMetrics handles the /metrics endpoint
+func (s *Server) handleMetrics(req *Request) *Response {
+	return &Response{
+		StatusLine: StatusOK,
+		Headers:    map[string]string{"Content-Type": "text/plain; version=0.0.4"},
+		Body:       []byte(metrics.render()),
+	}
+}
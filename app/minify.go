@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"mime"
+	"strings"
+
+	"github.com/tdewolff/minify/v2"
+	"github.com/tdewolff/minify/v2/css"
+	"github.com/tdewolff/minify/v2/html"
+	"github.com/tdewolff/minify/v2/js"
+	"github.com/tdewolff/minify/v2/svg"
+	"github.com/tdewolff/minify/v2/xml"
+)
+
+// MinifyMiddleware minifies response bodies whose Content-Type is one of types. It must sit
+// inside compressionMiddleware in the chain, compressed bodies are left untouched.
+func MinifyMiddleware(types []string) Middleware {
+	minifier := minify.New()
+	minifier.AddFunc("text/html", html.Minify)
+	minifier.AddFunc("text/css", css.Minify)
+	minifier.AddFunc("application/javascript", js.Minify)
+	minifier.AddFunc("text/javascript", js.Minify)
+	minifier.AddFunc("image/svg+xml", svg.Minify)
+	minifier.AddFunc("application/xml", xml.Minify)
+	minifier.AddFunc("text/xml", xml.Minify)
+
+	enabled := make(map[string]bool, len(types))
+	for _, t := range types {
+		enabled[strings.ToLower(t)] = true
+	}
+
+	return func(next Handler) Handler {
+		return HandlerFunc(func(req *Request) *Response {
+			response := next.Handle(req)
+			if len(response.Body) == 0 || response.Headers["Content-Encoding"] != "" {
+				return response
+			}
+
+			mediaType, _, err := mime.ParseMediaType(response.Headers["Content-Type"])
+			if err != nil || !enabled[mediaType] {
+				return response
+			}
+
+			var minified []byte
+			if mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") {
+				var compacted bytes.Buffer
+				err = json.Compact(&compacted, response.Body)
+				minified = compacted.Bytes()
+			} else {
+				minified, err = minifier.Bytes(mediaType, response.Body)
+			}
+			if err != nil {
+				slog.Debug("minification failed", "path", req.Path, "type", mediaType, "error", err)
+				return response
+			}
+
+			slog.Debug("minified response", "path", req.Path, "type", mediaType,
+				"before", len(response.Body), "after", len(minified))
+			response.Body = minified
+			return response
+		})
+	}
+}
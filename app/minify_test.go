@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// minifyTestHandler serves the body with the content type
+func minifyTestHandler(contentType, body string, headers ...string) Handler {
+	return HandlerFunc(func(req *Request) *Response {
+		response := &Response{
+			StatusLine: StatusOK,
+			Headers:    map[string]string{"Content-Type": contentType},
+			Body:       []byte(body),
+		}
+		for i := 0; i+1 < len(headers); i += 2 {
+			response.Headers[headers[i]] = headers[i+1]
+		}
+		return response
+	})
+}
+
+func TestMinifyMiddlewareHTML(t *testing.T) {
+	var page strings.Builder
+	page.WriteString("<!DOCTYPE html>\n<html>\n  <head>\n    <title>Test</title>\n  </head>\n  <body>\n")
+	for page.Len() < 10<<10 {
+		page.WriteString("    <div class=\"row\">\n      <p>   Some   text   </p>\n    </div>\n")
+	}
+	page.WriteString("  </body>\n</html>\n")
+
+	handler := MinifyMiddleware([]string{"text/html"})(minifyTestHandler("text/html; charset=utf-8", page.String()))
+	response := handler.Handle(&Request{Path: "/"})
+	if len(response.Body) >= page.Len() {
+		t.Fatalf("minified body is %d bytes, the page %d", len(response.Body), page.Len())
+	}
+	if !strings.Contains(string(response.Body), "<p>Some text</div>") {
+		t.Fatalf("minified body lost the content: %.200s", response.Body)
+	}
+}
+
+func TestMinifyMiddlewareJSON(t *testing.T) {
+	handler := MinifyMiddleware([]string{"application/json"})(minifyTestHandler("application/json", "{\n  \"a\": 1,\n  \"b\": [1, 2]\n}\n"))
+	response := handler.Handle(&Request{Path: "/"})
+	if got := string(response.Body); got != `{"a":1,"b":[1,2]}` {
+		t.Fatalf("got %s", got)
+	}
+}
+
+func TestMinifyMiddlewareSkips(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler Handler
+	}{
+		{"type not enabled", minifyTestHandler("text/css", "a {  color: red;  }")},
+		{"encoded body", minifyTestHandler("text/html", "<p>   x   </p>", "Content-Encoding", "gzip")},
+		{"invalid JSON", minifyTestHandler("application/json", "{ not json }")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := string(tt.handler.Handle(&Request{}).Body)
+			response := MinifyMiddleware([]string{"text/html", "application/json"})(tt.handler).Handle(&Request{Path: "/"})
+			if string(response.Body) != want {
+				t.Fatalf("body changed to %q", response.Body)
+			}
+		})
+	}
+}
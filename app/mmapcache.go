@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The mapped region starts with a header: the magic bytes, a generation bumped on every
+// eviction and the offset where the next entry goes. Entries follow back to back, each a
+// fixed-size entry header followed by the key, the response metadata as JSON and the body
+const (
+	mmapCacheMagic      = "HTTPCCH1"
+	mmapCacheHeaderSize = 24
+	mmapEntryHeaderSize = 20 // key, metadata and body lengths, then the expiry in Unix nanoseconds
+)
+
+// mmapCacheTTL is how long a response is cached unless its Cache-Control sets a max-age
+const mmapCacheTTL = time.Minute
+
+// mmapCachedMeta is everything about a cached response but its body
+type mmapCachedMeta struct {
+	StatusLine   string              `json:"status_line"`
+	Headers      map[string]string   `json:"headers"`
+	MultiHeaders map[string][]string `json:"multi_headers,omitempty"`
+}
+
+// mmapCache is a response cache in a memory-mapped file, which outlives the process and
+// is shared by every process mapping the same file. Entries are appended until the region
+// is full, then all of them are evicted at once
+type mmapCache struct {
+	mu   sync.Mutex // the file lock doesn't exclude goroutines sharing the file
+	file *os.File
+	data []byte
+
+	// index maps keys to the offset of their newest entry, up to offset indexed of the
+	// region as it was in generation
+	generation uint64
+	indexed    uint64
+	index      map[string]uint64
+}
+
+// MmapCacheMiddleware caches successful GET responses in a file memory-mapped at path,
+// at most maxBytes large, reloading the responses cached there by earlier runs. Prefork
+// children share the cache. It panics if the file can't be mapped
+func MmapCacheMiddleware(path string, maxBytes int64) Middleware {
+	cache, err := openMmapCache(path, maxBytes)
+	if err != nil {
+		panic(fmt.Sprintf("mmap cache %s: %v", path, err))
+	}
+
+	return func(next Handler) Handler {
+		return HandlerFunc(func(req *Request) *Response {
+			if req.Method != "GET" {
+				return next.Handle(req)
+			}
+
+			// The body depends on the encodings the client accepts
+			key := defaultCacheKey(req) + "\n" + req.Headers["accept-encoding"]
+			if response, ok := cache.get(key); ok {
+				return response
+			}
+			response := next.Handle(req)
+			if ttl, ok := mmapCacheableFor(response); ok {
+				if err := cache.put(key, response, time.Now().Add(ttl)); err != nil {
+					fmt.Println("Error caching response:", err)
+				}
+			}
+			return response
+		})
+	}
+}
+
+// mmapCacheableFor returns how long the response may be cached, shared between clients
+func mmapCacheableFor(response *Response) (time.Duration, bool) {
+	if response.dispatch != nil || response.StatusLine != StatusOK || response.Headers["Set-Cookie"] != "" || len(response.MultiHeaders["Set-Cookie"]) > 0 {
+		return 0, false
+	}
+	ttl := mmapCacheTTL
+	for _, directive := range strings.Split(response.Headers["Cache-Control"], ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch directive {
+		case "no-store", "no-cache", "private":
+			return 0, false
+		}
+		if value, ok := strings.CutPrefix(directive, "max-age="); ok {
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds <= 0 {
+				return 0, false
+			}
+			ttl = time.Duration(seconds) * time.Second
+		}
+	}
+	return ttl, true
+}
+
+// openMmapCache maps the cache file, initializing it unless it already holds a valid region
+func openMmapCache(path string, maxBytes int64) (*mmapCache, error) {
+	if maxBytes <= mmapCacheHeaderSize {
+		return nil, fmt.Errorf("size %d too small", maxBytes)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(file, true); err != nil {
+		file.Close()
+		return nil, err
+	}
+	defer unlockFile(file)
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if info.Size() != maxBytes {
+		if err := file.Truncate(maxBytes); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	data, err := mapFile(file, int(maxBytes))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	c := &mmapCache{file: file, data: data}
+	if string(data[:8]) != mmapCacheMagic || c.tail() < mmapCacheHeaderSize || c.tail() > uint64(len(data)) {
+		clear(data)
+		copy(data, mmapCacheMagic)
+		binary.LittleEndian.PutUint64(data[16:24], mmapCacheHeaderSize)
+	}
+	c.refresh()
+	if len(c.index) > 0 {
+		fmt.Println("Reloaded cached responses:", len(c.index))
+	}
+	return c, nil
+}
+
+// tail returns the offset where the next entry goes
+func (c *mmapCache) tail() uint64 {
+	return binary.LittleEndian.Uint64(c.data[16:24])
+}
+
+// refresh indexes the entries other processes appended since the last call, starting over
+// after an eviction
+func (c *mmapCache) refresh() {
+	if generation := binary.LittleEndian.Uint64(c.data[8:16]); generation != c.generation || c.index == nil {
+		c.generation = generation
+		c.indexed = mmapCacheHeaderSize
+		c.index = make(map[string]uint64)
+	}
+
+	tail := c.tail()
+	for c.indexed+mmapEntryHeaderSize <= tail {
+		keyLen, size := c.entrySize(c.indexed)
+		if c.indexed+size > tail {
+			break
+		}
+		key := string(c.data[c.indexed+mmapEntryHeaderSize : c.indexed+mmapEntryHeaderSize+keyLen])
+		c.index[key] = c.indexed
+		c.indexed += size
+	}
+}
+
+// entrySize returns the key length and the total size of the entry at offset
+func (c *mmapCache) entrySize(offset uint64) (keyLen, size uint64) {
+	header := c.data[offset : offset+mmapEntryHeaderSize]
+	keyLen = uint64(binary.LittleEndian.Uint32(header[0:4]))
+	metaLen := uint64(binary.LittleEndian.Uint32(header[4:8]))
+	bodyLen := uint64(binary.LittleEndian.Uint32(header[8:12]))
+	return keyLen, mmapEntryHeaderSize + keyLen + metaLen + bodyLen
+}
+
+// get returns the cached response for the key if it hasn't expired
+func (c *mmapCache) get(key string) (*Response, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := lockFile(c.file, false); err != nil {
+		return nil, false
+	}
+	defer unlockFile(c.file)
+
+	c.refresh()
+	offset, ok := c.index[key]
+	if !ok {
+		return nil, false
+	}
+
+	header := c.data[offset : offset+mmapEntryHeaderSize]
+	keyLen := uint64(binary.LittleEndian.Uint32(header[0:4]))
+	metaLen := uint64(binary.LittleEndian.Uint32(header[4:8]))
+	bodyLen := uint64(binary.LittleEndian.Uint32(header[8:12]))
+	expires := int64(binary.LittleEndian.Uint64(header[12:20]))
+	if time.Now().UnixNano() > expires {
+		return nil, false
+	}
+
+	metaStart := offset + mmapEntryHeaderSize + keyLen
+	var meta mmapCachedMeta
+	if err := json.Unmarshal(c.data[metaStart:metaStart+metaLen], &meta); err != nil {
+		return nil, false
+	}
+	if meta.Headers == nil {
+		meta.Headers = make(map[string]string)
+	}
+	bodyStart := metaStart + metaLen
+	return &Response{
+		StatusLine:   meta.StatusLine,
+		Headers:      meta.Headers,
+		MultiHeaders: meta.MultiHeaders,
+		Body:         bytes.Clone(c.data[bodyStart : bodyStart+bodyLen]),
+	}, true
+}
+
+// put appends an entry for the response, evicting every entry first if the region is full.
+// Responses that wouldn't fit in an empty region aren't cached
+func (c *mmapCache) put(key string, response *Response, expires time.Time) error {
+	meta, err := json.Marshal(mmapCachedMeta{
+		StatusLine:   response.StatusLine,
+		Headers:      response.Headers,
+		MultiHeaders: response.MultiHeaders,
+	})
+	if err != nil {
+		return err
+	}
+	size := uint64(mmapEntryHeaderSize + len(key) + len(meta) + len(response.Body))
+	if size > uint64(len(c.data)-mmapCacheHeaderSize) {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := lockFile(c.file, true); err != nil {
+		return err
+	}
+	defer unlockFile(c.file)
+
+	c.refresh()
+	tail := c.tail()
+	if tail+size > uint64(len(c.data)) {
+		clear(c.data[mmapCacheHeaderSize:tail])
+		binary.LittleEndian.PutUint64(c.data[8:16], c.generation+1)
+		binary.LittleEndian.PutUint64(c.data[16:24], mmapCacheHeaderSize)
+		c.refresh()
+		tail = mmapCacheHeaderSize
+	}
+
+	entry := c.data[tail : tail+size]
+	binary.LittleEndian.PutUint32(entry[0:4], uint32(len(key)))
+	binary.LittleEndian.PutUint32(entry[4:8], uint32(len(meta)))
+	binary.LittleEndian.PutUint32(entry[8:12], uint32(len(response.Body)))
+	binary.LittleEndian.PutUint64(entry[12:20], uint64(expires.UnixNano()))
+	n := copy(entry[mmapEntryHeaderSize:], key)
+	n += copy(entry[mmapEntryHeaderSize+n:], meta)
+	copy(entry[mmapEntryHeaderSize+n:], response.Body)
+
+	// Move the tail last, so the entry is complete once it can be seen
+	binary.LittleEndian.PutUint64(c.data[16:24], tail+size)
+	c.refresh()
+	return nil
+}
@@ -0,0 +1,26 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// errMmapUnsupported is returned when mapping the cache file on a platform without mmap
+var errMmapUnsupported = errors.New("memory-mapped files are only supported on Unix")
+
+// mapFile is only available on Unix
+func mapFile(file *os.File, size int) ([]byte, error) {
+	return nil, errMmapUnsupported
+}
+
+// lockFile is only available on Unix
+func lockFile(file *os.File, exclusive bool) error {
+	return errMmapUnsupported
+}
+
+// unlockFile is only available on Unix
+func unlockFile(file *os.File) error {
+	return errMmapUnsupported
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// mockInteraction is a single recorded request and the response served for it
+type mockInteraction struct {
+	Request  mockRequest  `yaml:"request"`
+	Response mockResponse `yaml:"response"`
+}
+
+// mockRequest describes the requests an interaction matches
+type mockRequest struct {
+	Method  string            `yaml:"method"`
+	Path    string            `yaml:"path"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+}
+
+// mockResponse describes the response served for an interaction
+type mockResponse struct {
+	Status  int               `yaml:"status"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+	Body    string            `yaml:"body,omitempty"`
+}
+
+// mockCredentialHeaders are the headers carrying credentials, which are left out of the
+// recorded interactions. A request recorded without them matches whatever they are
+var mockCredentialHeaders = []string{"authorization", "proxy-authorization", "cookie", "set-cookie"}
+
+// mockStore holds the interactions loaded from or recorded to the mock file
+type mockStore struct {
+	mu           sync.Mutex
+	path         string
+	interactions []mockInteraction
+}
+
+// loadMockStore reads the interactions from the mock file, a missing file is
+// only accepted when recording
+func loadMockStore(path string, recording bool) (*mockStore, error) {
+	store := &mockStore{path: path}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && recording {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mock file: %w", err)
+	}
+	if err := yaml.Unmarshal(content, &store.interactions); err != nil {
+		return nil, fmt.Errorf("malformed mock file %s: %w", path, err)
+	}
+	return store, nil
+}
+
+// reload reads the interactions from the mock file again, replacing the current ones
+func (m *mockStore) reload() error {
+	content, err := os.ReadFile(m.path)
+	if err != nil {
+		return fmt.Errorf("failed to read mock file: %w", err)
+	}
+	var interactions []mockInteraction
+	if err := yaml.Unmarshal(content, &interactions); err != nil {
+		return fmt.Errorf("malformed mock file %s: %w", m.path, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.interactions = interactions
+	return nil
+}
+
+// match returns the response of the first interaction matching the request
+func (m *mockStore) match(req *Request) (*Response, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, interaction := range m.interactions {
+		if interaction.Request.matches(req) {
+			headers := make(map[string]string, len(interaction.Response.Headers))
+			for k, v := range interaction.Response.Headers {
+				headers[k] = v
+			}
+			return &Response{
+				StatusLine: statusLineFor(interaction.Response.Status),
+				Headers:    headers,
+				Body:       []byte(interaction.Response.Body),
+			}, true
+		}
+	}
+	return nil, false
+}
+
+// matches reports whether the request has the method, path and all headers of the fixture
+func (m mockRequest) matches(req *Request) bool {
+	if !strings.EqualFold(m.Method, req.Method) || m.Path != req.Path {
+		return false
+	}
+	for k, v := range m.Headers {
+		if req.Headers[strings.ToLower(k)] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// record appends an interaction and rewrites the mock file
+func (m *mockStore) record(req *Request, response *Response) {
+	requestHeaders := withoutCredentials(req.Headers)
+	responseHeaders := withoutCredentials(response.Headers)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.interactions = append(m.interactions, mockInteraction{
+		Request: mockRequest{
+			Method:  req.Method,
+			Path:    req.Path,
+			Headers: requestHeaders,
+		},
+		Response: mockResponse{
+			Status:  statusCode(response.StatusLine),
+			Headers: responseHeaders,
+			Body:    string(response.Body),
+		},
+	})
+
+	content, err := yaml.Marshal(m.interactions)
+	if err == nil {
+		err = writeMockFile(m.path, content)
+	}
+	if err != nil {
+		fmt.Println("Error recording mock interaction:", err)
+	}
+}
+
+// withoutCredentials copies the headers, leaving out mockCredentialHeaders
+func withoutCredentials(headers map[string]string) map[string]string {
+	copied := make(map[string]string, len(headers))
+	for k, v := range headers {
+		if !slices.Contains(mockCredentialHeaders, strings.ToLower(k)) {
+			copied[k] = v
+		}
+	}
+	return copied
+}
+
+// writeMockFile replaces the mock file through a temporary file, which leaves the new one
+// readable by its owner only: the recorded responses may hold private content
+func writeMockFile(path string, content []byte) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), ".mock-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	_, err = temp.Write(content)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+// mockMiddleware serves responses from the mock file instead of the real handlers,
+// or records the real responses to it in record mode
+func (s *Server) mockMiddleware(next Handler) Handler {
+	return HandlerFunc(func(req *Request) *Response {
+		if s.mocks == nil {
+			return next.Handle(req)
+		}
+
+		if s.RecordMode {
+			// Streamed responses aren't known until they have been written, they aren't recorded
+			response := next.Handle(req)
+			if response.dispatch == nil {
+				s.mocks.record(req, response)
+			}
+			return response
+		}
+
+		if response, ok := s.mocks.match(req); ok {
+			return response
+		}
+		fmt.Println("No mock interaction for", req.Method, req.Path)
+		return &Response{
+			StatusLine: StatusNotImplemented,
+			Headers:    make(map[string]string),
+		}
+	})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestRecordModeLeavesOutCredentials(t *testing.T) {
+	s := newTestServer(t)
+	s.MockFile = filepath.Join(t.TempDir(), "mocks.yaml")
+	s.RecordMode = true
+	mocks, err := loadMockStore(s.MockFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mocks = mocks
+
+	req := fileRequest("GET", "", "")
+	req.Path = "/echo/hello"
+	req.Headers["authorization"] = "Bearer secret-token"
+	req.Headers["cookie"] = "session=secret-session"
+	req.Headers["x-trace"] = "abc"
+	expectStatus(t, s.Handler.Handle(req), 200)
+
+	content, err := os.ReadFile(s.MockFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "secret") {
+		t.Errorf("the mock file holds credentials:\n%s", content)
+	}
+	if !strings.Contains(string(content), "x-trace") {
+		t.Errorf("the mock file lost the other headers:\n%s", content)
+	}
+	if info, err := os.Stat(s.MockFile); err != nil {
+		t.Fatal(err)
+	} else if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("got mode %v for the mock file, want -rw-------", info.Mode().Perm())
+	}
+
+	// The recorded interaction is served whatever the credentials
+	replayed, err := loadMockStore(s.MockFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Headers["authorization"] = "Bearer other-token"
+	delete(req.Headers, "cookie")
+	response, ok := replayed.match(req)
+	if !ok {
+		t.Fatal("the recorded interaction doesn't match without the credentials")
+	}
+	if string(response.Body) != "hello" {
+		t.Errorf("got body %q, want %q", response.Body, "hello")
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// handleFileReplace handles PUT /files/{name}, which overwrites an existing file
+func (s *Server) handleFileReplace(req *Request, fullPath string) *Response {
+	if !s.uploadExtensionAllowed(fullPath) {
+		return &Response{StatusLine: StatusUnsupportedMediaType, Headers: make(map[string]string)}
+	}
+	if response := s.checkExistingFile(fullPath); response != nil {
+		return response
+	}
+	return s.writeFileContent(fullPath, req.Body)
+}
+
+// handleFileDelete handles DELETE /files/{name}
+func (s *Server) handleFileDelete(fullPath string) *Response {
+	if response := s.checkExistingFile(fullPath); response != nil {
+		return response
+	}
+	s.forgetUploadHash(fullPath)
+	if err := os.Remove(fullPath); err != nil {
+		fmt.Println("Error deleting file:", err)
+		return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+	}
+	// The sidecar would outlive the file otherwise
+	os.Remove(s.sidecarPath(fullPath))
+	s.usage.reset()
+	s.invalidateDirs(fullPath)
+
+	return &Response{StatusLine: StatusNoContent, Headers: make(map[string]string)}
+}
+
+// handleFilePatch handles PATCH /files/{name}. A JSON body updates the file's metadata,
+// anything else is appended to the file, or written at the offset of a Content-Range header
+func (s *Server) handleFilePatch(req *Request, fullPath string) *Response {
+	contentType, _, _ := strings.Cut(req.Headers["content-type"], ";")
+	if strings.TrimSpace(contentType) == "application/json" {
+		return s.handleFileTouch(req, fullPath)
+	}
+
+	if response := s.checkExistingFile(fullPath); response != nil {
+		return response
+	}
+	content, err := s.readFileContent(fullPath)
+	if err != nil {
+		fmt.Println("Error reading file:", err)
+		return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+	}
+
+	offset := int64(len(content))
+	if header := req.Headers["content-range"]; header != "" {
+		start, end, _, err := parseContentRange(header)
+		if err != nil {
+			return textResponse(StatusBadRequest, err.Error())
+		}
+		if end-start+1 != int64(len(req.Body)) {
+			return textResponse(StatusBadRequest, "Content-Range doesn't match the body length")
+		}
+		if start > int64(len(content)) {
+			return textResponse(StatusBadRequest, fmt.Sprintf("Content-Range starts past the end of the file (%d bytes)", len(content)))
+		}
+		offset = start
+	}
+
+	updated := append(content[:offset:offset], req.Body...)
+	if tail := offset + int64(len(req.Body)); tail < int64(len(content)) {
+		updated = append(updated, content[tail:]...)
+	}
+	return s.writeFileContent(fullPath, updated)
+}
+
+// replaceFile writes the content to a temporary file next to fullPath and renames it over
+// fullPath, so readers never see a partial file and other hard links to it keep the old content
+func replaceFile(fullPath string, content []byte) error {
+	temp, err := os.CreateTemp(filepath.Dir(fullPath), ".write-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	_, err = temp.Write(content)
+	if err == nil {
+		err = temp.Chmod(0644)
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), fullPath)
+}
+
+// checkExistingFile returns the response for a request to modify a file that doesn't
+// exist or isn't a regular file, or nil if it can be modified
+func (s *Server) checkExistingFile(fullPath string) *Response {
+	info, err := os.Stat(fullPath)
+	if os.IsNotExist(err) {
+		return &Response{StatusLine: StatusNotFound, Headers: make(map[string]string)}
+	} else if err != nil {
+		fmt.Println("Error checking file existence:", err)
+		return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+	}
+	if !info.Mode().IsRegular() {
+		return textResponse(StatusConflict, "not a regular file")
+	}
+	return nil
+}
+
+// readFileContent reads a stored file, decrypting it when encryption at rest is enabled
+func (s *Server) readFileContent(fullPath string) ([]byte, error) {
+	content, err := os.ReadFile(fullPath)
+	if err != nil || len(s.EncryptionKey) == 0 {
+		return content, err
+	}
+	return decryptFile(s.EncryptionKey, content)
+}
+
+// writeFileContent replaces an existing file's content, keeping the previous content as a
+// version when versioning is enabled
+func (s *Server) writeFileContent(fullPath string, content []byte) *Response {
+	if len(s.EncryptionKey) > 0 {
+		encrypted, err := encryptFile(s.EncryptionKey, content)
+		if err != nil {
+			fmt.Println("Error encrypting file:", err)
+			return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+		}
+		content = encrypted
+	}
+
+	// Only the growth counts against the quota, unless the old content is kept as a version
+	if s.DirectoryQuotaBytes > 0 {
+		growth := int64(len(content))
+		if info, err := os.Stat(fullPath); err == nil && !s.VersionFiles {
+			growth -= info.Size()
+		}
+		ok, err := s.usage.reserve(s.Directory, growth, s.DirectoryQuotaBytes)
+		if err != nil {
+			fmt.Println("Error computing directory usage:", err)
+			return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+		}
+		if !ok {
+			fmt.Println("Directory quota exceeded, rejecting:", fullPath)
+			return &Response{StatusLine: StatusInsufficientStorage, Headers: make(map[string]string)}
+		}
+	}
+
+	if s.VersionFiles {
+		if err := s.saveVersion(fullPath); err != nil {
+			s.usage.reset()
+			fmt.Println("Error saving file version:", err)
+			return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+		}
+	}
+	s.forgetUploadHash(fullPath)
+	if err := replaceFile(fullPath, content); err != nil {
+		s.usage.reset()
+		fmt.Println("Error writing file:", err)
+		return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+	}
+	s.invalidateDirs(fullPath)
+
+	if s.PrewarmCompression && len(s.EncryptionKey) == 0 {
+		go s.prewarmCompression(fullPath)
+	}
+	return &Response{StatusLine: StatusNoContent, Headers: make(map[string]string)}
+}
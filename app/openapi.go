@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// swaggerUIPage renders the Swagger UI for the served OpenAPI spec
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>API documentation</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.onload = function () {
+      SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui"});
+    };
+  </script>
+</body>
+</html>
+`
+
+// openAPISpec holds the OpenAPI spec in both formats, reloading it when the file changes
+type openAPISpec struct {
+	mu      sync.RWMutex
+	path    string
+	modTime time.Time
+	json    []byte
+	yaml    []byte
+}
+
+// loadOpenAPISpec reads and validates the spec file
+func loadOpenAPISpec(path string) (*openAPISpec, error) {
+	spec := &openAPISpec{path: path}
+	if err := spec.reload(); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// reload parses the spec file and converts it to JSON and YAML
+func (spec *openAPISpec) reload() error {
+	info, err := os.Stat(spec.path)
+	if err != nil {
+		return fmt.Errorf("failed to read OpenAPI spec: %w", err)
+	}
+	content, err := os.ReadFile(spec.path)
+	if err != nil {
+		return fmt.Errorf("failed to read OpenAPI spec: %w", err)
+	}
+
+	// YAML is a superset of JSON, but use the stricter parser for .json files
+	var document map[string]any
+	if strings.EqualFold(filepath.Ext(spec.path), ".json") {
+		err = json.Unmarshal(content, &document)
+	} else {
+		err = yaml.Unmarshal(content, &document)
+	}
+	if err != nil {
+		return fmt.Errorf("malformed OpenAPI spec %s: %w", spec.path, err)
+	}
+	if document["openapi"] == nil && document["swagger"] == nil {
+		return fmt.Errorf("malformed OpenAPI spec %s: missing openapi version", spec.path)
+	}
+
+	jsonContent, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return fmt.Errorf("malformed OpenAPI spec %s: %w", spec.path, err)
+	}
+	yamlContent, err := yaml.Marshal(document)
+	if err != nil {
+		return fmt.Errorf("malformed OpenAPI spec %s: %w", spec.path, err)
+	}
+
+	spec.mu.Lock()
+	spec.modTime = info.ModTime()
+	spec.json = jsonContent
+	spec.yaml = yamlContent
+	spec.mu.Unlock()
+	return nil
+}
+
+// current returns the spec in both formats, picking up changes to the file first
+func (spec *openAPISpec) current() ([]byte, []byte) {
+	spec.mu.RLock()
+	modTime := spec.modTime
+	spec.mu.RUnlock()
+
+	if info, err := os.Stat(spec.path); err == nil && !info.ModTime().Equal(modTime) {
+		if err := spec.reload(); err != nil {
+			fmt.Println("Error reloading OpenAPI spec, keeping the previous one:", err)
+		} else {
+			fmt.Println("Reloaded OpenAPI spec:", spec.path)
+		}
+	}
+
+	spec.mu.RLock()
+	defer spec.mu.RUnlock()
+	return spec.json, spec.yaml
+}
+
+// handleOpenAPI handles the /openapi.json and /openapi.yaml endpoints
+func (s *Server) handleOpenAPI(req *Request) *Response {
+	if s.openAPI == nil {
+		return &Response{
+			StatusLine: StatusNotFound,
+			Headers:    make(map[string]string),
+		}
+	}
+
+	jsonContent, yamlContent := s.openAPI.current()
+	if strings.HasSuffix(req.Path, ".yaml") {
+		return &Response{
+			StatusLine: StatusOK,
+			Headers:    map[string]string{"Content-Type": "application/yaml"},
+			Body:       yamlContent,
+		}
+	}
+	return &Response{
+		StatusLine: StatusOK,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       jsonContent,
+	}
+}
+
+// handleDocs handles the /docs endpoint serving the Swagger UI
+func (s *Server) handleDocs(req *Request) *Response {
+	if s.openAPI == nil {
+		return &Response{
+			StatusLine: StatusNotFound,
+			Headers:    make(map[string]string),
+		}
+	}
+	return &Response{
+		StatusLine: StatusOK,
+		Headers:    map[string]string{"Content-Type": "text/html; charset=utf-8"},
+		Body:       []byte(swaggerUIPage),
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// maxPacingDelay caps how long a single request is held back
+const maxPacingDelay = 5 * time.Second
+
+// pacingEvictInterval is how often the states of idle clients are dropped
+const pacingEvictInterval = time.Minute
+
+// pacingState holds the earliest time the next request from a client may run
+type pacingState struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+// PacingMiddleware slows down clients by spacing their requests at least minInterval apart,
+// forgetting the clients that went idle for as long as the process runs
+func PacingMiddleware(minInterval time.Duration) Middleware {
+	var clients sync.Map // client IP -> *pacingState
+	go func() {
+		ticker := time.NewTicker(pacingEvictInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			evictIdlePacing(&clients, now)
+		}
+	}()
+
+	return func(next Handler) Handler {
+		return HandlerFunc(func(req *Request) *Response {
+			value, _ := clients.LoadOrStore(clientIP(req), &pacingState{})
+			state := value.(*pacingState)
+
+			// Reserve the next slot so concurrent requests queue up behind each other
+			state.mu.Lock()
+			now := time.Now()
+			slot := now
+			if state.next.After(now) {
+				slot = state.next
+			}
+			if slot.Sub(now) > maxPacingDelay {
+				slot = now.Add(maxPacingDelay)
+			}
+			state.next = slot.Add(minInterval)
+			state.mu.Unlock()
+
+			if delay := slot.Sub(now); delay > 0 {
+				time.Sleep(delay)
+			}
+			return next.Handle(req)
+		})
+	}
+}
+
+// evictIdlePacing drops the states whose next slot has passed. A new state lets the
+// request through at once as well, so dropping them changes nothing for their clients
+func evictIdlePacing(clients *sync.Map, now time.Time) {
+	clients.Range(func(key, value any) bool {
+		state := value.(*pacingState)
+		state.mu.Lock()
+		if !state.next.After(now) {
+			clients.CompareAndDelete(key, state)
+		}
+		state.mu.Unlock()
+		return true
+	})
+}
+
+// clientIP returns the IP address of the client that sent the request
+func clientIP(req *Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPacingMiddlewareSpacesRequests(t *testing.T) {
+	const interval = 100 * time.Millisecond
+	var mu sync.Mutex
+	var handled []time.Time
+	handler := PacingMiddleware(interval)(HandlerFunc(func(req *Request) *Response {
+		mu.Lock()
+		handled = append(handled, time.Now())
+		mu.Unlock()
+		return &Response{StatusLine: StatusOK}
+	}))
+
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler.Handle(&Request{RemoteAddr: "192.0.2.1:1234"})
+		}()
+	}
+	wg.Wait()
+
+	if gap := handled[1].Sub(handled[0]); gap < interval-5*time.Millisecond {
+		t.Fatalf("requests ran %s apart, want at least %s", gap, interval)
+	}
+}
+
+func TestPacingMiddlewareKeepsClientsApart(t *testing.T) {
+	handler := PacingMiddleware(time.Second)(HandlerFunc(func(req *Request) *Response {
+		return &Response{StatusLine: StatusOK}
+	}))
+
+	started := time.Now()
+	handler.Handle(&Request{RemoteAddr: "192.0.2.1:1234"})
+	handler.Handle(&Request{RemoteAddr: "192.0.2.2:1234"})
+	if elapsed := time.Since(started); elapsed > 500*time.Millisecond {
+		t.Fatalf("a different client was held back for %s", elapsed)
+	}
+}
+
+func TestEvictIdlePacing(t *testing.T) {
+	var clients sync.Map
+	now := time.Now()
+	clients.Store("idle", &pacingState{next: now.Add(-time.Second)})
+	clients.Store("busy", &pacingState{next: now.Add(time.Second)})
+
+	evictIdlePacing(&clients, now)
+	if _, ok := clients.Load("idle"); ok {
+		t.Error("idle client kept")
+	}
+	if _, ok := clients.Load("busy"); !ok {
+		t.Error("client with a pending slot evicted")
+	}
+}
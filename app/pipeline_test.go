@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaxPipelineDepth(t *testing.T) {
+	s := newTestServer(t)
+	addr := startTestServer(t, s)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// All 20 requests arrive together, before any response is read
+	io.WriteString(conn, strings.Repeat("GET /echo/hi HTTP/1.1\r\nHost: localhost\r\n\r\n", 20))
+
+	reader := bufio.NewReader(conn)
+	for i := range 20 {
+		response, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("response %d: %v", i+1, err)
+		}
+		io.Copy(io.Discard, response.Body)
+		response.Body.Close()
+
+		want := 200
+		if i >= s.MaxPipelineDepth {
+			want = 503
+		}
+		if response.StatusCode != want {
+			t.Fatalf("response %d: got status %d, want %d", i+1, response.StatusCode, want)
+		}
+		if i == 19 && !response.Close {
+			t.Fatal("connection kept open after the backlog was drained")
+		}
+	}
+
+	// The server closes the connection once the backlog is answered
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Fatalf("got %v reading past the last response, want EOF", err)
+	}
+}
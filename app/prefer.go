@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// preferencesKey is the key of the request's preferences in Request.Ctx
+type preferencesKey struct{}
+
+// preferences are the preferences of a Prefer header (RFC 7240), and those the handlers honored
+type preferences struct {
+	values map[string]string // preference name -> value, names lowercased
+
+	mu      sync.Mutex
+	applied map[string]string
+}
+
+// parsePreferences parses Prefer headers such as "return=minimal, respond-async, wait=10",
+// ignoring the parameters after ";". The first occurrence of a preference wins
+func parsePreferences(header string) map[string]string {
+	values := make(map[string]string)
+	for _, preference := range strings.Split(header, ",") {
+		preference, _, _ = strings.Cut(preference, ";")
+		name, value, _ := strings.Cut(strings.TrimSpace(preference), "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if _, ok := values[name]; !ok {
+			values[name] = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return values
+}
+
+// PreferMiddleware makes the request's Prefer header available to handlers through
+// PreferReturnMinimal and PreferRespondAsync, and lists the preferences they honored
+// in the Preference-Applied response header
+func PreferMiddleware() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(req *Request) *Response {
+			header := req.Headers["prefer"]
+			if header == "" {
+				return next.Handle(req)
+			}
+
+			prefs := &preferences{values: parsePreferences(header), applied: make(map[string]string)}
+			req.Ctx = context.WithValue(req.Context(), preferencesKey{}, prefs)
+			response := next.Handle(req)
+
+			if applied := prefs.appliedHeader(); applied != "" {
+				if response.Headers == nil {
+					response.Headers = make(map[string]string)
+				}
+				response.Headers["Preference-Applied"] = applied
+				addVary(response, "Prefer")
+			}
+			return response
+		})
+	}
+}
+
+// PreferReturnMinimal reports whether the client asked for a minimal response with
+// Prefer: return=minimal, recording the preference as honored
+func PreferReturnMinimal(ctx context.Context) bool {
+	return preferenceApplied(ctx, "return", "minimal")
+}
+
+// PreferRespondAsync reports whether the client asked to be answered right away with
+// Prefer: respond-async, recording the preference as honored
+func PreferRespondAsync(ctx context.Context) bool {
+	return preferenceApplied(ctx, "respond-async", "")
+}
+
+// preferenceApplied checks for a preference and records it as honored when present
+func preferenceApplied(ctx context.Context, name, value string) bool {
+	prefs, ok := ctx.Value(preferencesKey{}).(*preferences)
+	if !ok {
+		return false
+	}
+	if got, ok := prefs.values[name]; !ok || !strings.EqualFold(got, value) {
+		return false
+	}
+
+	prefs.mu.Lock()
+	defer prefs.mu.Unlock()
+	prefs.applied[name] = value
+	return true
+}
+
+// appliedHeader formats the honored preferences for the Preference-Applied header
+func (p *preferences) appliedHeader() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	applied := make([]string, 0, len(p.applied))
+	for name, value := range p.applied {
+		if value == "" {
+			applied = append(applied, name)
+		} else {
+			applied = append(applied, name+"="+value)
+		}
+	}
+	sort.Strings(applied)
+	return strings.Join(applied, ", ")
+}
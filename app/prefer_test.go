@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// preferRequest returns a request for the handler with the Prefer header
+func preferRequest(prefer string) *Request {
+	req := fileRequest("POST", "", `{"files": ["a.txt"]}`)
+	if prefer != "" {
+		req.Headers["prefer"] = prefer
+	}
+	return req
+}
+
+func TestPreferReturnMinimal(t *testing.T) {
+	s := newTestServer(t)
+	expectStatus(t, s.handleFiles(fileRequest("POST", "a.txt", "content")), 201)
+	handler := PreferMiddleware()(HandlerFunc(s.handleBulkStat))
+
+	tests := []struct {
+		prefer      string
+		wantMinimal bool
+	}{
+		{"", false},
+		{"return=representation", false},
+		{"return=minimal", true},
+		{`wait=10, Return="minimal"; foo=bar`, true},
+	}
+	for _, tt := range tests {
+		response := handler.Handle(preferRequest(tt.prefer))
+		expectStatus(t, response, 200)
+
+		var body struct {
+			Results []bulkStatResult `json:"results"`
+		}
+		if err := json.Unmarshal(response.Body, &body); err != nil {
+			t.Fatal(err)
+		}
+		if len(body.Results) != 1 || !body.Results[0].Exists || body.Results[0].Size != 7 {
+			t.Fatalf("Prefer %q: got results %+v", tt.prefer, body.Results)
+		}
+		if minimal := body.Results[0].Mtime == nil; minimal != tt.wantMinimal {
+			t.Errorf("Prefer %q: got minimal %v, want %v", tt.prefer, minimal, tt.wantMinimal)
+		}
+
+		wantApplied := ""
+		if tt.wantMinimal {
+			wantApplied = "return=minimal"
+		}
+		if applied := response.Headers["Preference-Applied"]; applied != wantApplied {
+			t.Errorf("Prefer %q: got Preference-Applied %q, want %q", tt.prefer, applied, wantApplied)
+		}
+	}
+}
+
+func TestPreferRespondAsync(t *testing.T) {
+	handler := PreferMiddleware()(HandlerFunc(func(req *Request) *Response {
+		if PreferRespondAsync(req.Context()) {
+			return &Response{StatusLine: StatusAccepted}
+		}
+		return &Response{StatusLine: StatusOK}
+	}))
+
+	response := handler.Handle(preferRequest("respond-async, wait=100"))
+	expectStatus(t, response, 202)
+	if applied := response.Headers["Preference-Applied"]; applied != "respond-async" {
+		t.Errorf("got Preference-Applied %q, want respond-async", applied)
+	}
+	if vary := response.Headers["Vary"]; vary != "Prefer" {
+		t.Errorf("got Vary %q, want Prefer", vary)
+	}
+
+	// Preferences the handler didn't honor aren't reported
+	response = handler.Handle(preferRequest("return=minimal"))
+	expectStatus(t, response, 200)
+	if applied, ok := response.Headers["Preference-Applied"]; ok {
+		t.Errorf("got Preference-Applied %q for an ignored preference", applied)
+	}
+}
+
+func TestPreferenceHelpersWithoutMiddleware(t *testing.T) {
+	req := preferRequest("return=minimal, respond-async")
+	if PreferReturnMinimal(req.Context()) || PreferRespondAsync(req.Context()) {
+		t.Error("preferences reported without PreferMiddleware")
+	}
+}
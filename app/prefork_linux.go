@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// listenReusePort listens with SO_REUSEPORT so every prefork child can bind the same port
+// and the kernel balances connections between them
+func listenReusePort(address string) (net.Listener, error) {
+	config := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			err := c.Control(func(fd uintptr) {
+				sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+			})
+			if err != nil {
+				return err
+			}
+			return sockErr
+		},
+	}
+	return config.Listen(context.Background(), "tcp", address)
+}
+
+// runPreforkParent starts a child process per CPU, each serving the port on its own, and
+// restarts the children that crash; it never serves requests itself
+func (s *Server) runPreforkParent() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find executable for prefork: %w", err)
+	}
+	attr := &syscall.ProcAttr{
+		Env:   append(os.Environ(), preforkChildEnv+"=1"),
+		Files: []uintptr{os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd()},
+	}
+
+	children := runtime.GOMAXPROCS(0)
+	exited := make(chan int, children)
+	start := func() error {
+		pid, err := syscall.ForkExec(executable, os.Args, attr)
+		if err != nil {
+			return fmt.Errorf("failed to start prefork child: %w", err)
+		}
+		fmt.Println("Started prefork child:", pid)
+		go func() {
+			var status syscall.WaitStatus
+			syscall.Wait4(pid, &status, 0, nil)
+			if status.Exited() && status.ExitStatus() == 0 {
+				fmt.Println("Prefork child exited:", pid)
+				exited <- 0
+				return
+			}
+			reason := fmt.Sprintf("exit status %d", status.ExitStatus())
+			if status.Signaled() {
+				reason = status.Signal().String()
+			}
+			fmt.Println("Prefork child crashed:", pid, reason)
+			exited <- pid
+		}()
+		return nil
+	}
+
+	for i := 0; i < children; i++ {
+		if err := start(); err != nil {
+			return err
+		}
+	}
+
+	running := children
+	for running > 0 {
+		if pid := <-exited; pid == 0 {
+			running--
+			continue
+		}
+		// Don't spin when a child crashes right away
+		time.Sleep(time.Second)
+		if err := start(); err != nil {
+			fmt.Println(err)
+			running--
+		}
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// errPreforkUnsupported is returned when Prefork is enabled on a platform other than Linux
+var errPreforkUnsupported = errors.New("prefork is only supported on Linux")
+
+// listenReusePort is only available on Linux
+func listenReusePort(address string) (net.Listener, error) {
+	return nil, errPreforkUnsupported
+}
+
+// runPreforkParent is only available on Linux
+func (s *Server) runPreforkParent() error {
+	return errPreforkUnsupported
+}
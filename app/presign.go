@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxPresignExpiry bounds how long a pre-signed URL may stay valid
+const maxPresignExpiry = 7 * 24 * time.Hour
+
+// presignRequest is the JSON body of POST /files/presign
+type presignRequest struct {
+	Filename  string `json:"filename"`
+	ExpiresIn int64  `json:"expires_in"` // seconds, defaults to an hour
+}
+
+// handlePresign handles POST /files/presign, issuing a single-use download URL for a file
+func (s *Server) handlePresign(req *Request) *Response {
+	if req.Method != "POST" || s.Directory == "" {
+		return s.handleFiles(req)
+	}
+
+	var body presignRequest
+	if err := json.Unmarshal(req.Body, &body); err != nil {
+		return textResponse(StatusBadRequest, "invalid JSON body: "+err.Error())
+	}
+	if body.Filename == "" {
+		return textResponse(StatusBadRequest, "filename is required")
+	}
+	if _, err := s.resolveFilePath(body.Filename); err != nil {
+		return textResponse(StatusBadRequest, err.Error())
+	}
+	expiresIn := time.Duration(body.ExpiresIn) * time.Second
+	if body.ExpiresIn == 0 {
+		expiresIn = time.Hour
+	}
+	if expiresIn < 0 || expiresIn > maxPresignExpiry {
+		return textResponse(StatusBadRequest, fmt.Sprintf("expires_in must be between 1 and %d seconds", int64(maxPresignExpiry.Seconds())))
+	}
+
+	expires := time.Now().Add(expiresIn)
+	token := s.signToken(body.Filename, expires)
+	s.pruneTokens()
+	s.presignedTokens.Store(token, expires)
+	s.audit(req, "file.presign", StatusOK)
+
+	content, _ := json.Marshal(map[string]string{"url": "/files/?token=" + token})
+	return &Response{
+		StatusLine: StatusOK,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       content,
+	}
+}
+
+// handlePresignedDownload handles GET /files/?token=, serving the file the token was issued for
+func (s *Server) handlePresignedDownload(req *Request, w ResponseWriter) {
+	token := req.Query.Get("token")
+	filename, err := s.verifyToken(token)
+	if err != nil {
+		fmt.Println("Rejected pre-signed URL:", err)
+		writeError(w, 403, err.Error())
+		return
+	}
+	// Tokens are single-use, whoever removes it first gets the file
+	if _, ok := s.presignedTokens.LoadAndDelete(token); !ok {
+		writeError(w, 403, "token already used")
+		return
+	}
+
+	fullPath, err := s.resolveFilePath(filename)
+	if err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
+	writeResponse(w, s.handleFileDownload(req, fullPath))
+}
+
+// presignKey returns the key signing pre-signed URLs, generating a random one on first use
+func (s *Server) presignKey() []byte {
+	s.presignOnce.Do(func() {
+		if len(s.PresignKey) == 0 {
+			s.PresignKey = make([]byte, 32)
+			rand.Read(s.PresignKey)
+		}
+	})
+	return s.PresignKey
+}
+
+// signToken encodes the filename and expiry followed by their HMAC-SHA256
+func (s *Server) signToken(filename string, expires time.Time) string {
+	payload := filename + "\n" + strconv.FormatInt(expires.Unix(), 10)
+	mac := hmac.New(sha256.New, s.presignKey())
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyToken checks the token's signature and expiry and returns the filename it was issued for
+func (s *Server) verifyToken(token string) (string, error) {
+	encodedPayload, encodedSignature, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", errors.New("malformed token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return "", errors.New("malformed token")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return "", errors.New("malformed token")
+	}
+
+	mac := hmac.New(sha256.New, s.presignKey())
+	mac.Write(payload)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return "", errors.New("invalid token signature")
+	}
+
+	filename, expiry, ok := strings.Cut(string(payload), "\n")
+	if !ok {
+		return "", errors.New("malformed token")
+	}
+	unix, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return "", errors.New("malformed token")
+	}
+	if time.Now().After(time.Unix(unix, 0)) {
+		s.presignedTokens.Delete(token)
+		return "", errors.New("token expired")
+	}
+	return filename, nil
+}
+
+// pruneTokens forgets tokens that expired without being used
+func (s *Server) pruneTokens() {
+	now := time.Now()
+	s.presignedTokens.Range(func(key, value any) bool {
+		if now.After(value.(time.Time)) {
+			s.presignedTokens.Delete(key)
+		}
+		return true
+	})
+}
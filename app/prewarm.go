@@ -0,0 +1,102 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// precompressedSuffix is appended to a file name to get its gzip sidecar
+const precompressedSuffix = ".gz"
+
+// precompressedDirName is the directory under the served directory holding the gzip
+// sidecars, out of the way of the files clients upload
+const precompressedDirName = ".gzcache"
+
+// prewarmSemaphore returns the channel limiting concurrent prewarm workers
+func (s *Server) prewarmSemaphore() chan struct{} {
+	s.prewarmOnce.Do(func() {
+		workers := s.PrewarmWorkers
+		if workers <= 0 {
+			workers = 1
+		}
+		s.prewarmSem = make(chan struct{}, workers)
+	})
+	return s.prewarmSem
+}
+
+// sidecarPath returns where the gzip sidecar of a file is kept
+func (s *Server) sidecarPath(fullPath string) string {
+	relative, err := filepath.Rel(s.Directory, fullPath)
+	if err != nil {
+		relative = filepath.Base(fullPath)
+	}
+	return filepath.Join(s.Directory, precompressedDirName, relative+precompressedSuffix)
+}
+
+// prewarmCompression writes the gzip sidecar of an uploaded file
+func (s *Server) prewarmCompression(fullPath string) {
+	sem := s.prewarmSemaphore()
+	sem <- struct{}{}
+	defer func() { <-sem }()
+
+	started := time.Now()
+
+	src, err := os.Open(fullPath)
+	if err != nil {
+		fmt.Println("Error opening file for prewarm:", err)
+		return
+	}
+	defer src.Close()
+
+	// Write to a temporary file first so downloads never see a partial sidecar
+	sidecar := s.sidecarPath(fullPath)
+	if err := os.MkdirAll(filepath.Dir(sidecar), 0755); err != nil {
+		fmt.Println("Error creating prewarm directory:", err)
+		return
+	}
+	dst, err := os.CreateTemp(filepath.Dir(sidecar), ".prewarm-*")
+	if err != nil {
+		fmt.Println("Error creating prewarm file:", err)
+		return
+	}
+	tmpPath := dst.Name()
+
+	gz := gzip.NewWriter(dst)
+	written, err := io.Copy(gz, src)
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, sidecar)
+	}
+	if err != nil {
+		fmt.Println("Error prewarming compression:", err)
+		os.Remove(tmpPath)
+		return
+	}
+	s.invalidateDirs(fullPath)
+	s.usage.reset()
+
+	ratio := 0.0
+	if info, err := os.Stat(sidecar); err == nil && written > 0 {
+		ratio = float64(info.Size()) / float64(written)
+	}
+	fmt.Printf("Prewarmed %s: ratio %.2f in %s\n", fullPath, ratio, time.Since(started))
+}
+
+// precompressedPath returns the gzip sidecar for fullPath if it is up to date
+func (s *Server) precompressedPath(fullPath string, fileInfo os.FileInfo) (string, bool) {
+	sidecar := s.sidecarPath(fullPath)
+	sidecarInfo, err := os.Stat(sidecar)
+	if err != nil || sidecarInfo.IsDir() || sidecarInfo.ModTime().Before(fileInfo.ModTime()) {
+		return "", false
+	}
+	return sidecar, true
+}
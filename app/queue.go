@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net"
+	"sync"
+)
+
+// QueueMode selects the order in which queued connections are served
+type QueueMode int
+
+const (
+	// FIFO serves connections in the order they were accepted and drops new ones when full
+	FIFO QueueMode = iota
+	// LIFO serves the newest connection first and drops the oldest when full
+	LIFO
+	// Block serves connections in the order they were accepted and stops accepting while
+	// the queue is full, leaving new clients in the listen backlog. Unlike the other modes
+	// it turns nobody away, which is why servers made by NewServer use it
+	Block
+)
+
+// connQueue is a bounded queue of accepted connections waiting for a worker
+type connQueue struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	space    *sync.Cond // signalled when a connection leaves a full queue, for Block
+	conns    []net.Conn
+	capacity int
+	mode     QueueMode
+	closed   bool
+}
+
+// newConnQueue creates a queue holding at most capacity connections
+func newConnQueue(capacity int, mode QueueMode) *connQueue {
+	q := &connQueue{
+		conns:    make([]net.Conn, 0, capacity),
+		capacity: capacity,
+		mode:     mode,
+	}
+	q.cond = sync.NewCond(&q.mu)
+	q.space = sync.NewCond(&q.mu)
+	return q
+}
+
+// push adds a connection to the queue and returns the connection that had to be
+// dropped because the queue was full or closed, or nil. In Block mode it waits for room instead
+func (q *connQueue) push(conn net.Conn) net.Conn {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.mode == Block && len(q.conns) >= q.capacity && !q.closed {
+		q.space.Wait()
+	}
+	if q.closed {
+		return conn
+	}
+
+	var dropped net.Conn
+	if len(q.conns) >= q.capacity {
+		if q.mode == FIFO {
+			return conn
+		}
+		// The oldest client has most likely given up already
+		dropped = q.conns[0]
+		q.conns = q.conns[1:]
+	}
+
+	q.conns = append(q.conns, conn)
+	q.cond.Signal()
+	return dropped
+}
+
+// pop blocks until a connection is available and removes it from the queue. Once the
+// queue is closed and empty it returns nil
+func (q *connQueue) pop() net.Conn {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.conns) == 0 {
+		if q.closed {
+			return nil
+		}
+		q.cond.Wait()
+	}
+
+	var conn net.Conn
+	if q.mode == LIFO {
+		conn = q.conns[len(q.conns)-1]
+		q.conns = q.conns[:len(q.conns)-1]
+	} else {
+		conn = q.conns[0]
+		q.conns = q.conns[1:]
+	}
+	q.space.Signal()
+	return conn
+}
+
+// close stops the queue taking connections, the ones already queued are still served
+// before pop returns nil to the waiting workers
+func (q *connQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.space.Broadcast()
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testConns returns n connections for queueing, never read or written
+func testConns(t *testing.T, n int) []net.Conn {
+	conns := make([]net.Conn, n)
+	for i := range conns {
+		client, server := net.Pipe()
+		t.Cleanup(func() {
+			client.Close()
+			server.Close()
+		})
+		conns[i] = server
+	}
+	return conns
+}
+
+func TestConnQueueFIFO(t *testing.T) {
+	conns := testConns(t, 3)
+	q := newConnQueue(2, FIFO)
+	for _, conn := range conns[:2] {
+		if dropped := q.push(conn); dropped != nil {
+			t.Fatal("dropped a connection before the queue was full")
+		}
+	}
+	// The newest connection is turned away
+	if dropped := q.push(conns[2]); dropped != conns[2] {
+		t.Fatal("didn't drop the new connection from a full queue")
+	}
+	if q.pop() != conns[0] || q.pop() != conns[1] {
+		t.Fatal("connections weren't served in order")
+	}
+}
+
+func TestConnQueueLIFO(t *testing.T) {
+	conns := testConns(t, 3)
+	q := newConnQueue(2, LIFO)
+	q.push(conns[0])
+	q.push(conns[1])
+	// The oldest connection makes room
+	if dropped := q.push(conns[2]); dropped != conns[0] {
+		t.Fatal("didn't drop the oldest connection from a full queue")
+	}
+	if q.pop() != conns[2] || q.pop() != conns[1] {
+		t.Fatal("the newest connection wasn't served first")
+	}
+}
+
+func TestConnQueueClose(t *testing.T) {
+	conns := testConns(t, 3)
+	q := newConnQueue(2, Block)
+	q.push(conns[0])
+	q.push(conns[1])
+
+	// A push waiting for room gives up when the queue is closed
+	pushed := make(chan net.Conn)
+	go func() { pushed <- q.push(conns[2]) }()
+	select {
+	case <-pushed:
+		t.Fatal("push didn't wait for room in a full queue")
+	case <-time.After(10 * time.Millisecond):
+	}
+	q.close()
+	if dropped := <-pushed; dropped != conns[2] {
+		t.Fatal("push to a closed queue didn't return the connection")
+	}
+
+	// Queued connections are still served, then the workers are told to stop
+	if q.pop() != conns[0] || q.pop() != conns[1] {
+		t.Fatal("queued connections weren't served after closing")
+	}
+	if conn := q.pop(); conn != nil {
+		t.Fatal("pop on a closed, empty queue returned a connection")
+	}
+}
+
+// burstServer starts a server serving at most workers connections at once, whose /slow
+// route blocks until release is closed. It reports how many requests are being handled
+func burstServer(t *testing.T, workers int, mode QueueMode) (addr string, inFlight *atomic.Int32, maxInFlight *atomic.Int32, release chan struct{}) {
+	s := newTestServer(t)
+	s.MaxConcurrentConnections = workers
+	s.QueueMode = mode
+	inFlight, maxInFlight = new(atomic.Int32), new(atomic.Int32)
+	release = make(chan struct{})
+	s.Router.GET("/slow", func(req *Request) *Response {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for seen := maxInFlight.Load(); n > seen && !maxInFlight.CompareAndSwap(seen, n); seen = maxInFlight.Load() {
+		}
+		<-release
+		return &Response{StatusLine: StatusOK}
+	})
+	return startTestServer(t, s), inFlight, maxInFlight, release
+}
+
+// sendSlow opens a connection and sends a request for /slow on it
+func sendSlow(t *testing.T, addr string) net.Conn {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if _, err := io.WriteString(conn, "GET /slow HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// waitFor polls until the condition holds, failing the test after a few seconds
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !condition(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// goroutineRunning reports whether any goroutine has the function on its stack
+func goroutineRunning(function string) bool {
+	buf := make([]byte, 1<<20)
+	return strings.Contains(string(buf[:runtime.Stack(buf, true)]), function+"(")
+}
+
+// answered reports whether the server responded with 200 before closing the connection
+func answered(conn net.Conn) bool {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	response, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return false
+	}
+	response.Body.Close()
+	return response.StatusCode == 200
+}
+
+func TestConnectionBurstBlock(t *testing.T) {
+	const workers, clients = 4, 40
+	if mode := NewServer("").QueueMode; mode != Block {
+		t.Fatalf("got queue mode %d by default, want Block", mode)
+	}
+	t.Cleanup(func() {
+		// Runs after the server shut down, its workers must have exited
+		waitFor(t, "the workers to exit", func() bool { return !goroutineRunning(".(*connQueue).pop") })
+	})
+	addr, inFlight, maxInFlight, release := burstServer(t, workers, Block)
+
+	conns := make([]net.Conn, clients)
+	for i := range conns {
+		conns[i] = sendSlow(t, addr)
+	}
+	waitFor(t, "the workers to be busy", func() bool { return inFlight.Load() == workers })
+	time.Sleep(50 * time.Millisecond)
+	if n := maxInFlight.Load(); n != workers {
+		t.Fatalf("%d requests were handled at once, want %d", n, workers)
+	}
+
+	// Every client is served eventually, however many waited
+	close(release)
+	var wg sync.WaitGroup
+	var served atomic.Int32
+	for _, conn := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if answered(conn) {
+				served.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := served.Load(); n != clients {
+		t.Errorf("served %d of %d clients", n, clients)
+	}
+	if n := maxInFlight.Load(); n > workers {
+		t.Errorf("%d requests were handled at once, want at most %d", n, workers)
+	}
+}
+
+func TestConnectionBurstFIFO(t *testing.T) {
+	const workers, clients = 4, 20
+	addr, inFlight, maxInFlight, release := burstServer(t, workers, FIFO)
+
+	// Occupy every worker, then fill the queue; the rest of the burst is turned away
+	var conns []net.Conn
+	for range workers {
+		conns = append(conns, sendSlow(t, addr))
+	}
+	waitFor(t, "the workers to be busy", func() bool { return inFlight.Load() == workers })
+	for range clients - workers {
+		conns = append(conns, sendSlow(t, addr))
+	}
+
+	// The dropped connections are closed without a response while the workers are still busy
+	for _, conn := range conns[2*workers:] {
+		if answered(conn) {
+			t.Fatal("a client beyond the queue's capacity was served")
+		}
+	}
+
+	close(release)
+	for i, conn := range conns[:2*workers] {
+		if !answered(conn) {
+			t.Errorf("client %d wasn't served", i)
+		}
+	}
+	if n := maxInFlight.Load(); n > workers {
+		t.Errorf("%d requests were handled at once, want at most %d", n, workers)
+	}
+}
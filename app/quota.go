@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sync"
+)
+
+// errQuotaExceeded is returned when a write doesn't fit in Server.DirectoryQuotaBytes
+var errQuotaExceeded = errors.New("directory quota exceeded")
+
+// storageUsage caches the total size of the files in the directory, so enforcing
+// Server.DirectoryQuotaBytes doesn't walk the directory on every upload
+type storageUsage struct {
+	mu    sync.Mutex
+	known bool
+	used  int64
+}
+
+// reserve counts n more bytes as used, unless that would exceed the quota
+func (u *storageUsage) reserve(dir string, n, quota int64) (bool, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if !u.known {
+		used, err := directorySize(dir)
+		if err != nil {
+			return false, err
+		}
+		u.used, u.known = used, true
+	}
+	if u.used+n > quota {
+		return false, nil
+	}
+	u.used += n
+	return true, nil
+}
+
+// reserveQuota counts n more bytes against DirectoryQuotaBytes, returning errQuotaExceeded
+// when they don't fit
+func (s *Server) reserveQuota(n int64) error {
+	ok, err := s.usage.reserve(s.Directory, n, s.DirectoryQuotaBytes)
+	if err != nil {
+		return fmt.Errorf("computing directory usage: %w", err)
+	}
+	if !ok {
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+// release gives back bytes reserved for a write that failed, or freed by a delete
+func (u *storageUsage) release(n int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.used -= n
+}
+
+// reset forgets the cached total after files changed in ways that weren't counted,
+// it is recomputed on the next upload
+func (u *storageUsage) reset() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.known = false
+}
+
+// quotaWriter counts the bytes written through it against DirectoryQuotaBytes, failing
+// with errQuotaExceeded once they no longer fit
+type quotaWriter struct {
+	s        *Server
+	w        io.Writer
+	reserved int64
+}
+
+// Write reserves len(p) bytes before writing them
+func (q *quotaWriter) Write(p []byte) (int, error) {
+	if q.s.DirectoryQuotaBytes > 0 {
+		if err := q.s.reserveQuota(int64(len(p))); err != nil {
+			return 0, err
+		}
+		q.reserved += int64(len(p))
+	}
+	return q.w.Write(p)
+}
+
+// directorySize sums the sizes of all regular files under dir
+func directorySize(dir string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		return nil
+	})
+	return total, err
+}
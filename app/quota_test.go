@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDirectoryQuota(t *testing.T) {
+	s := newTestServer(t)
+	s.DirectoryQuotaBytes = 1 << 20
+
+	// Filling the quota exactly is allowed
+	expectStatus(t, s.handleFiles(fileRequest("POST", "a.bin", strings.Repeat("a", 1<<19))), 201)
+	expectStatus(t, s.handleFiles(fileRequest("POST", "b.bin", strings.Repeat("b", 1<<19))), 201)
+
+	// One more byte isn't
+	expectStatus(t, s.handleFiles(fileRequest("POST", "c.bin", "c")), 507)
+
+	// Deleting a file frees its space again
+	expectStatus(t, s.handleFiles(fileRequest("DELETE", "a.bin", "")), 204)
+	expectStatus(t, s.handleFiles(fileRequest("POST", "c.bin", "c")), 201)
+}
+
+func TestDirectoryQuotaCountsExistingFiles(t *testing.T) {
+	s := newTestServer(t)
+	expectStatus(t, s.handleFiles(fileRequest("POST", "a.bin", strings.Repeat("a", 1000))), 201)
+
+	// The quota is applied to files that were there before it was set
+	s.DirectoryQuotaBytes = 1024
+	expectStatus(t, s.handleFiles(fileRequest("POST", "b.bin", strings.Repeat("b", 25))), 507)
+	expectStatus(t, s.handleFiles(fileRequest("POST", "b.bin", strings.Repeat("b", 24))), 201)
+}
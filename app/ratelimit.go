@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/time/rate"
+)
+
+// redisRateLimitTimeout bounds every Redis round trip so a slow Redis can't stall requests
+const redisRateLimitTimeout = 100 * time.Millisecond
+
+// rateLimiterEvictInterval is how often the limiters of idle clients are dropped
+const rateLimiterEvictInterval = time.Minute
+
+// localRateLimiter is an in-process token bucket per client IP
+type localRateLimiter struct {
+	rps      float64
+	burst    int
+	limiters sync.Map // client IP -> *rate.Limiter
+}
+
+// newLocalRateLimiter creates a limiter allowing rps requests per second with the given burst,
+// evicting the limiters of idle clients for as long as the process runs
+func newLocalRateLimiter(rps float64, burst int) *localRateLimiter {
+	l := &localRateLimiter{rps: rps, burst: burst}
+	go func() {
+		ticker := time.NewTicker(rateLimiterEvictInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			l.evictIdle(now)
+		}
+	}()
+	return l
+}
+
+// evictIdle drops the limiters whose bucket has filled up again. A new limiter starts
+// full, so dropping them changes nothing for their clients
+func (l *localRateLimiter) evictIdle(now time.Time) {
+	l.limiters.Range(func(key, value any) bool {
+		if value.(*rate.Limiter).TokensAt(now) >= float64(l.burst) {
+			l.limiters.Delete(key)
+		}
+		return true
+	})
+}
+
+// allow reports whether the client may make a request now
+func (l *localRateLimiter) allow(ip string) bool {
+	value, _ := l.limiters.LoadOrStore(ip, rate.NewLimiter(rate.Limit(l.rps), l.burst))
+	return value.(*rate.Limiter).Allow()
+}
+
+// tooManyRequests builds the response for a rate limited client
+func tooManyRequests(retryAfter time.Duration) *Response {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return &Response{
+		StatusLine: StatusTooManyRequests,
+		Headers:    map[string]string{"Retry-After": strconv.Itoa(seconds)},
+	}
+}
+
+// RateLimitMiddleware limits each client IP to rps requests per second, allowing bursts of
+// up to rps requests
+func RateLimitMiddleware(rps int) Middleware {
+	limiter := newLocalRateLimiter(float64(rps), rps)
+	retryAfter := time.Second / time.Duration(max(rps, 1))
+
+	return func(next Handler) Handler {
+		return HandlerFunc(func(req *Request) *Response {
+			if !limiter.allow(clientIP(req)) {
+				return tooManyRequests(retryAfter)
+			}
+			return next.Handle(req)
+		})
+	}
+}
+
+// RedisRateLimitMiddleware limits each client IP to rps requests per second with the given
+// burst, sharing the counters through Redis so the limit holds across server instances.
+// It falls back to an in-process limiter while Redis is unreachable.
+func RedisRateLimitMiddleware(redisAddr string, rps float64, burst int) Middleware {
+	client := redis.NewClient(&redis.Options{
+		Addr:        redisAddr,
+		DialTimeout: redisRateLimitTimeout,
+		ReadTimeout: redisRateLimitTimeout,
+	})
+	limiter := &redisRateLimiter{
+		client: client,
+		limit:  float64(burst),
+		window: time.Duration(float64(burst) / rps * float64(time.Second)),
+		local:  newLocalRateLimiter(rps, burst),
+	}
+
+	return func(next Handler) Handler {
+		return HandlerFunc(func(req *Request) *Response {
+			if !limiter.allow(clientIP(req)) {
+				return tooManyRequests(limiter.window)
+			}
+			return next.Handle(req)
+		})
+	}
+}
+
+// redisRateLimiter is a sliding window counter stored in Redis: a client may make
+// limit requests in any window, so on average rps = limit / window
+type redisRateLimiter struct {
+	client   *redis.Client
+	limit    float64
+	window   time.Duration
+	local    *localRateLimiter
+	degraded atomic.Bool
+}
+
+// allow reports whether the client may make a request now
+func (l *redisRateLimiter) allow(ip string) bool {
+	allowed, err := l.allowRedis(ip)
+	if err != nil {
+		if !l.degraded.Swap(true) {
+			fmt.Println("Redis rate limiter unreachable, falling back to in-process limiter:", err)
+		}
+		return l.local.allow(ip)
+	}
+	if l.degraded.Swap(false) {
+		fmt.Println("Redis rate limiter reachable again")
+	}
+	return allowed
+}
+
+// allowRedis counts the request in the current window and weighs in the previous
+// window by how much of it still overlaps the sliding window
+func (l *redisRateLimiter) allowRedis(ip string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisRateLimitTimeout)
+	defer cancel()
+
+	now := time.Now().UnixMilli()
+	windowMillis := max(l.window.Milliseconds(), 1)
+	current := now / windowMillis
+	elapsed := float64(now%windowMillis) / float64(windowMillis)
+
+	currentKey := fmt.Sprintf("ratelimit:%s:%d", ip, current)
+	previousKey := fmt.Sprintf("ratelimit:%s:%d", ip, current-1)
+
+	pipe := l.client.Pipeline()
+	incr := pipe.Incr(ctx, currentKey)
+	pipe.Expire(ctx, currentKey, 2*l.window)
+	previous := pipe.Get(ctx, previousKey)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return false, err
+	}
+
+	previousCount, _ := previous.Int64()
+	estimate := float64(previousCount)*(1-elapsed) + float64(incr.Val())
+	return estimate <= l.limit, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// rateLimitTestHandler answers 200 to every request
+var rateLimitTestHandler = HandlerFunc(func(req *Request) *Response {
+	return &Response{StatusLine: StatusOK, Headers: make(map[string]string)}
+})
+
+// countAllowed sends n requests from the client and counts the ones let through
+func countAllowed(handler Handler, remoteAddr string, n int) int {
+	allowed := 0
+	for range n {
+		if statusCode(handler.Handle(&Request{RemoteAddr: remoteAddr}).StatusLine) == 200 {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func TestRedisRateLimitMiddleware(t *testing.T) {
+	redis := miniredis.RunT(t)
+	handler := RedisRateLimitMiddleware(redis.Addr(), 1, 5)(rateLimitTestHandler)
+
+	if allowed := countAllowed(handler, "192.0.2.1:1000", 10); allowed != 5 {
+		t.Fatalf("allowed %d of 10 requests, want the burst of 5", allowed)
+	}
+	response := handler.Handle(&Request{RemoteAddr: "192.0.2.1:1000"})
+	if statusCode(response.StatusLine) != 429 || response.Headers["Retry-After"] == "" {
+		t.Fatalf("got %q with headers %v, want 429 with Retry-After", response.StatusLine, response.Headers)
+	}
+	// Every client has its own counter
+	if allowed := countAllowed(handler, "192.0.2.2:1000", 1); allowed != 1 {
+		t.Fatal("another client was limited")
+	}
+}
+
+func TestRedisRateLimitMiddlewareSharesTheCounters(t *testing.T) {
+	redis := miniredis.RunT(t)
+	first := RedisRateLimitMiddleware(redis.Addr(), 1, 4)(rateLimitTestHandler)
+	second := RedisRateLimitMiddleware(redis.Addr(), 1, 4)(rateLimitTestHandler)
+
+	allowed := countAllowed(first, "192.0.2.1:1000", 2) + countAllowed(second, "192.0.2.1:1000", 4)
+	if allowed != 4 {
+		t.Fatalf("two instances allowed %d requests together, want 4", allowed)
+	}
+}
+
+func TestRedisRateLimitMiddlewareFallsBack(t *testing.T) {
+	// Nothing listens on the address once the listener is closed
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	handler := RedisRateLimitMiddleware(addr, 1, 3)(rateLimitTestHandler)
+	if allowed := countAllowed(handler, "192.0.2.1:1000", 5); allowed != 3 {
+		t.Fatalf("in-process fallback allowed %d of 5 requests, want 3", allowed)
+	}
+}
+
+func TestLocalRateLimiterEvictsIdleClients(t *testing.T) {
+	limiter := newLocalRateLimiter(10, 2)
+	limiter.allow("192.0.2.1")
+	limiter.allow("192.0.2.1")
+
+	limiter.evictIdle(time.Now())
+	if _, ok := limiter.limiters.Load("192.0.2.1"); !ok {
+		t.Fatal("limiter of a client with an empty bucket evicted")
+	}
+	limiter.evictIdle(time.Now().Add(time.Second))
+	if _, ok := limiter.limiters.Load("192.0.2.1"); ok {
+		t.Fatal("limiter of an idle client kept")
+	}
+}
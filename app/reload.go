@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+)
+
+// RegisterSignalReload calls fn every time the process receives sig, until Context is
+// cancelled. Connections served while fn runs keep the configuration they started with
+func (s *Server) RegisterSignalReload(sig os.Signal, fn func() error) {
+	parent := s.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+
+	go func() {
+		ctx, stop := signal.NotifyContext(parent, sig)
+		for {
+			<-ctx.Done()
+			if parent.Err() != nil {
+				stop()
+				return
+			}
+			// Catch the next signal before releasing this one, so it's never left to the
+			// default action, which for SIGHUP is to exit
+			next, nextStop := signal.NotifyContext(parent, sig)
+			stop()
+			ctx, stop = next, nextStop
+
+			if err := fn(); err != nil {
+				fmt.Println("Error reloading configuration on", sig, "signal:", err)
+			} else {
+				fmt.Println("Reloaded configuration on", sig, "signal")
+			}
+		}
+	}()
+}
+
+// SetTLSCert loads the certificate and key from PEM files and serves new TLS connections
+// with it; connections already established keep the previous one
+func (s *Server) SetTLSCert(certFile, keyFile string) error {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("malformed TLS certificate %s or key %s: %w", certFile, keyFile, err)
+	}
+	s.tlsCertMu.Lock()
+	s.tlsCert = &certificate
+	s.tlsCertFile, s.tlsKeyFile = certFile, keyFile
+	s.tlsCertMu.Unlock()
+	return nil
+}
+
+// getTLSCert returns the certificate set by SetTLSCert, for tls.Config.GetCertificate
+func (s *Server) getTLSCert(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.tlsCertMu.RLock()
+	defer s.tlsCertMu.RUnlock()
+	return s.tlsCert, nil
+}
+
+// reloadConfig is the default SIGHUP handler, reloading the TLS certificate, the mock file
+// and the OpenAPI spec. Whatever fails to reload keeps its previous version
+func (s *Server) reloadConfig() error {
+	var errs []error
+
+	s.tlsCertMu.RLock()
+	certFile, keyFile := s.tlsCertFile, s.tlsKeyFile
+	s.tlsCertMu.RUnlock()
+	if certFile != "" {
+		errs = append(errs, s.SetTLSCert(certFile, keyFile))
+	}
+	if s.mocks != nil && !s.RecordMode {
+		errs = append(errs, s.mocks.reload())
+	}
+	if s.openAPI != nil {
+		errs = append(errs, s.openAPI.reload())
+	}
+	return errors.Join(errs...)
+}
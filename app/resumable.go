@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// partialUploadsDirName is the directory under the served directory holding resumable
+// uploads until they are complete
+const partialUploadsDirName = ".uploads"
+
+// resumableUpload is a file uploaded over several PATCH requests
+type resumableUpload struct {
+	mu        sync.Mutex
+	ID        string
+	Filename  string
+	FullPath  string
+	TempPath  string
+	Total     int64 // -1 until a Content-Range tells it
+	Received  int64
+	UpdatedAt time.Time
+}
+
+// handleStartUpload handles POST /files/?start-upload&filename=name, which creates an
+// empty resumable upload and returns its Upload-Id
+func (s *Server) handleStartUpload(req *Request) *Response {
+	filename := req.Query.Get("filename")
+	if filename == "" {
+		return textResponse(StatusBadRequest, "filename is required")
+	}
+	fullPath, err := s.resolveFilePath(filename)
+	if err != nil {
+		return textResponse(StatusBadRequest, err.Error())
+	}
+	if !s.uploadExtensionAllowed(fullPath) {
+		return &Response{StatusLine: StatusUnsupportedMediaType, Headers: make(map[string]string)}
+	}
+	if _, err := os.Stat(fullPath); err == nil {
+		return &Response{StatusLine: StatusConflict, Headers: make(map[string]string)}
+	}
+
+	upload := &resumableUpload{
+		ID:        newJobID(),
+		Filename:  filename,
+		FullPath:  fullPath,
+		Total:     -1,
+		UpdatedAt: time.Now(),
+	}
+	upload.TempPath = filepath.Join(s.Directory, partialUploadsDirName, upload.ID)
+	if err := os.MkdirAll(filepath.Dir(upload.TempPath), 0755); err != nil {
+		fmt.Println("Error creating uploads directory:", err)
+		return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+	}
+	if err := os.WriteFile(upload.TempPath, nil, 0644); err != nil {
+		fmt.Println("Error creating upload file:", err)
+		return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+	}
+	s.resumableUploads.Store(upload.ID, upload)
+	fmt.Println("Started resumable upload", upload.ID, "of", filename)
+
+	content, _ := json.Marshal(map[string]string{"upload_id": upload.ID})
+	return &Response{
+		StatusLine: StatusCreated,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+			"Upload-Id":    upload.ID,
+		},
+		Body: content,
+	}
+}
+
+// handleUploadChunk handles PATCH /files/?id=<Upload-Id>, appending the body to the upload.
+// The Content-Range must start where the previous chunk ended; on a mismatch the client
+// gets 409 Conflict with a Range header telling how much was received
+func (s *Server) handleUploadChunk(req *Request) *Response {
+	if !req.Query.Has("id") {
+		return s.handleFiles(req)
+	}
+	upload, ok := s.resumableUpload(req.Query.Get("id"))
+	if !ok {
+		return &Response{StatusLine: StatusNotFound, Headers: make(map[string]string)}
+	}
+	start, end, total, err := parseContentRange(req.Headers["content-range"])
+	if err != nil {
+		return textResponse(StatusBadRequest, err.Error())
+	}
+	if end-start+1 != int64(len(req.Body)) {
+		return textResponse(StatusBadRequest, "Content-Range doesn't match the body length")
+	}
+
+	upload.mu.Lock()
+	defer upload.mu.Unlock()
+	if upload.Total >= 0 && total != upload.Total {
+		return textResponse(StatusBadRequest, fmt.Sprintf("total size changed from %d", upload.Total))
+	}
+	if start != upload.Received {
+		return &Response{StatusLine: StatusConflict, Headers: upload.rangeHeaders()}
+	}
+
+	if s.DirectoryQuotaBytes > 0 {
+		ok, err := s.usage.reserve(s.Directory, int64(len(req.Body)), s.DirectoryQuotaBytes)
+		if err != nil {
+			fmt.Println("Error computing directory usage:", err)
+			return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+		}
+		if !ok {
+			return &Response{StatusLine: StatusInsufficientStorage, Headers: make(map[string]string)}
+		}
+	}
+	if err := appendToFile(upload.TempPath, req.Body); err != nil {
+		if s.DirectoryQuotaBytes > 0 {
+			s.usage.release(int64(len(req.Body)))
+		}
+		fmt.Println("Error writing upload chunk:", err)
+		return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+	}
+	upload.Total = total
+	upload.Received = end + 1
+	upload.UpdatedAt = time.Now()
+
+	return &Response{StatusLine: StatusNoContent, Headers: upload.rangeHeaders()}
+}
+
+// handleCompleteUpload handles POST /files/?complete-upload=<Upload-Id>, moving the fully
+// received upload to its final path
+func (s *Server) handleCompleteUpload(req *Request) *Response {
+	upload, ok := s.resumableUpload(req.Query.Get("complete-upload"))
+	if !ok {
+		return &Response{StatusLine: StatusNotFound, Headers: make(map[string]string)}
+	}
+
+	upload.mu.Lock()
+	defer upload.mu.Unlock()
+	if upload.Total < 0 || upload.Received != upload.Total {
+		response := textResponse(StatusConflict, fmt.Sprintf("upload incomplete, received %d bytes", upload.Received))
+		for k, v := range upload.rangeHeaders() {
+			response.Headers[k] = v
+		}
+		return response
+	}
+	if _, err := os.Stat(upload.FullPath); err == nil {
+		return &Response{StatusLine: StatusConflict, Headers: make(map[string]string)}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(upload.FullPath), 0755); err != nil {
+		fmt.Println("Error creating directory:", err)
+		return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+	}
+	if len(s.EncryptionKey) > 0 {
+		if err := encryptFileInPlace(s.EncryptionKey, upload.TempPath); err != nil {
+			fmt.Println("Error encrypting upload:", err)
+			return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+		}
+		s.usage.reset()
+	}
+	if err := os.Rename(upload.TempPath, upload.FullPath); err != nil {
+		fmt.Println("Error completing upload:", err)
+		return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+	}
+	s.resumableUploads.Delete(upload.ID)
+	s.invalidateDirs(upload.FullPath)
+	s.audit(req, "file.create", StatusCreated)
+	fmt.Println("Completed resumable upload", upload.ID, "of", upload.Filename)
+
+	return &Response{StatusLine: StatusCreated, Headers: make(map[string]string)}
+}
+
+// resumableUpload looks an upload up by ID
+func (s *Server) resumableUpload(id string) (*resumableUpload, bool) {
+	value, ok := s.resumableUploads.Load(id)
+	if !ok {
+		return nil, false
+	}
+	return value.(*resumableUpload), true
+}
+
+// rangeHeaders tells the client how much of the upload was received
+func (u *resumableUpload) rangeHeaders() map[string]string {
+	headers := map[string]string{"Upload-Offset": strconv.FormatInt(u.Received, 10)}
+	if u.Received > 0 {
+		headers["Range"] = fmt.Sprintf("bytes=0-%d", u.Received-1)
+	}
+	return headers
+}
+
+// parseContentRange parses "bytes=START-END/TOTAL", also accepting the standard
+// "bytes START-END/TOTAL"
+func parseContentRange(header string) (start, end, total int64, err error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		spec, ok = strings.CutPrefix(header, "bytes ")
+	}
+	rangeSpec, totalSpec, hasTotal := strings.Cut(spec, "/")
+	startSpec, endSpec, hasEnd := strings.Cut(rangeSpec, "-")
+	if !ok || !hasTotal || !hasEnd {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range %q, expected bytes=START-END/TOTAL", header)
+	}
+
+	start, err1 := strconv.ParseInt(startSpec, 10, 64)
+	end, err2 := strconv.ParseInt(endSpec, 10, 64)
+	total, err3 := strconv.ParseInt(totalSpec, 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil || start < 0 || end < start || end >= total {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range %q, expected bytes=START-END/TOTAL", header)
+	}
+	return start, end, total, nil
+}
+
+// appendToFile appends data to the end of an existing file
+func appendToFile(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// encryptFileInPlace replaces the file's content with its encrypted form
+func encryptFileInPlace(key []byte, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	encrypted, err := encryptFile(key, content)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, encrypted, 0644)
+}
+
+// expireUploads deletes the resumable uploads that received nothing for UploadTTL
+func (s *Server) expireUploads() {
+	ctx := s.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ticker := time.NewTicker(min(s.UploadTTL, time.Minute))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.resumableUploads.Range(func(key, value any) bool {
+			upload := value.(*resumableUpload)
+			upload.mu.Lock()
+			defer upload.mu.Unlock()
+			if time.Since(upload.UpdatedAt) < s.UploadTTL {
+				return true
+			}
+			s.resumableUploads.Delete(key)
+			if err := os.Remove(upload.TempPath); err != nil && !os.IsNotExist(err) {
+				fmt.Println("Error removing expired upload:", err)
+			}
+			if s.DirectoryQuotaBytes > 0 {
+				s.usage.release(upload.Received)
+			}
+			fmt.Println("Expired resumable upload", upload.ID, "of", upload.Filename)
+			return true
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+)
+
+// interruptedReader fails the first reads with EINTR, as a read cut short by a signal does
+type interruptedReader struct {
+	r           io.Reader
+	interrupts  int
+	interrupted int
+}
+
+func (r *interruptedReader) Read(p []byte) (int, error) {
+	if r.interrupted < r.interrupts {
+		r.interrupted++
+		return 0, syscall.EINTR
+	}
+	return r.r.Read(p)
+}
+
+func TestReadWithRetryRetriesInterruptedReads(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go func() {
+		io.WriteString(client, "hello ")
+		io.WriteString(client, "world")
+	}()
+
+	before := readRetries.Load()
+	buf := make([]byte, len("hello world"))
+	n, err := readWithRetry(&interruptedReader{r: server, interrupts: 2}, buf, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "hello world" {
+		t.Fatalf("got %q, want %q", buf[:n], "hello world")
+	}
+	if retried := readRetries.Load() - before; retried != 2 {
+		t.Fatalf("counted %d retries, want 2", retried)
+	}
+}
+
+func TestReadWithRetryGivesUpAfterMaxRetries(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	buf := make([]byte, 5)
+	_, err := readWithRetry(&interruptedReader{r: server, interrupts: 4}, buf, 3)
+	if !errors.Is(err, syscall.EINTR) {
+		t.Fatalf("got %v, want EINTR", err)
+	}
+}
+
+func TestReadWithRetryReportsShortBody(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		io.WriteString(client, "abc")
+		client.Close()
+	}()
+
+	buf := make([]byte, 5)
+	n, err := readWithRetry(server, buf, 3)
+	if n != 3 || err != io.ErrUnexpectedEOF {
+		t.Fatalf("got %d, %v, want 3, %v", n, err, io.ErrUnexpectedEOF)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RewriteRule rewrites request paths matching Pattern to Replacement, which may use $1-style back-references
+type RewriteRule struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+	Methods     []string // empty applies to every method
+	Last        bool     // stop processing further rules after this one matches
+	Redirect    bool     // send the client to the new URL instead of rewriting internally
+	Permanent   bool     // use 301 instead of 302 for redirects
+}
+
+// rewriteRuleJSON is the format of a rule in a rules file
+type rewriteRuleJSON struct {
+	Pattern     string   `json:"pattern"`
+	Replacement string   `json:"replacement"`
+	Methods     []string `json:"methods"`
+	Last        bool     `json:"last"`
+	Redirect    bool     `json:"redirect"`
+	Permanent   bool     `json:"permanent"`
+}
+
+// LoadRewriteRules reads rewrite rules from a JSON file
+func LoadRewriteRules(path string) ([]RewriteRule, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rewrite rules: %w", err)
+	}
+
+	var raw []rewriteRuleJSON
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return nil, fmt.Errorf("malformed rewrite rules %s: %w", path, err)
+	}
+
+	rules := make([]RewriteRule, 0, len(raw))
+	for _, r := range raw {
+		pattern, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("malformed rewrite rules %s: %w", path, err)
+		}
+		rules = append(rules, RewriteRule{
+			Pattern:     pattern,
+			Replacement: r.Replacement,
+			Methods:     r.Methods,
+			Last:        r.Last,
+			Redirect:    r.Redirect,
+			Permanent:   r.Permanent,
+		})
+	}
+	return rules, nil
+}
+
+// rewriteRules is the current rule set, optionally reloaded from a file when it changes
+type rewriteRules struct {
+	mu      sync.RWMutex
+	rules   []RewriteRule
+	path    string
+	modTime time.Time
+}
+
+// URLRewriteMiddleware rewrites request paths before routing, or redirects the client
+func URLRewriteMiddleware(rules []RewriteRule) Middleware {
+	set := &rewriteRules{rules: rules}
+	return set.middleware
+}
+
+// URLRewriteFileMiddleware is URLRewriteMiddleware with rules loaded from a JSON file,
+// reloaded whenever the file changes
+func URLRewriteFileMiddleware(path string) (Middleware, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rewrite rules: %w", err)
+	}
+	rules, err := LoadRewriteRules(path)
+	if err != nil {
+		return nil, err
+	}
+	set := &rewriteRules{rules: rules, path: path, modTime: info.ModTime()}
+	return set.middleware, nil
+}
+
+// current returns the rules, picking up changes to the rules file first
+func (set *rewriteRules) current() []RewriteRule {
+	set.mu.RLock()
+	rules, path, modTime := set.rules, set.path, set.modTime
+	set.mu.RUnlock()
+
+	if path == "" {
+		return rules
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.ModTime().Equal(modTime) {
+		return rules
+	}
+
+	reloaded, err := LoadRewriteRules(path)
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	set.modTime = info.ModTime()
+	if err != nil {
+		fmt.Println("Error reloading rewrite rules, keeping the previous ones:", err)
+		return set.rules
+	}
+	fmt.Println("Reloaded rewrite rules:", path)
+	set.rules = reloaded
+	return set.rules
+}
+
+// middleware applies the rules to every request
+func (set *rewriteRules) middleware(next Handler) Handler {
+	return HandlerFunc(func(req *Request) *Response {
+		for _, rule := range set.current() {
+			if !rule.appliesTo(req) {
+				continue
+			}
+
+			target := rule.Pattern.ReplaceAllString(req.Path, rule.Replacement)
+			if rule.Redirect {
+				statusLine := StatusFound
+				if rule.Permanent {
+					statusLine = StatusMovedPermanently
+				}
+				if !strings.Contains(target, "?") && req.RawQuery != "" {
+					target += "?" + req.RawQuery
+				}
+				return &Response{
+					StatusLine: statusLine,
+					Headers:    map[string]string{"Location": target},
+				}
+			}
+
+			// A replacement may carry its own query string
+			path, rawQuery, hasQuery := strings.Cut(target, "?")
+			req.Path = path
+			if hasQuery {
+				req.RawQuery = rawQuery
+				req.Query, _ = url.ParseQuery(rawQuery)
+			}
+			if rule.Last {
+				break
+			}
+		}
+		return next.Handle(req)
+	})
+}
+
+// appliesTo reports whether the rule matches the request's method and path
+func (rule RewriteRule) appliesTo(req *Request) bool {
+	if len(rule.Methods) > 0 {
+		allowed := false
+		for _, method := range rule.Methods {
+			if strings.EqualFold(method, req.Method) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return rule.Pattern.MatchString(req.Path)
+}
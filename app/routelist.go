@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// routeListEntry describes a registered route in GET /__routes
+type routeListEntry struct {
+	Method     string   `json:"method"`
+	Pattern    string   `json:"pattern"`
+	Middleware []string `json:"middleware"`
+}
+
+// handleRouteList handles GET /__routes, listing the registered routes sorted by pattern
+// when EnableRouteList is set
+func (s *Server) handleRouteList(req *Request) *Response {
+	if !s.EnableRouteList {
+		return &Response{StatusLine: StatusNotFound, Headers: make(map[string]string)}
+	}
+
+	routes := make([]routeListEntry, 0, len(s.Router.routes))
+	for _, route := range s.Router.routes {
+		method := route.Method
+		if method == "" {
+			method = "*"
+		}
+		middleware := route.middleware
+		if middleware == nil {
+			middleware = []string{}
+		}
+		routes = append(routes, routeListEntry{Method: method, Pattern: route.Pattern, Middleware: middleware})
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		if routes[i].Pattern != routes[j].Pattern {
+			return routes[i].Pattern < routes[j].Pattern
+		}
+		return routes[i].Method < routes[j].Method
+	})
+
+	content, _ := json.Marshal(routes)
+	return &Response{
+		StatusLine: StatusOK,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       content,
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRouteList(t *testing.T) {
+	s := newTestServer(t)
+	s.EnableRouteList = true
+	ok := func(req *Request) *Response { return &Response{StatusLine: StatusOK} }
+	passThrough := func(next Handler) Handler { return next }
+
+	s.Router.GET("/test/c", ok)
+	s.Router.Register("POST", "/test/a", HandlerFunc(ok)).Use(WithName("auth", passThrough), WithName("log", passThrough))
+	s.Router.GET("/test/a", ok)
+	s.Router.GetRegex("/test/b/{id:[0-9]+}", ok)
+	s.Router.Register("", "/test/d/*", HandlerFunc(ok))
+	addr := startTestServer(t, s)
+
+	response, body := roundTrip(t, addr, "GET /__routes HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	if response.StatusCode != 200 {
+		t.Fatalf("got status %d", response.StatusCode)
+	}
+	var routes []routeListEntry
+	if err := json.Unmarshal(body, &routes); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.IsSortedFunc(routes, func(a, b routeListEntry) int {
+		return cmp.Or(strings.Compare(a.Pattern, b.Pattern), strings.Compare(a.Method, b.Method))
+	}) {
+		t.Errorf("routes aren't sorted: %+v", routes)
+	}
+
+	var registered []routeListEntry
+	for _, route := range routes {
+		if strings.HasPrefix(route.Pattern, "/test/") {
+			registered = append(registered, route)
+		}
+	}
+	want := []routeListEntry{
+		{Method: "GET", Pattern: "/test/a", Middleware: []string{}},
+		{Method: "POST", Pattern: "/test/a", Middleware: []string{"auth", "log"}},
+		{Method: "GET", Pattern: "/test/b/{id:[0-9]+}", Middleware: []string{}},
+		{Method: "GET", Pattern: "/test/c", Middleware: []string{}},
+		{Method: "*", Pattern: "/test/d/*", Middleware: []string{}},
+	}
+	if len(registered) != len(want) {
+		t.Fatalf("got routes %+v, want %+v", registered, want)
+	}
+	for i := range want {
+		if registered[i].Method != want[i].Method || registered[i].Pattern != want[i].Pattern || !slices.Equal(registered[i].Middleware, want[i].Middleware) {
+			t.Errorf("route %d: got %+v, want %+v", i, registered[i], want[i])
+		}
+	}
+}
+
+func TestRouteListDisabled(t *testing.T) {
+	addr := startTestServer(t, newTestServer(t))
+	if response, _ := roundTrip(t, addr, "GET /__routes HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"); response.StatusCode != 404 {
+		t.Errorf("got status %d, want 404", response.StatusCode)
+	}
+}
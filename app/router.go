@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Route is a single entry in the Router
+type Route struct {
+	Method      string // empty matches any method
+	Pattern     string // a trailing "*" matches any suffix, see Register for named segments
+	Handler     Handler
+	Handler2    Handler2
+	ConnHandler ConnHandler
+	Query       map[string]string // query parameters that must be present with these values
+
+	regex      *regexp.Regexp
+	cache      *routeCache
+	middleware []string // names of the Named middleware wrapping the handler, outermost first
+}
+
+// Named is implemented by the handlers a middleware wraps around the next one, giving the
+// middleware's name in the route list
+type Named interface {
+	Name() string
+}
+
+// namedHandler is a handler produced by a middleware made with WithName
+type namedHandler struct {
+	Handler
+	name string
+}
+
+// Name returns the name of the middleware that produced the handler
+func (h namedHandler) Name() string {
+	return h.name
+}
+
+// WithName gives a middleware a name, reported through Named by the handlers it produces
+func WithName(name string, m Middleware) Middleware {
+	return func(next Handler) Handler {
+		return namedHandler{Handler: m(next), name: name}
+	}
+}
+
+// Use wraps the route's handler in middleware, the first one outermost
+func (route *Route) Use(middlewares ...Middleware) *Route {
+	if route.Handler == nil {
+		return route
+	}
+	names := make([]string, 0, len(middlewares))
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		route.Handler = middlewares[i](route.Handler)
+		if named, ok := route.Handler.(Named); ok {
+			names = append([]string{named.Name()}, names...)
+		}
+	}
+	route.middleware = append(names, route.middleware...)
+	return route
+}
+
+// Router maps request methods and paths to handlers
+type Router struct {
+	routes []*Route
+}
+
+// NewRouter creates an empty router
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Register adds a handler for the method and path pattern. The pattern may have named
+// segments, whose values are put in req.PathParams: ":name" matches a single segment,
+// "*name" the rest of the path and "{name:regex}" what the regex matches
+func (r *Router) Register(method, pattern string, h Handler) *Route {
+	route := &Route{Method: method, Pattern: pattern, Handler: h, regex: compileNamedPattern(pattern)}
+	r.routes = append(r.routes, route)
+	return route
+}
+
+// RegisterStreaming adds a streaming handler for the method and path pattern, which may
+// have named segments like in Register
+func (r *Router) RegisterStreaming(method, pattern string, h Handler2) *Route {
+	route := &Route{Method: method, Pattern: pattern, Handler2: h, regex: compileNamedPattern(pattern)}
+	r.routes = append(r.routes, route)
+	return route
+}
+
+// RegisterConn adds a handler taking over the connection for the method and path pattern,
+// which may have named segments like in Register
+func (r *Router) RegisterConn(method, pattern string, h ConnHandler) *Route {
+	route := &Route{Method: method, Pattern: pattern, ConnHandler: h, regex: compileNamedPattern(pattern)}
+	r.routes = append(r.routes, route)
+	return route
+}
+
+// GET adds a handler for GET requests to the path pattern
+func (r *Router) GET(pattern string, h HandlerFunc) *Route {
+	return r.Register("GET", pattern, h)
+}
+
+// GetRegex adds a handler for GET requests to a pattern with {name:regex} segments,
+// panicking if the pattern is invalid
+func (r *Router) GetRegex(pattern string, h HandlerFunc) *Route {
+	regex := compileRoutePattern(pattern)
+	route := r.GET(pattern, h)
+	route.regex = regex
+	return route
+}
+
+// GetWithQuery adds a handler for GET requests to the path pattern that only matches when
+// the query has all of the given parameters with the given values
+func (r *Router) GetWithQuery(pattern string, queryParams map[string]string, h HandlerFunc) *Route {
+	route := r.GET(pattern, h)
+	route.Query = queryParams
+	return route
+}
+
+// Match returns the first route matching the request, or nil, filling in
+// req.PathParams from the route's named segments. Routes requiring more query
+// parameters take precedence
+func (r *Router) Match(req *Request) *Route {
+	var best *Route
+	for _, route := range r.routes {
+		if route.matches(req) && (best == nil || len(route.Query) > len(best.Query)) {
+			best = route
+		}
+	}
+	if best != nil && best.regex != nil {
+		req.PathParams = best.params(req.Path)
+	}
+	return best
+}
+
+// namedSegment matches the ":name" and "*name" segments of a pattern
+var namedSegment = regexp.MustCompile(`^[:*][A-Za-z_][A-Za-z0-9_]*$`)
+
+// compileNamedPattern compiles a pattern with named segments, returning nil for plain
+// patterns, which are matched literally or by prefix
+func compileNamedPattern(pattern string) *regexp.Regexp {
+	segments := strings.Split(pattern, "/")
+	named := strings.Contains(pattern, "{")
+	for i, segment := range segments {
+		if !namedSegment.MatchString(segment) {
+			continue
+		}
+		named = true
+		if segment[0] == ':' {
+			segments[i] = "{" + segment[1:] + "}"
+		} else {
+			segments[i] = "{" + segment[1:] + ":.*}"
+		}
+	}
+	if !named {
+		return nil
+	}
+	return compileRoutePattern(strings.Join(segments, "/"))
+}
+
+// compileRoutePattern turns {name:regex} segments of a pattern into named capture groups
+func compileRoutePattern(pattern string) *regexp.Regexp {
+	var expr strings.Builder
+	expr.WriteString("^")
+	names := make(map[string]bool)
+
+	for rest := pattern; rest != ""; {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			expr.WriteString(regexp.QuoteMeta(rest))
+			break
+		}
+		expr.WriteString(regexp.QuoteMeta(rest[:start]))
+
+		// Find the matching closing brace, the regex itself may contain braces
+		depth, end := 0, -1
+		for i := start; i < len(rest) && end < 0; i++ {
+			switch rest[i] {
+			case '{':
+				depth++
+			case '}':
+				depth--
+				if depth == 0 {
+					end = i
+				}
+			}
+		}
+		if end < 0 {
+			panic(fmt.Sprintf("route pattern %q: unclosed {", pattern))
+		}
+
+		name, segment, ok := strings.Cut(rest[start+1:end], ":")
+		if !ok {
+			segment = "[^/]+"
+		}
+		if names[name] {
+			panic(fmt.Sprintf("route pattern %q: duplicate group name %q", pattern, name))
+		}
+		names[name] = true
+		if _, err := regexp.Compile(segment); err != nil {
+			panic(fmt.Sprintf("route pattern %q: %v", pattern, err))
+		}
+		fmt.Fprintf(&expr, "(?P<%s>%s)", name, segment)
+		rest = rest[end+1:]
+	}
+
+	expr.WriteString("$")
+	return regexp.MustCompile(expr.String())
+}
+
+// params extracts the named segments of the path
+func (route *Route) params(path string) map[string]string {
+	match := route.regex.FindStringSubmatch(path)
+	params := make(map[string]string)
+	for i, name := range route.regex.SubexpNames() {
+		if name != "" && i < len(match) {
+			params[name] = match[i]
+		}
+	}
+	return params
+}
+
+// AllowedMethods returns the methods the routes matching the request's path accept, in
+// the order of supportedMethods, or nil if no route matches the path
+func (r *Router) AllowedMethods(req *Request) []string {
+	allowed := make(map[string]bool)
+	for _, route := range r.routes {
+		if !route.matchesPath(req) {
+			continue
+		}
+		switch route.Method {
+		case "":
+			return supportedMethods
+		case "GET":
+			allowed["HEAD"] = true
+		}
+		allowed[route.Method] = true
+		allowed["OPTIONS"] = true
+	}
+
+	var methods []string
+	for _, method := range supportedMethods {
+		if allowed[method] {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+// matches reports whether the route applies to the request, GET routes also answer HEAD
+func (route *Route) matches(req *Request) bool {
+	if route.Method != "" && route.Method != req.Method && !(route.Method == "GET" && req.Method == "HEAD") {
+		return false
+	}
+	return route.matchesPath(req)
+}
+
+// matchesPath reports whether the route applies to the request's path and query, whatever its method
+func (route *Route) matchesPath(req *Request) bool {
+	for name, value := range route.Query {
+		if !req.Query.Has(name) || req.Query.Get(name) != value {
+			return false
+		}
+	}
+	if route.regex != nil {
+		return route.regex.MatchString(req.Path)
+	}
+	if prefix, ok := strings.CutSuffix(route.Pattern, "*"); ok {
+		return strings.HasPrefix(req.Path, prefix)
+	}
+	return req.Path == route.Pattern
+}
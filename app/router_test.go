@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestGetWithQuery(t *testing.T) {
+	router := NewRouter()
+	router.GET("/api", func(req *Request) *Response {
+		return &Response{StatusLine: StatusOK, Body: []byte("default")}
+	})
+	router.GetWithQuery("/api", map[string]string{"version": "1"}, func(req *Request) *Response {
+		return &Response{StatusLine: StatusOK, Body: []byte("v1")}
+	})
+	router.GetWithQuery("/api", map[string]string{"version": "2"}, func(req *Request) *Response {
+		return &Response{StatusLine: StatusOK, Body: []byte("v2")}
+	})
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"version=1", "v1"},
+		{"version=2", "v2"},
+		{"version=2&other=x", "v2"},
+		{"version=3", "default"},
+		{"", "default"},
+	}
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		for _, method := range []string{"GET", "HEAD"} {
+			route := router.Match(&Request{Method: method, Path: "/api", Query: query})
+			if route == nil {
+				t.Fatalf("%s /api?%s: no route matched", method, tt.query)
+			}
+			if body := string(route.Handler.Handle(&Request{}).Body); body != tt.want {
+				t.Errorf("%s /api?%s: got %q, want %q", method, tt.query, body, tt.want)
+			}
+		}
+	}
+
+	if route := router.Match(&Request{Method: "POST", Path: "/api", Query: url.Values{"version": {"1"}}}); route != nil {
+		t.Errorf("POST matched the GET route %q", route.Pattern)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// searchMatch is a single line matching a search, streamed as one NDJSON line
+type searchMatch struct {
+	Filename   string `json:"filename"`
+	LineNumber int    `json:"line_number"`
+	Line       string `json:"line"`
+}
+
+// searchError reports a file whose search ended early, streamed in place of a match
+type searchError struct {
+	Filename   string `json:"filename"`
+	LineNumber int    `json:"line_number"`
+	Error      string `json:"error"`
+}
+
+// maxSearchLineBytes is the longest line a search reads, longer ones end the file's search
+const maxSearchLineBytes = 1 << 20
+
+// errSearchDone stops the directory walk once enough results were sent
+var errSearchDone = errors.New("search done")
+
+// handleFilesRoot handles GET /files/, which searches file contents with ?search=pattern
+// and lists the directory with ?list=1
+func (s *Server) handleFilesRoot(req *Request, w ResponseWriter) {
+	if s.Directory == "" {
+		fmt.Println("Directory not specified for /files endpoint")
+		w.SetStatus(400)
+		return
+	}
+
+	if req.Query.Has("search") {
+		s.handleFileSearch(req, w)
+		return
+	}
+	if req.Query.Has("token") {
+		s.handlePresignedDownload(req, w)
+		return
+	}
+	if req.Query.Get("list") == "1" {
+		s.handleFileList(req, w)
+		return
+	}
+
+	w.SetStatus(404)
+}
+
+// handleFileSearch streams the lines of all files matching the search regex as NDJSON
+func (s *Server) handleFileSearch(req *Request, w ResponseWriter) {
+	pattern, err := regexp.Compile(req.Query.Get("search"))
+	if err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
+
+	ctx := context.Background()
+	if s.SearchTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.SearchTimeout)
+		defer cancel()
+	}
+
+	w.SetHeader("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(w)
+	results := 0
+
+	err = filepath.WalkDir(s.Directory, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		// Versions, partial uploads and the write-ahead log aren't the clients' to read
+		filename, _ := filepath.Rel(s.Directory, path)
+		if filename != "." && isInternalPath(filename) {
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		content, err := s.openSearchFile(path)
+		if err != nil {
+			return nil
+		}
+		defer content.Close()
+
+		scanner := bufio.NewScanner(content)
+		scanner.Buffer(nil, maxSearchLineBytes)
+		lineNumber := 1
+		for ; scanner.Scan(); lineNumber++ {
+			if !pattern.Match(scanner.Bytes()) {
+				continue
+			}
+
+			// Send every match right away so the client sees partial results
+			match := searchMatch{Filename: filepath.ToSlash(filename), LineNumber: lineNumber, Line: scanner.Text()}
+			if err := encoder.Encode(match); err != nil {
+				return err
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+
+			results++
+			if s.SearchMaxResults > 0 && results >= s.SearchMaxResults {
+				return errSearchDone
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+		}
+
+		// Let the client know the file's matches are incomplete, and go on with the next one
+		if err := scanner.Err(); err != nil {
+			fmt.Printf("Error searching %s: %v\n", path, err)
+			if err := encoder.Encode(searchError{Filename: filepath.ToSlash(filename), LineNumber: lineNumber, Error: err.Error()}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	switch err {
+	case nil, errSearchDone:
+	case context.DeadlineExceeded:
+		fmt.Println("Search timed out after", s.SearchTimeout)
+	default:
+		fmt.Println("Error searching files:", err)
+	}
+}
+
+// openSearchFile opens a file for searching, decrypted when it is encrypted at rest
+func (s *Server) openSearchFile(path string) (io.ReadCloser, error) {
+	if len(s.EncryptionKey) == 0 {
+		return os.Open(path)
+	}
+	content, err := s.readFileContent(path)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// searchLines runs a search and returns the NDJSON lines streamed back
+func searchLines(t *testing.T, addr, pattern string) []map[string]any {
+	t.Helper()
+	response, body := roundTrip(t, addr, "GET /files/?search="+url.QueryEscape(pattern)+" HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	if response.StatusCode != 200 {
+		t.Fatalf("got status %d", response.StatusCode)
+	}
+	var lines []map[string]any
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(nil, 2*maxSearchLineBytes)
+	for scanner.Scan() {
+		var line map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("malformed line %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return lines
+}
+
+func TestSearchSkipsInternalFiles(t *testing.T) {
+	s := newTestServer(t)
+	files := map[string]string{
+		"a.txt":                    "needle in a.txt",
+		"sub/b.txt":                "needle in b.txt",
+		".versions/a.txt/1":        "needle in an old version",
+		".uploads/part":            "needle in a partial upload",
+		".gzcache/a.txt.gz":        "needle in a sidecar",
+		".wal":                     "needle in the write-ahead log",
+		"sub/.versions-not-hidden": "needle in a file that only looks internal",
+	}
+	for name, content := range files {
+		path := filepath.Join(s.Directory, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addr := startTestServer(t, s)
+
+	var found []string
+	for _, line := range searchLines(t, addr, "needle") {
+		found = append(found, line["filename"].(string))
+	}
+	want := "a.txt sub/.versions-not-hidden sub/b.txt"
+	if got := strings.Join(found, " "); got != want {
+		t.Errorf("got matches in %q, want %q", got, want)
+	}
+}
+
+func TestSearchDecryptsFiles(t *testing.T) {
+	s := newTestServer(t)
+	s.EncryptionKey = bytes.Repeat([]byte{7}, 32)
+	expectStatus(t, s.handleFiles(fileRequest("POST", "secret.txt", "first line\nthe needle\n")), 201)
+	addr := startTestServer(t, s)
+
+	lines := searchLines(t, addr, "needle")
+	if len(lines) != 1 || lines[0]["line"] != "the needle" || lines[0]["line_number"] != 2.0 {
+		t.Errorf("got %v, want the decrypted line", lines)
+	}
+}
+
+func TestSearchReportsLongLines(t *testing.T) {
+	s := newTestServer(t)
+	content := "needle before\n" + strings.Repeat("x", maxSearchLineBytes+1) + "\nneedle after\n"
+	if err := os.WriteFile(filepath.Join(s.Directory, "long.txt"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Lines longer than the default 64 KiB scanner buffer are still searched
+	if err := os.WriteFile(filepath.Join(s.Directory, "medium.txt"), []byte(strings.Repeat("x", 100<<10)+"needle\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	addr := startTestServer(t, s)
+
+	lines := searchLines(t, addr, "needle")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want 3: %v", len(lines), lines)
+	}
+	if lines[0]["filename"] != "long.txt" || lines[0]["line_number"] != 1.0 {
+		t.Errorf("got first line %v", lines[0])
+	}
+	if lines[1]["filename"] != "long.txt" || lines[1]["line_number"] != 2.0 || lines[1]["error"] == nil {
+		t.Errorf("got %v, want an error for the long line", lines[1])
+	}
+	if lines[2]["filename"] != "medium.txt" {
+		t.Errorf("got %v, want the match in medium.txt", lines[2])
+	}
+}
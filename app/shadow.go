@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	shadowRequests = metrics.counter("shadow_requests_total")
+	shadowErrors   = metrics.counter("shadow_errors_total")
+)
+
+// ShadowMiddleware mirrors every request to a shadow backend after the main handler
+// has responded, discarding whatever the shadow backend answers
+func ShadowMiddleware(shadowURL string, timeout time.Duration) Middleware {
+	target, err := url.Parse(shadowURL)
+	if err != nil || target.Host == "" {
+		panic(fmt.Sprintf("invalid shadow URL %q", shadowURL))
+	}
+	address := target.Host
+	if target.Port() == "" {
+		address = net.JoinHostPort(target.Hostname(), "80")
+	}
+
+	return func(next Handler) Handler {
+		return HandlerFunc(func(req *Request) *Response {
+			response := next.Handle(req)
+
+			// Serialize the request now, the goroutine must not touch req
+			raw := rawRequest(req, target.Host)
+			go sendShadowRequest(address, raw, timeout)
+
+			return response
+		})
+	}
+}
+
+// rawRequest re-constructs the request as it would be sent over the wire
+func rawRequest(req *Request, host string) []byte {
+	lines := make([]string, 0, 4+len(req.Headers))
+	lines = append(lines, fmt.Sprintf("%s %s HTTP/1.1", req.Method, req.RequestURI()))
+	lines = append(lines, "Host: "+host)
+	for k, v := range req.Headers {
+		switch k {
+		case "host", "connection", "content-length":
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", k, v))
+	}
+	lines = append(lines, "Connection: close")
+	if len(req.Body) > 0 {
+		lines = append(lines, "Content-Length: "+strconv.Itoa(len(req.Body)))
+	}
+	lines = append(lines, "", "")
+
+	return append([]byte(strings.Join(lines, "\r\n")), req.Body...)
+}
+
+// sendShadowRequest sends a serialized request to the shadow backend and drains the response
+func sendShadowRequest(address string, raw []byte, timeout time.Duration) {
+	shadowRequests.Add(1)
+
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		shadowErrors.Add(1)
+		fmt.Println("Error connecting to shadow backend:", err)
+		return
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		shadowErrors.Add(1)
+		fmt.Println("Error setting shadow deadline:", err)
+		return
+	}
+	if _, err := conn.Write(raw); err != nil {
+		shadowErrors.Add(1)
+		fmt.Println("Error sending shadow request:", err)
+		return
+	}
+	if _, err := io.Copy(io.Discard, conn); err != nil {
+		shadowErrors.Add(1)
+		fmt.Println("Error reading shadow response:", err)
+	}
+}
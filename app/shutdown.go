@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// errServerNotStarted is returned by Shutdown before Start listens
+var errServerNotStarted = errors.New("server not started")
+
+// Shutdown stops accepting connections, closes the idle ones and waits for the requests in
+// flight to finish, or for ctx to be done. Start returns nil once every connection is closed
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.listenerMu.Lock()
+	listener := s.listener
+	s.listenerMu.Unlock()
+	if listener == nil {
+		return errServerNotStarted
+	}
+
+	s.draining.Store(true)
+	if err := listener.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	s.closeIdleConnections()
+
+	done := make(chan struct{})
+	go func() {
+		s.connections.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// setListener records the listener Start accepts connections from, for Shutdown
+func (s *Server) setListener(listener net.Listener) {
+	s.listenerMu.Lock()
+	defer s.listenerMu.Unlock()
+	s.listener = listener
+}
+
+// trackConnection registers a connection for Shutdown, returning the flag telling whether
+// it is waiting for the next request and a function unregistering it
+func (s *Server) trackConnection(conn net.Conn) (*atomic.Bool, func()) {
+	idle := &atomic.Bool{}
+	s.activeConns.Store(conn, idle)
+	return idle, func() {
+		s.activeConns.Delete(conn)
+	}
+}
+
+// closeIdleConnections wakes the connections waiting for a request up, so they close
+// instead of waiting for the read deadline
+func (s *Server) closeIdleConnections() {
+	s.activeConns.Range(func(key, value any) bool {
+		if value.(*atomic.Bool).Load() {
+			key.(net.Conn).SetReadDeadline(time.Now())
+		}
+		return true
+	})
+}
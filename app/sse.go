@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSSEDemoEvents caps the ?count= of the /events/ demo stream
+const maxSSEDemoEvents = 3600
+
+// SSEEvent is a server-sent event. Only Data is required
+type SSEEvent struct {
+	ID    string
+	Event string
+	Data  string
+	Retry time.Duration // how long the client waits before reconnecting, zero leaves it unchanged
+}
+
+// String formats the event for a text/event-stream body, a data line per line of Data
+func (e SSEEvent) String() string {
+	var b strings.Builder
+	if e.ID != "" {
+		fmt.Fprintf(&b, "id: %s\n", e.ID)
+	}
+	if e.Event != "" {
+		fmt.Fprintf(&b, "event: %s\n", e.Event)
+	}
+	if e.Retry > 0 {
+		fmt.Fprintf(&b, "retry: %d\n", e.Retry.Milliseconds())
+	}
+	for _, line := range strings.Split(e.Data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// ServeSSE streams the events to the client as they arrive, flushing each one, until the
+// channel is closed or the client goes away
+func ServeSSE(w ResponseWriter, events <-chan SSEEvent) error {
+	w.SetHeader("Content-Type", "text/event-stream")
+	w.SetHeader("Cache-Control", "no-cache")
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	for event := range events {
+		if _, err := w.Write([]byte(event.String())); err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleEvents handles GET /events/, a demo stream sending a tick event every second,
+// ?count= times, 10 by default. A reconnecting client resumes after its Last-Event-ID
+func (s *Server) handleEvents(req *Request, w ResponseWriter) {
+	count := 10
+	if value := req.Query.Get("count"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxSSEDemoEvents {
+			writeResponse(w, textResponse(StatusBadRequest, fmt.Sprintf("count must be between 1 and %d", maxSSEDemoEvents)))
+			return
+		}
+		count = n
+	}
+	first := 1
+	if lastID, err := strconv.Atoi(req.Headers["last-event-id"]); err == nil && lastID >= 0 {
+		first = lastID + 1
+	}
+
+	events := make(chan SSEEvent)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(events)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for i := first; i <= count; i++ {
+			event := SSEEvent{ID: strconv.Itoa(i), Event: "tick", Data: time.Now().UTC().Format(time.RFC3339)}
+			select {
+			case events <- event:
+			case <-done:
+				return
+			}
+			if i < count {
+				select {
+				case <-ticker.C:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+
+	if err := ServeSSE(w, events); err != nil && !isConnReset(err) {
+		fmt.Println("Error streaming events:", err)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// bufferedConn is a connection whose reads first drain what a bufio.Reader already buffered
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// Read reads through the buffered reader
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// wantsTLSUpgrade reports whether a cleartext request asks to switch to TLS (RFC 2817)
+func (s *Server) wantsTLSUpgrade(conn net.Conn, req *Request) (string, bool) {
+	if !s.AllowSTARTTLS || s.TLSConfig == nil {
+		return "", false
+	}
+	if _, ok := conn.(*tls.Conn); ok {
+		return "", false
+	}
+	for _, protocol := range strings.Split(req.Headers["upgrade"], ",") {
+		protocol = strings.TrimSpace(protocol)
+		if strings.HasPrefix(strings.ToUpper(protocol), "TLS/") {
+			return protocol, true
+		}
+	}
+	return "", false
+}
+
+// upgradeToTLS switches the connection to TLS and keeps serving requests over it
+func (s *Server) upgradeToTLS(conn net.Conn, reader *bufio.Reader, protocol string) {
+	err := s.sendResponse(conn, &Response{
+		StatusLine: StatusSwitchingProtocols,
+		Headers: map[string]string{
+			"Upgrade":    protocol,
+			"Connection": "Upgrade",
+		},
+	}, false)
+	if err != nil {
+		fmt.Println("Error sending response:", err)
+		return
+	}
+
+	tlsConn := tls.Server(&bufferedConn{Conn: conn, reader: reader}, s.TLSConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		fmt.Println("Error during TLS upgrade handshake:", err)
+		return
+	}
+
+	fmt.Println("Upgraded connection to TLS:", conn.RemoteAddr())
+	s.handleConnection(tlsConn)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStatusLine(t *testing.T) {
+	if got := StatusLine(418, "I'm a teapot"); got != "HTTP/1.1 418 I'm a teapot" {
+		t.Fatalf("got %q", got)
+	}
+	if got := statusCode(StatusLine(418, "I'm a teapot")); got != 418 {
+		t.Fatalf("got code %d, want 418", got)
+	}
+	if got := statusLineFor(404); got != StatusNotFound {
+		t.Fatalf("got %q, want %q", got, StatusNotFound)
+	}
+}
+
+func TestWithCustomReason(t *testing.T) {
+	reasons := map[int]string{200: "Proceed"}
+	if got := withCustomReason(StatusOK, reasons); got != "HTTP/1.1 200 Proceed" {
+		t.Fatalf("got %q", got)
+	}
+	if got := withCustomReason(StatusNotFound, reasons); got != StatusNotFound {
+		t.Fatalf("status without a custom reason changed to %q", got)
+	}
+}
+
+func TestCustomStatusReasonsOnTheWire(t *testing.T) {
+	s := newTestServer(t)
+	s.CustomStatusReasons = map[int]string{200: "Proceed"}
+	addr := startTestServer(t, s)
+
+	response, _ := roundTrip(t, addr, "GET /echo/hi HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	if !strings.HasSuffix(response.Status, "Proceed") {
+		t.Fatalf("got status %q, want reason Proceed", response.Status)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Handler2 is implemented by handlers that stream their response instead of returning it
+type Handler2 interface {
+	Handle2(req *Request, w ResponseWriter)
+}
+
+// Handler2Func is a function type that implements the Handler2 interface
+type Handler2Func func(req *Request, w ResponseWriter)
+
+// Handle2 calls the handler function
+func (f Handler2Func) Handle2(req *Request, w ResponseWriter) {
+	f(req, w)
+}
+
+// ResponseWriter lets a Handler2 write the response as it is produced
+type ResponseWriter interface {
+	SetStatus(code int)
+	SetHeader(key, value string)
+	AddHeader(key, value string)
+	Write(data []byte) (int, error)
+	Flush() error
+}
+
+// connResponseWriter writes a response straight to the connection, using chunked
+// transfer encoding unless the handler sets Content-Length
+type connResponseWriter struct {
+	writer      *bufio.Writer
+	reasons     map[int]string
+	status      int
+	headers     map[string]string
+	multi       map[string][]string
+	wroteHeader bool
+	chunked     bool
+	head        bool // the request was HEAD, the body is left out
+
+	// flushInterval is how many bytes of the body may wait in the buffer, so the client
+	// gets a steady stream instead of whatever the buffer size happens to be
+	flushInterval int
+	unflushed     int
+	written       int64 // body bytes written, for the access log
+}
+
+// newConnResponseWriter creates a ResponseWriter on top of the connection
+func newConnResponseWriter(w io.Writer, reasons map[int]string) *connResponseWriter {
+	return &connResponseWriter{
+		writer:  bufio.NewWriter(w),
+		reasons: reasons,
+		status:  200,
+		headers: make(map[string]string),
+	}
+}
+
+// SetStatus sets the status code, it has no effect once the body is being written
+func (w *connResponseWriter) SetStatus(code int) {
+	w.status = code
+}
+
+// SetHeader sets a response header, it has no effect once the body is being written
+func (w *connResponseWriter) SetHeader(key, value string) {
+	w.headers[key] = value
+}
+
+// AddHeader adds a value to a header sent once per value, it has no effect once the body
+// is being written
+func (w *connResponseWriter) AddHeader(key, value string) {
+	if w.multi == nil {
+		w.multi = make(map[string][]string)
+	}
+	w.multi[key] = append(w.multi[key], value)
+}
+
+// writeHeader sends the status line and headers
+func (w *connResponseWriter) writeHeader() error {
+	if w.wroteHeader {
+		return nil
+	}
+	w.wroteHeader = true
+
+	if w.headers["Content-Length"] == "" {
+		w.chunked = true
+		w.headers["Transfer-Encoding"] = "chunked"
+	}
+
+	lines := make([]string, 0, 2+len(w.headers))
+	lines = append(lines, withCustomReason(statusLineFor(w.status), w.reasons))
+	for k, v := range w.headers {
+		lines = append(lines, fmt.Sprintf("%s: %s", k, v))
+	}
+	for k, values := range w.multi {
+		for _, v := range values {
+			lines = append(lines, fmt.Sprintf("%s: %s", k, v))
+		}
+	}
+	lines = append(lines, "", "")
+
+	_, err := w.writer.WriteString(strings.Join(lines, "\r\n"))
+	return err
+}
+
+// Write sends part of the body
+func (w *connResponseWriter) Write(data []byte) (int, error) {
+	if err := w.writeHeader(); err != nil {
+		return 0, err
+	}
+	if len(data) == 0 {
+		return 0, nil
+	}
+	w.written += int64(len(data))
+	if w.head {
+		return len(data), nil
+	}
+	if !w.chunked {
+		n, err := w.writer.Write(data)
+		if err != nil {
+			return n, err
+		}
+		return n, w.flushIfDue(n)
+	}
+
+	if _, err := w.writer.WriteString(strconv.FormatInt(int64(len(data)), 16) + "\r\n"); err != nil {
+		return 0, err
+	}
+	n, err := w.writer.Write(data)
+	if err != nil {
+		return n, err
+	}
+	if _, err := w.writer.WriteString("\r\n"); err != nil {
+		return n, err
+	}
+	return n, w.flushIfDue(n)
+}
+
+// flushIfDue counts n more bytes written and flushes once flushInterval bytes are waiting
+func (w *connResponseWriter) flushIfDue(n int) error {
+	w.unflushed += n
+	if w.flushInterval <= 0 || w.unflushed < w.flushInterval {
+		return nil
+	}
+	return w.Flush()
+}
+
+// Flush sends everything written so far to the client
+func (w *connResponseWriter) Flush() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	w.unflushed = 0
+	return w.writer.Flush()
+}
+
+// finish terminates the body and flushes the response
+func (w *connResponseWriter) finish() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	if w.chunked && !w.head {
+		if _, err := w.writer.WriteString("0\r\n\r\n"); err != nil {
+			return err
+		}
+	}
+	return w.writer.Flush()
+}
+
+// writeResponse sends a buffered response through a ResponseWriter
+func writeResponse(w ResponseWriter, response *Response) {
+	w.SetStatus(statusCode(response.StatusLine))
+	for k, v := range response.Headers {
+		if response.Chunked && k == "Content-Length" {
+			continue
+		}
+		w.SetHeader(k, v)
+	}
+	for k, values := range response.MultiHeaders {
+		for _, v := range values {
+			w.AddHeader(k, v)
+		}
+	}
+	// Without a Content-Length the writer sends the body chunked
+	if !response.Chunked {
+		w.SetHeader("Content-Length", strconv.Itoa(len(response.Body)))
+	}
+	w.Write(response.Body)
+	if response.NoBuffer {
+		w.Flush()
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// throttledReader limits how fast the wrapped reader can be read
+type throttledReader struct {
+	reader  io.Reader
+	limiter *rate.Limiter
+}
+
+// newThrottledReader creates a reader delivering at most bytesPerSecond bytes per second
+func newThrottledReader(r io.Reader, bytesPerSecond int64) *throttledReader {
+	return &throttledReader{
+		reader:  r,
+		limiter: rate.NewLimiter(rate.Limit(bytesPerSecond), int(bytesPerSecond)),
+	}
+}
+
+// Read reads no more than the limiter allows, blocking until the tokens are available
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > t.limiter.Burst() {
+		p = p[:t.limiter.Burst()]
+	}
+	n, err := t.reader.Read(p)
+	if n > 0 {
+		if waitErr := t.limiter.WaitN(context.Background(), n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
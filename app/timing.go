@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// ResponseTimingMiddleware reports how long the handlers took in X-Response-Time and,
+// for browser DevTools, in X-Server-Timing
+func ResponseTimingMiddleware() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(req *Request) *Response {
+			started := time.Now()
+			response := next.Handle(req)
+			millis := float64(time.Since(started)) / float64(time.Millisecond)
+
+			if response.Headers == nil {
+				response.Headers = make(map[string]string)
+			}
+			response.Headers["X-Response-Time"] = fmt.Sprintf("%.3fms", millis)
+			response.Headers["X-Server-Timing"] = fmt.Sprintf("handler;dur=%.3f", millis)
+			return response
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestResponseTimingMiddleware(t *testing.T) {
+	handler := ResponseTimingMiddleware()(HandlerFunc(func(req *Request) *Response {
+		time.Sleep(5 * time.Millisecond)
+		return &Response{StatusLine: StatusOK}
+	}))
+
+	response := handler.Handle(&Request{})
+	value := response.Headers["X-Response-Time"]
+	elapsed, err := time.ParseDuration(value)
+	if err != nil || elapsed < 5*time.Millisecond {
+		t.Fatalf("got X-Response-Time %q, want a duration of at least 5ms", value)
+	}
+	if timing := response.Headers["X-Server-Timing"]; timing != "handler;dur="+strings.TrimSuffix(value, "ms") {
+		t.Fatalf("got X-Server-Timing %q for X-Response-Time %q", timing, value)
+	}
+}
+
+func TestResponseTimingMiddlewareTimesEachRequest(t *testing.T) {
+	handler := ResponseTimingMiddleware()(HandlerFunc(func(req *Request) *Response {
+		delay, _ := time.ParseDuration(req.Path)
+		time.Sleep(delay)
+		return &Response{StatusLine: StatusOK, Headers: map[string]string{}}
+	}))
+
+	// A slow and a fast request at the same time each get their own duration
+	var wg sync.WaitGroup
+	results := make([]time.Duration, 2)
+	for i, delay := range []string{"100ms", "1ms"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response := handler.Handle(&Request{Path: delay})
+			results[i], _ = time.ParseDuration(response.Headers["X-Response-Time"])
+		}()
+	}
+	wg.Wait()
+
+	if results[0] < 100*time.Millisecond || results[1] >= 100*time.Millisecond {
+		t.Fatalf("got %s for the slow request and %s for the fast one", results[0], results[1])
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// StartTLS starts the HTTPS server on the specified port with the certificate and key
+// in PEM files, reloaded from them on SIGHUP
+func (s *Server) StartTLS(port, certFile, keyFile string) error {
+	for _, file := range []string{certFile, keyFile} {
+		if _, err := os.Stat(file); err != nil {
+			return fmt.Errorf("failed to read TLS certificate: %w", err)
+		}
+	}
+	if err := s.SetTLSCert(certFile, keyFile); err != nil {
+		return err
+	}
+
+	// Look the certificate up on every handshake, so SetTLSCert can replace it
+	config := s.serverTLSConfig()
+	config.GetCertificate = s.getTLSCert
+	return s.start(port, config)
+}
+
+// StartAutoTLS starts the HTTPS server on port 443 with a certificate for the domain
+// provisioned from Let's Encrypt, answering its TLS-ALPN-01 challenge on that port.
+// Certificates are cached in the user's cache directory
+func (s *Server) StartAutoTLS(domain string) error {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return fmt.Errorf("failed to find the certificate cache directory: %w", err)
+	}
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domain),
+		Cache:      autocert.DirCache(filepath.Join(cacheDir, "http-server-autocert")),
+	}
+
+	config := s.serverTLSConfig()
+	managed := manager.TLSConfig()
+	config.GetCertificate = managed.GetCertificate
+	config.NextProtos = append(config.NextProtos, managed.NextProtos...)
+	return s.start("443", config)
+}
+
+// serverTLSConfig returns a copy of TLSConfig to serve with, or a new config when unset
+func (s *Server) serverTLSConfig() *tls.Config {
+	if s.TLSConfig != nil {
+		return s.TLSConfig.Clone()
+	}
+	return &tls.Config{MinVersion: tls.VersionTLS12}
+}
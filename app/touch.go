@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// fileMetadataUpdate is the JSON body of PATCH /files/{name}
+type fileMetadataUpdate struct {
+	Mtime string `json:"mtime"`
+}
+
+// handleFileTouch handles PATCH /files/{name}, which sets the file's modification time
+// without changing its content
+func (s *Server) handleFileTouch(req *Request, fullPath string) *Response {
+	var update fileMetadataUpdate
+	if err := json.Unmarshal(req.Body, &update); err != nil {
+		return textResponse(StatusBadRequest, "invalid JSON body: "+err.Error())
+	}
+	mtime, err := time.Parse(time.RFC3339, update.Mtime)
+	if err != nil {
+		return textResponse(StatusBadRequest, "mtime must be an RFC 3339 timestamp")
+	}
+
+	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
+		return &Response{StatusLine: StatusNotFound, Headers: make(map[string]string)}
+	} else if err != nil {
+		fmt.Println("Error checking file existence:", err)
+		return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+	}
+
+	if err := os.Chtimes(fullPath, mtime, mtime); err != nil {
+		fmt.Println("Error updating file times:", err)
+		return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+	}
+	s.invalidateDirs(fullPath)
+
+	return &Response{StatusLine: StatusNoContent, Headers: make(map[string]string)}
+}
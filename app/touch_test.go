@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// patchMtime sends PATCH /files/{name} with a JSON body
+func patchMtime(t *testing.T, addr, name, body string) int {
+	t.Helper()
+	response, _ := roundTrip(t, addr, fmt.Sprintf("PATCH /files/%s HTTP/1.1\r\nHost: localhost\r\nContent-Type: application/json\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s", name, len(body), body))
+	return response.StatusCode
+}
+
+func TestPatchFileMtime(t *testing.T) {
+	s := newTestServer(t)
+	if err := os.WriteFile(filepath.Join(s.Directory, "a.txt"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	addr := startTestServer(t, s)
+
+	// Stat the file first so a stale cached stat would show up
+	roundTrip(t, addr, "GET /files/a.txt?info=1 HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+
+	if status := patchMtime(t, addr, "a.txt", `{"mtime": "2024-01-01T00:00:00Z"}`); status != 204 {
+		t.Fatalf("got status %d, want 204", status)
+	}
+
+	_, body := roundTrip(t, addr, "GET /files/a.txt?info=1 HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	var info fileInfoJSON
+	if err := json.Unmarshal(body, &info); err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC); !info.Mtime.Equal(want) {
+		t.Fatalf("got mtime %s, want %s", info.Mtime, want)
+	}
+	if content, _ := os.ReadFile(filepath.Join(s.Directory, "a.txt")); string(content) != "content" {
+		t.Fatalf("content changed to %q", content)
+	}
+}
+
+func TestPatchFileMtimeErrors(t *testing.T) {
+	s := newTestServer(t)
+	if err := os.WriteFile(filepath.Join(s.Directory, "a.txt"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	addr := startTestServer(t, s)
+
+	tests := []struct {
+		name, body string
+		want       int
+	}{
+		{"a.txt", `{"mtime": "yesterday"}`, 400},
+		{"a.txt", `not json`, 400},
+		{"missing.txt", `{"mtime": "2024-01-01T00:00:00Z"}`, 404},
+	}
+	for _, tt := range tests {
+		if status := patchMtime(t, addr, tt.name, tt.body); status != tt.want {
+			t.Errorf("%s %s: got status %d, want %d", tt.name, tt.body, status, tt.want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// connRateWindow is how far back the UDP health check looks for TCP connections, in seconds
+const connRateWindow = 60
+
+// connRate counts accepted TCP connections per second over the last connRateWindow seconds
+type connRate struct {
+	mu      sync.Mutex
+	buckets [connRateWindow]int64
+	seconds [connRateWindow]int64 // the unix second each bucket counts
+	total   int64                 // connections accepted since the server started
+}
+
+// record counts an accepted connection
+func (c *connRate) record() {
+	now := time.Now().Unix()
+	i := now % connRateWindow
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.seconds[i] != now {
+		c.seconds[i] = now
+		c.buckets[i] = 0
+	}
+	c.buckets[i]++
+	c.total++
+}
+
+// recent returns the connections accepted in the window and since the server started
+func (c *connRate) recent() (int64, int64) {
+	now := time.Now().Unix()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var sum int64
+	for i, second := range c.seconds {
+		if now-second < connRateWindow {
+			sum += c.buckets[i]
+		}
+	}
+	return sum, c.total
+}
+
+// StartUDPHealthCheck answers every datagram on the UDP port with OK, or DEGRADED when the
+// server had TCP connections but none in the last minute. It serves in the background until
+// the server's Context is cancelled
+func (s *Server) StartUDPHealthCheck(port int) error {
+	conn, err := net.ListenPacket("udp", fmt.Sprintf("0.0.0.0:%d", port))
+	if err != nil {
+		return fmt.Errorf("failed to bind to UDP port %d: %w", port, err)
+	}
+	fmt.Println("Starting UDP health check on port", port)
+
+	ctx := s.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			_, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				if ctx.Err() == nil {
+					fmt.Println("Error reading UDP health check:", err)
+				}
+				return
+			}
+			if _, err := conn.WriteTo([]byte(s.healthStatus()), addr); err != nil {
+				fmt.Println("Error answering UDP health check:", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// healthStatus reports whether connections dried up since the server started serving
+func (s *Server) healthStatus() string {
+	recent, total := s.connRate.recent()
+	if total > 0 && recent == 0 {
+		return "DEGRADED\n"
+	}
+	return "OK\n"
+}
@@ -0,0 +1,28 @@
+package main
+
+// upgradeInsecureMiddleware handles Upgrade-Insecure-Requests on plain HTTP requests: the
+// response varies on it, and with ForceHTTPS the client is redirected to HTTPS. The redirect
+// is a 307 so browsers repeat POST requests with their body
+func (s *Server) upgradeInsecureMiddleware(next Handler) Handler {
+	return HandlerFunc(func(req *Request) *Response {
+		if req.TLS != nil || req.Headers["upgrade-insecure-requests"] != "1" {
+			return next.Handle(req)
+		}
+
+		if s.ForceHTTPS && req.Headers["host"] != "" {
+			response := &Response{
+				StatusLine: StatusTemporaryRedirect,
+				Headers:    map[string]string{"Location": "https://" + req.Headers["host"] + req.RequestURI()},
+			}
+			addVary(response, "Upgrade-Insecure-Requests")
+			return response
+		}
+
+		response := next.Handle(req)
+		if response.Headers == nil {
+			response.Headers = make(map[string]string)
+		}
+		addVary(response, "Upgrade-Insecure-Requests")
+		return response
+	})
+}
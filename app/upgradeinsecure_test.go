@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUpgradeInsecureRedirectsPost(t *testing.T) {
+	s := newTestServer(t)
+	s.ForceHTTPS = true
+	addr := startTestServer(t, s)
+
+	response, _ := roundTrip(t, addr, "POST /files/a.txt?x=1 HTTP/1.1\r\nHost: example.com\r\nUpgrade-Insecure-Requests: 1\r\nContent-Length: 5\r\nConnection: close\r\n\r\nhello")
+	if response.StatusCode != 307 {
+		t.Fatalf("got status %d, want 307", response.StatusCode)
+	}
+	if location := response.Header.Get("Location"); location != "https://example.com/files/a.txt?x=1" {
+		t.Errorf("got Location %q", location)
+	}
+	if vary := response.Header.Get("Vary"); !strings.Contains(vary, "Upgrade-Insecure-Requests") {
+		t.Errorf("got Vary %q, want Upgrade-Insecure-Requests", vary)
+	}
+
+	// The redirected upload must not have been written
+	expectStatus(t, s.handleFiles(fileRequest("GET", "a.txt", "")), 404)
+}
+
+func TestUpgradeInsecureWithoutForceHTTPS(t *testing.T) {
+	s := newTestServer(t)
+	addr := startTestServer(t, s)
+
+	response, _ := roundTrip(t, addr, "GET /echo/abc HTTP/1.1\r\nHost: example.com\r\nUpgrade-Insecure-Requests: 1\r\nConnection: close\r\n\r\n")
+	if response.StatusCode != 200 {
+		t.Fatalf("got status %d, want 200", response.StatusCode)
+	}
+	if vary := response.Header.Get("Vary"); !strings.Contains(vary, "Upgrade-Insecure-Requests") {
+		t.Errorf("got Vary %q, want Upgrade-Insecure-Requests", vary)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// uploadProgress tracks how much of a request body has been received
+type uploadProgress struct {
+	Received  atomic.Int64
+	Total     int64
+	StartedAt time.Time
+}
+
+// ProgressReader wraps a body reader and counts the bytes read through it
+type ProgressReader struct {
+	Reader   io.Reader
+	progress *uploadProgress
+}
+
+// Read reads from the wrapped reader and records the progress
+func (p *ProgressReader) Read(buf []byte) (int, error) {
+	n, err := p.Reader.Read(buf)
+	p.progress.Received.Add(int64(n))
+	return n, err
+}
+
+// trackUpload registers an upload and returns a reader recording its progress and
+// a function removing it once the body is read or the connection fails
+func (s *Server) trackUpload(id string, total int64, r io.Reader) (*ProgressReader, func()) {
+	progress := &uploadProgress{
+		Total:     total,
+		StartedAt: time.Now(),
+	}
+	s.uploads.Store(id, progress)
+	return &ProgressReader{Reader: r, progress: progress}, func() {
+		s.uploads.CompareAndDelete(id, progress)
+	}
+}
+
+// uploadID returns the key an upload is tracked under
+func uploadID(headers map[string]string, remoteAddr string) string {
+	if id := headers["upload-id"]; id != "" {
+		return id
+	}
+	return remoteAddr
+}
+
+// handleDebugUploads handles the /debug/uploads endpoint listing uploads in progress
+func (s *Server) handleDebugUploads(req *Request) *Response {
+	if response := s.requireAdmin(req); response != nil {
+		return response
+	}
+
+	type uploadStatus struct {
+		Received  int64     `json:"received"`
+		Total     int64     `json:"total"`
+		StartedAt time.Time `json:"started_at"`
+	}
+
+	uploads := make(map[string]uploadStatus)
+	s.uploads.Range(func(key, value any) bool {
+		progress := value.(*uploadProgress)
+		uploads[key.(string)] = uploadStatus{
+			Received:  progress.Received.Load(),
+			Total:     progress.Total,
+			StartedAt: progress.StartedAt,
+		}
+		return true
+	})
+
+	body, err := json.Marshal(uploads)
+	if err != nil {
+		fmt.Println("Error encoding uploads:", err)
+		return &Response{
+			StatusLine: StatusInternalServerError,
+			Headers:    make(map[string]string),
+		}
+	}
+
+	return &Response{
+		StatusLine: StatusOK,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       body,
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+)
+
+// vcdiffMagic starts every VCDIFF (RFC 3284) delta, followed by a zero header indicator
+var vcdiffMagic = []byte{0xD6, 0xC3, 0xC4, 0x00, 0x00}
+
+// Instructions of the default code table used by the encoder, both take an explicit size
+const (
+	vcdiffAdd  = 1  // ADD, size 0: the size follows in the instructions section
+	vcdiffCopy = 19 // COPY in mode VCD_SELF, size 0: the size follows in the instructions section
+)
+
+// vcdiffBlockSize is the length of the source blocks the encoder looks for in the target
+const vcdiffBlockSize = 16
+
+// vcdiffEncode returns a VCDIFF delta turning source into target, in a single window
+// that copies from the source and adds everything else
+func vcdiffEncode(source, target []byte) []byte {
+	// Index the source by its aligned blocks
+	blocks := make(map[string]int, len(source)/vcdiffBlockSize)
+	for i := 0; i+vcdiffBlockSize <= len(source); i += vcdiffBlockSize {
+		block := string(source[i : i+vcdiffBlockSize])
+		if _, ok := blocks[block]; !ok {
+			blocks[block] = i
+		}
+	}
+
+	var data, instructions, addresses []byte
+	pending := 0 // start of target bytes not yet encoded
+	flushAdd := func(end int) {
+		if end > pending {
+			instructions = append(instructions, vcdiffAdd)
+			instructions = appendVarint(instructions, end-pending)
+			data = append(data, target[pending:end]...)
+		}
+	}
+
+	for i := 0; i+vcdiffBlockSize <= len(target); {
+		start, ok := blocks[string(target[i:i+vcdiffBlockSize])]
+		if !ok {
+			i++
+			continue
+		}
+
+		// Grow the match in both directions
+		end := start + vcdiffBlockSize
+		j := i + vcdiffBlockSize
+		for end < len(source) && j < len(target) && source[end] == target[j] {
+			end++
+			j++
+		}
+		from := i
+		for start > 0 && from > pending && source[start-1] == target[from-1] {
+			start--
+			from--
+		}
+
+		flushAdd(from)
+		instructions = append(instructions, vcdiffCopy)
+		instructions = appendVarint(instructions, end-start)
+		addresses = appendVarint(addresses, start)
+		pending, i = j, j
+	}
+	flushAdd(len(target))
+
+	// The delta encoding, from the target window length to the end of the addresses
+	var encoding []byte
+	encoding = appendVarint(encoding, len(target))
+	encoding = append(encoding, 0) // no compressed sections
+	encoding = appendVarint(encoding, len(data))
+	encoding = appendVarint(encoding, len(instructions))
+	encoding = appendVarint(encoding, len(addresses))
+	encoding = append(encoding, data...)
+	encoding = append(encoding, instructions...)
+	encoding = append(encoding, addresses...)
+
+	delta := append([]byte{}, vcdiffMagic...)
+	delta = append(delta, 0x01) // VCD_SOURCE: the window copies from the source
+	delta = appendVarint(delta, len(source))
+	delta = appendVarint(delta, 0)
+	delta = appendVarint(delta, len(encoding))
+	return append(delta, encoding...)
+}
+
+// appendVarint appends an RFC 3284 integer: base 128, most significant digit first,
+// with the high bit set on every byte but the last
+func appendVarint(buf []byte, n int) []byte {
+	var digits [10]byte
+	i := len(digits) - 1
+	digits[i] = byte(n & 0x7f)
+	for n >>= 7; n > 0; n >>= 7 {
+		i--
+		digits[i] = byte(n&0x7f) | 0x80
+	}
+	return append(buf, digits[i:]...)
+}
+
+// Limits of the delta base store
+const (
+	maxDeltaBaseBytes = 64 << 20
+	maxDeltaBaseFile  = 8 << 20
+)
+
+// deltaBases keeps the content of recently served file versions by path and ETag, so a
+// client holding an old version can be sent a delta from it
+type deltaBases struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // of *deltaBase, most recent first
+	size    int
+}
+
+// deltaBase is a stored file version
+type deltaBase struct {
+	key     string
+	content []byte
+}
+
+// get returns the content of the file version, if it is still stored
+func (d *deltaBases) get(path, etag string) ([]byte, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	element, ok := d.entries[deltaBaseKey(path, etag)]
+	if !ok {
+		return nil, false
+	}
+	d.order.MoveToFront(element)
+	return element.Value.(*deltaBase).content, true
+}
+
+// put stores a file version, evicting the least recently used ones beyond the size limit
+func (d *deltaBases) put(path, etag string, content []byte) {
+	if len(content) > maxDeltaBaseFile {
+		return
+	}
+	key := deltaBaseKey(path, etag)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.entries == nil {
+		d.entries = make(map[string]*list.Element)
+		d.order = list.New()
+	}
+	if element, ok := d.entries[key]; ok {
+		d.order.MoveToFront(element)
+		return
+	}
+
+	d.entries[key] = d.order.PushFront(&deltaBase{key: key, content: content})
+	d.size += len(content)
+	for d.size > maxDeltaBaseBytes {
+		oldest := d.order.Remove(d.order.Back()).(*deltaBase)
+		delete(d.entries, oldest.key)
+		d.size -= len(oldest.content)
+	}
+}
+
+// clear drops every stored file version
+func (d *deltaBases) clear() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.entries = nil
+	d.order = nil
+	d.size = 0
+}
+
+// deltaBaseKey identifies a version of a file
+func deltaBaseKey(path, etag string) string {
+	return path + "\x00" + etag
+}
+
+// wantsVCDIFF reports whether the client accepts VCDIFF deltas through A-IM
+func wantsVCDIFF(req *Request) bool {
+	for _, im := range strings.Split(req.Headers["a-im"], ",") {
+		name, _, _ := strings.Cut(im, ";")
+		if strings.EqualFold(strings.TrimSpace(name), "vcdiff") {
+			return true
+		}
+	}
+	return false
+}
+
+// deltaResponse returns a 226 IM Used response with the delta from the client's version
+// of the file, or nil when the client didn't ask for one, its version isn't stored or
+// the delta wouldn't be smaller than the file
+func (s *Server) deltaResponse(req *Request, fullPath, etag string, content []byte) *Response {
+	baseETag := req.Headers["if-none-match"]
+	if !wantsVCDIFF(req) || baseETag == "" || baseETag == etag {
+		return nil
+	}
+	base, ok := s.deltaBases.get(fullPath, baseETag)
+	if !ok {
+		return nil
+	}
+
+	delta := vcdiffEncode(base, content)
+	if len(delta) >= len(content) {
+		return nil
+	}
+	return &Response{
+		StatusLine: StatusIMUsed,
+		Headers: map[string]string{
+			"Content-Type": "text/vcdiff",
+			"IM":           "vcdiff",
+			"ETag":         etag,
+			"Delta-Base":   baseETag,
+		},
+		Body: delta,
+	}
+}
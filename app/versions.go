@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// versionsDirName is the directory under the served directory holding old file versions
+const versionsDirName = ".versions"
+
+// versionTimestampFormat names the versions, sorting them oldest first
+const versionTimestampFormat = "20060102T150405.000000000Z"
+
+// fileVersion describes a stored version in GET /files/{name}?versions=1
+type fileVersion struct {
+	Version string    `json:"version"`
+	Size    int64     `json:"size"`
+	Mtime   time.Time `json:"mtime"`
+}
+
+// versionsDir returns the directory holding the old versions of a file
+func (s *Server) versionsDir(fullPath string) string {
+	relative, err := filepath.Rel(s.Directory, fullPath)
+	if err != nil {
+		relative = filepath.Base(fullPath)
+	}
+	return filepath.Join(s.Directory, versionsDirName, relative)
+}
+
+// saveVersion keeps the content of a file about to be replaced in its versions directory,
+// dropping the oldest versions beyond MaxVersionsPerFile. The file itself stays in place
+// until the new content is renamed over it, so it is never missing in between
+func (s *Server) saveVersion(fullPath string) error {
+	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	dir := s.versionsDir(fullPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	versionPath := filepath.Join(dir, time.Now().UTC().Format(versionTimestampFormat))
+	// A hard link is free, and safe since files are only ever replaced, never written in place
+	if err := os.Link(fullPath, versionPath); err != nil {
+		content, err := os.ReadFile(fullPath)
+		if err != nil {
+			return err
+		}
+		if err := replaceFile(versionPath, content); err != nil {
+			return err
+		}
+	}
+	s.invalidateDirs(versionPath)
+
+	versions, err := s.listVersions(fullPath)
+	if err != nil {
+		return err
+	}
+	for len(versions) > s.MaxVersionsPerFile {
+		oldest := filepath.Join(dir, versions[0].Version)
+		if err := os.Remove(oldest); err != nil {
+			return err
+		}
+		s.invalidateDirs(oldest)
+		versions = versions[1:]
+	}
+	return nil
+}
+
+// listVersions returns the stored versions of a file, oldest first
+func (s *Server) listVersions(fullPath string) ([]fileVersion, error) {
+	entries, err := os.ReadDir(s.versionsDir(fullPath))
+	if os.IsNotExist(err) {
+		return []fileVersion{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	versions := make([]fileVersion, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		versions = append(versions, fileVersion{
+			Version: entry.Name(),
+			Size:    info.Size(),
+			Mtime:   info.ModTime().UTC(),
+		})
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+	return versions, nil
+}
+
+// handleFileVersions handles GET /files/{name}?versions=1, listing the stored versions as JSON
+func (s *Server) handleFileVersions(fullPath string) *Response {
+	versions, err := s.listVersions(fullPath)
+	if err != nil {
+		fmt.Println("Error listing versions:", err)
+		return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+	}
+	content, err := json.Marshal(map[string]any{"versions": versions})
+	if err != nil {
+		fmt.Println("Error encoding versions:", err)
+		return &Response{StatusLine: StatusInternalServerError, Headers: make(map[string]string)}
+	}
+	return &Response{
+		StatusLine: StatusOK,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       content,
+	}
+}
+
+// handleFileVersion handles GET /files/{name}?version=<timestamp>, downloading a stored version
+func (s *Server) handleFileVersion(req *Request, fullPath string) *Response {
+	version := req.Query.Get("version")
+	if version == "" || filepath.Base(version) != version || version == "." || version == ".." {
+		return textResponse(StatusBadRequest, fmt.Sprintf("invalid version %q", version))
+	}
+
+	response := s.handleFileDownload(req, filepath.Join(s.versionsDir(fullPath), version))
+	if response.StatusLine == StatusOK {
+		response.Headers["Content-Disposition"] = fmt.Sprintf("attachment; filename=%s", filepath.Base(fullPath))
+	}
+	return response
+}
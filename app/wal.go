@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// walFileName is the write-ahead log under the served directory
+const walFileName = ".wal"
+
+// walEntry is a line of the write-ahead log. A write is followed by a commit once the file
+// is complete, or an abort once the partial file was removed
+type walEntry struct {
+	Action string `json:"action"`
+	Path   string `json:"path,omitempty"`
+	Size   int    `json:"size,omitempty"`
+	ID     string `json:"id"`
+}
+
+// writeAheadLog records uploads before they are written, so the files of uploads cut short
+// by a crash can be deleted on the next start. A nil log records nothing
+type writeAheadLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// openWAL opens the log at path. With cleanUp it first deletes the files under directory of
+// the writes the log never committed and starts a new, empty log; without it appends to the
+// log of the processes sharing it, whose writes may still be in progress
+func openWAL(path, directory string, cleanUp bool) (*writeAheadLog, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if cleanUp {
+		if err := recoverWAL(path, directory); err != nil {
+			return nil, fmt.Errorf("failed to recover write-ahead log: %w", err)
+		}
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open write-ahead log: %w", err)
+	}
+	return &writeAheadLog{file: file}, nil
+}
+
+// recoverWAL deletes the files of the uncommitted writes in the log. Entries for files
+// outside directory are ignored, whoever wrote them wasn't this server
+func recoverWAL(path, directory string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var pending []walEntry
+	done := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry walEntry
+		// The last line may be cut short by the crash itself
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if entry.Action == "write" {
+			pending = append(pending, entry)
+		} else {
+			done[entry.ID] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	root, err := filepath.Abs(directory)
+	if err != nil {
+		return err
+	}
+	for _, entry := range pending {
+		if done[entry.ID] {
+			continue
+		}
+		target, err := filepath.Abs(entry.Path)
+		if err != nil {
+			return err
+		}
+		if rel, err := filepath.Rel(root, target); err != nil || !filepath.IsLocal(rel) {
+			fmt.Println("Ignoring write-ahead log entry outside the directory:", entry.Path)
+			continue
+		}
+		if err := os.Remove(entry.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		fmt.Println("Removed partial upload:", entry.Path)
+	}
+	return nil
+}
+
+// begin records a write of size bytes to path, returning its ID
+func (l *writeAheadLog) begin(path string, size int) (string, error) {
+	if l == nil {
+		return "", nil
+	}
+	id := newJobID()
+	return id, l.append(walEntry{Action: "write", Path: path, Size: size, ID: id})
+}
+
+// commit records that the write completed
+func (l *writeAheadLog) commit(id string) error {
+	if l == nil {
+		return nil
+	}
+	return l.append(walEntry{Action: "commit", ID: id})
+}
+
+// abort records that the write failed and its partial file was removed
+func (l *writeAheadLog) abort(id string) error {
+	if l == nil {
+		return nil
+	}
+	return l.append(walEntry{Action: "abort", ID: id})
+}
+
+// append writes an entry and waits until it is on disk
+func (l *writeAheadLog) append(entry walEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
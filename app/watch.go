@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Bounds of the timeout of GET /files/{name}?watch=1, in seconds
+const (
+	defaultWatchTimeout = 30
+	maxWatchTimeout     = 300
+)
+
+// handleFileWatch handles GET /files/{name}?watch=1&timeout=30, which waits until the file's
+// modification time changes and returns its metadata, or 304 Not Modified on timeout
+func (s *Server) handleFileWatch(req *Request, fullPath string) *Response {
+	timeout := defaultWatchTimeout
+	if req.Query.Has("timeout") {
+		seconds, err := strconv.Atoi(req.Query.Get("timeout"))
+		if err != nil || seconds < 1 || seconds > maxWatchTimeout {
+			return textResponse(StatusBadRequest, fmt.Sprintf("timeout must be between 1 and %d seconds", maxWatchTimeout))
+		}
+		timeout = seconds
+	}
+
+	if s.MaxWatchers > 0 && s.watchers.Add(1) > int64(s.MaxWatchers) {
+		s.watchers.Add(-1)
+		return &Response{
+			StatusLine: StatusServiceUnavailable,
+			Headers:    map[string]string{"Retry-After": "1"},
+		}
+	}
+	defer s.watchers.Add(-1)
+
+	info, err := os.Stat(fullPath)
+	if err != nil || info.IsDir() {
+		return &Response{StatusLine: StatusNotFound, Headers: make(map[string]string)}
+	}
+	mtime := info.ModTime()
+
+	parent := s.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(parent, time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	interval := s.WatchInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return &Response{StatusLine: StatusNotModified, Headers: make(map[string]string)}
+		case <-ticker.C:
+			info, err := os.Stat(fullPath)
+			if err != nil {
+				return &Response{StatusLine: StatusNotFound, Headers: make(map[string]string)}
+			}
+			if !info.ModTime().Equal(mtime) {
+				s.invalidateDirs(fullPath)
+				return s.handleFileInfo(fullPath)
+			}
+		}
+	}
+}
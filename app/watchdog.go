@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"runtime"
+	"time"
+)
+
+// defaultWatchdogTimeout is used when EnableWatchdog is set without a WatchdogTimeout
+const defaultWatchdogTimeout = 30 * time.Second
+
+// goroutineHeader matches the first line of a goroutine in a runtime.Stack dump,
+// e.g. "goroutine 42 [sync.Mutex.Lock, 2 minutes]:"
+var goroutineHeader = regexp.MustCompile(`^goroutine (\d+) \[([^,\]]+)`)
+
+// watchdogSample is the state a goroutine was first seen in
+type watchdogSample struct {
+	signature string
+	since     time.Time
+	reported  bool
+}
+
+// runWatchdog samples the stacks of the goroutines serving connections and reports the ones
+// blocked in the same call for longer than WatchdogTimeout
+func (s *Server) runWatchdog() {
+	timeout := s.WatchdogTimeout
+	if timeout <= 0 {
+		timeout = defaultWatchdogTimeout
+	}
+	ctx := s.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	ticker := time.NewTicker(timeout / 4)
+	defer ticker.Stop()
+
+	seen := make(map[string]*watchdogSample)
+	buf := make([]byte, 1<<20)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Grow the buffer until the dump of every goroutine fits
+		n := runtime.Stack(buf, true)
+		for n == len(buf) {
+			buf = make([]byte, 2*len(buf))
+			n = runtime.Stack(buf, true)
+		}
+
+		now := time.Now()
+		current := make(map[string]*watchdogSample)
+		for _, stack := range bytes.Split(buf[:n], []byte("\n\n")) {
+			id, signature, ok := blockedConnectionGoroutine(stack)
+			if !ok {
+				continue
+			}
+
+			sample := seen[id]
+			if sample == nil || sample.signature != signature {
+				sample = &watchdogSample{signature: signature, since: now}
+			}
+			current[id] = sample
+
+			if !sample.reported && now.Sub(sample.since) > timeout {
+				sample.reported = true
+				fmt.Printf("Watchdog: goroutine %s stuck for %s:\n%s\n", id, now.Sub(sample.since).Round(time.Second), stack)
+				if s.WatchdogKillOnStuck {
+					fmt.Println("Watchdog: exiting")
+					os.Exit(1)
+				}
+			}
+		}
+		seen = current
+	}
+}
+
+// blockedConnectionGoroutine returns the ID and a signature of the goroutine when it serves a
+// connection and is blocked on something other than network I/O, which has its own deadlines
+func blockedConnectionGoroutine(stack []byte) (string, string, bool) {
+	header, frames, _ := bytes.Cut(stack, []byte("\n"))
+	match := goroutineHeader.FindSubmatch(header)
+	if match == nil || string(match[2]) == "running" || string(match[2]) == "runnable" || string(match[2]) == "IO wait" {
+		return "", "", false
+	}
+	if !bytes.Contains(frames, []byte("main.(*Server).handleConnection")) {
+		return "", "", false
+	}
+	return string(match[1]), string(match[2]) + "\n" + string(frames), true
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ConnHandler is implemented by handlers that take over the connection, such as WebSocket
+// endpoints. The connection is closed once HandleConn returns
+type ConnHandler interface {
+	HandleConn(req *Request, conn net.Conn)
+}
+
+// ConnHandlerFunc is a function type that implements the ConnHandler interface
+type ConnHandlerFunc func(req *Request, conn net.Conn)
+
+// HandleConn calls the handler function
+func (f ConnHandlerFunc) HandleConn(req *Request, conn net.Conn) {
+	f(req, conn)
+}
+
+// webSocketGUID is appended to the client's key to compute Sec-WebSocket-Accept (RFC 6455)
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxWebSocketMessage bounds a message assembled from its frames
+const maxWebSocketMessage = 16 << 20
+
+// WebSocket frame opcodes
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// WebSocket close status codes
+const (
+	wsCloseNormal        = 1000
+	wsCloseProtocolError = 1002
+	wsCloseTooBig        = 1009
+)
+
+// errWebSocketProtocol is returned when the client breaks the WebSocket framing rules
+var errWebSocketProtocol = errors.New("websocket protocol error")
+
+// WebSocketConn is a WebSocket connection after the opening handshake
+type WebSocketConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	writeMu sync.Mutex // pongs and close frames are written while reading
+	closed  bool
+}
+
+// WebSocketUpgrade checks the request is a WebSocket opening handshake and answers it with
+// 101 Switching Protocols. On error nothing has been written to conn yet
+func WebSocketUpgrade(req *Request, conn net.Conn) (*WebSocketConn, error) {
+	if req.Method != "GET" {
+		return nil, errors.New("websocket handshake must be a GET request")
+	}
+	if !headerHasToken(req.Headers["upgrade"], "websocket") {
+		return nil, errors.New("missing Upgrade: websocket header")
+	}
+	if !headerHasToken(req.Headers["connection"], "upgrade") {
+		return nil, errors.New("missing Connection: Upgrade header")
+	}
+	if req.Headers["sec-websocket-version"] != "13" {
+		return nil, fmt.Errorf("unsupported websocket version %q", req.Headers["sec-websocket-version"])
+	}
+	key := req.Headers["sec-websocket-key"]
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, errors.New("invalid Sec-WebSocket-Key header")
+	}
+
+	sum := sha1.Sum([]byte(key + webSocketGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(response)); err != nil {
+		return nil, err
+	}
+	return &WebSocketConn{conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// headerHasToken reports whether a comma-separated header value lists the token
+func headerHasToken(value, token string) bool {
+	for _, item := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(item), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadMessage returns the next text or binary message, assembled from its fragments.
+// Pings are answered along the way. It returns io.EOF once the client closed the connection
+func (ws *WebSocketConn) ReadMessage() ([]byte, error) {
+	var message []byte
+	fragmented := false
+	for {
+		fin, opcode, payload, err := ws.readFrame()
+		if err == errWebSocketProtocol {
+			ws.closeWith(wsCloseProtocolError)
+			return nil, err
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case wsPing:
+			if err := ws.writeFrame(wsPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			ws.closeWith(wsCloseNormal)
+			return nil, io.EOF
+		case wsText, wsBinary:
+			if fragmented {
+				ws.closeWith(wsCloseProtocolError)
+				return nil, errWebSocketProtocol
+			}
+		case wsContinuation:
+			if !fragmented {
+				ws.closeWith(wsCloseProtocolError)
+				return nil, errWebSocketProtocol
+			}
+		default:
+			ws.closeWith(wsCloseProtocolError)
+			return nil, errWebSocketProtocol
+		}
+
+		if len(message)+len(payload) > maxWebSocketMessage {
+			ws.closeWith(wsCloseTooBig)
+			return nil, errors.New("websocket message too large")
+		}
+		message = append(message, payload...)
+		if fin {
+			return message, nil
+		}
+		fragmented = true
+	}
+}
+
+// WriteMessage sends a message in a single frame, as text if it is valid UTF-8 and as
+// binary otherwise
+func (ws *WebSocketConn) WriteMessage(data []byte) error {
+	opcode := byte(wsBinary)
+	if utf8.Valid(data) {
+		opcode = wsText
+	}
+	return ws.writeFrame(opcode, data)
+}
+
+// Close sends a close frame and closes the connection
+func (ws *WebSocketConn) Close() error {
+	ws.closeWith(wsCloseNormal)
+	return ws.conn.Close()
+}
+
+// closeWith sends a close frame with the status code, unless one was sent already
+func (ws *WebSocketConn) closeWith(code uint16) {
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+	if !ws.closed {
+		ws.closed = true
+		ws.writeLocked(wsClose, binary.BigEndian.AppendUint16(nil, code))
+	}
+}
+
+// readFrame reads a single frame, unmasking its payload. Clients must mask every frame and
+// control frames can't be fragmented or carry more than 125 bytes
+func (ws *WebSocketConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(ws.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+	if header[0]&0x70 != 0 || !masked {
+		return false, 0, nil, errWebSocketProtocol
+	}
+	if opcode >= wsClose && (!fin || length > 125) {
+		return false, 0, nil, errWebSocketProtocol
+	}
+
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(ws.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(ws.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if length > maxWebSocketMessage {
+		return false, 0, nil, errors.New("websocket frame too large")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(ws.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(ws.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// writeFrame sends a single frame, failing once the close frame was sent
+func (ws *WebSocketConn) writeFrame(opcode byte, payload []byte) error {
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+	if ws.closed {
+		return net.ErrClosed
+	}
+	return ws.writeLocked(opcode, payload)
+}
+
+// writeLocked sends a single unmasked frame, as servers do. The caller holds writeMu
+func (ws *WebSocketConn) writeLocked(opcode byte, payload []byte) error {
+	frame := []byte{0x80 | opcode}
+	switch {
+	case len(payload) <= 125:
+		frame = append(frame, byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	frame = append(frame, payload...)
+	_, err := ws.conn.Write(frame)
+	return err
+}
+
+// handleWebSocketEcho handles /ws/echo, sending every message back to the client
+func (s *Server) handleWebSocketEcho(req *Request, conn net.Conn) {
+	ws, err := WebSocketUpgrade(req, conn)
+	if err != nil {
+		fmt.Println("Rejected WebSocket handshake:", err)
+		s.sendResponse(conn, &Response{
+			StatusLine: StatusBadRequest,
+			Headers:    map[string]string{"Connection": "close", "Sec-WebSocket-Version": "13"},
+			Body:       []byte(err.Error()),
+		}, false)
+		return
+	}
+	defer ws.Close()
+
+	for {
+		message, err := ws.ReadMessage()
+		if err != nil {
+			if err != io.EOF {
+				fmt.Println("Error reading WebSocket message:", err)
+			}
+			return
+		}
+		if err := ws.WriteMessage(message); err != nil {
+			fmt.Println("Error writing WebSocket message:", err)
+			return
+		}
+	}
+}
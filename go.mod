@@ -1,3 +1,28 @@
 module github.com/codecrafters-io/http-server-starter-go
 
 go 1.24.0
+
+require (
+	github.com/alicebob/miniredis/v2 v2.36.1
+	github.com/quic-go/quic-go v0.59.1
+	github.com/redis/go-redis/v9 v9.14.1
+	github.com/sergi/go-diff v1.4.0
+	github.com/tdewolff/minify/v2 v2.24.8
+	github.com/xeipuuv/gojsonschema v1.2.0
+	golang.org/x/crypto v0.41.0
+	golang.org/x/sys v0.37.0
+	golang.org/x/time v0.14.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/quic-go/qpack v0.6.0 // indirect
+	github.com/tdewolff/parse/v2 v2.8.5 // indirect
+	github.com/xeipuuv/gojsonpointer v0.0.0-20180127040702-4e3ac2762d5f // indirect
+	github.com/xeipuuv/gojsonreference v0.0.0-20180127040603-bd5ef7bd5415 // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
+	golang.org/x/net v0.43.0 // indirect
+	golang.org/x/text v0.28.0 // indirect
+)